@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/thebluefowl/burrow/internal/agent"
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/keyring"
+)
+
+var (
+	agentSocket string
+	agentTTL    time.Duration
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Keep the unlocked config in memory for other burrow commands",
+	Long: `Unlocks the config once and serves key operations (deriving data keys, opening
+envelopes, signing) over a Unix socket only the current user can access. The
+master key never leaves the agent and is kept in locked memory where the OS
+allows; the age identities and the signing key are not locked and may be
+swapped to disk.
+
+Commands run with BURROW_AGENT_SOCK set use the agent instead of prompting for
+the master password. The agent exits after --ttl or on interrupt.
+
+  burrow agent --ttl 2h &
+  export BURROW_AGENT_SOCK=<socket path printed by the agent>`,
+	Args: cobra.NoArgs,
+	RunE: runAgent,
+}
+
+func init() {
+	agentCmd.Flags().StringVar(&agentSocket, "socket", agent.DefaultSocketPath(), "Unix socket path")
+	agentCmd.Flags().DurationVar(&agentTTL, "ttl", time.Hour, "How long to keep the config unlocked (0 for no limit)")
+}
+
+// runAgent is the main entry point for the agent command
+func runAgent(cmd *cobra.Command, args []string) error {
+	cfg, err := loadOrSetupConfig()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	server, err := agent.NewServer(cfg, agentTTL)
+	if err != nil {
+		return err
+	}
+	if !server.MemoryLocked() {
+		color.Yellow("⚠ Could not lock memory; the master key may be swapped to disk")
+	}
+
+	listener, err := agent.Listen(agentSocket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(agentSocket)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("%s=%s; export %s;\n", agent.SocketEnv, agentSocket, agent.SocketEnv)
+	if agentTTL > 0 {
+		color.Green("✓ Agent unlocked until %s", time.Now().Add(agentTTL).Format(time.Kitchen))
+	}
+
+	return server.Serve(ctx, listener)
+}
+
+// loadKeyring returns the config and keyring for commands that only need key
+// operations. With BURROW_AGENT_SOCK set they come from the agent, otherwise
+// the config is unlocked with the master password.
+func loadKeyring() (*config.Config, keyring.Keyring, error) {
+	if path := os.Getenv(agent.SocketEnv); path != "" {
+		client, err := agent.Dial(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg, err := client.Config()
+		if err != nil {
+			return nil, nil, err
+		}
+		return cfg, client, nil
+	}
+
+	cfg, err := loadOrSetupConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, keyring.NewLocal(cfg), nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/thebluefowl/burrow/internal/bench"
+	"github.com/thebluefowl/burrow/internal/settings"
+)
+
+var (
+	benchSize   = settings.Size(bench.DefaultOptions().Size)
+	benchTarget = settings.Size(bench.DefaultOptions().Target)
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench [path]",
+	Short: "Measure local pipeline throughput and suggest settings",
+	Long: `Measures how fast this machine archives, compresses and encrypts, and
+suggests settings for 'burrow config settings'. With a path, that file or
+directory is used as sample data; otherwise mixed text and random data is
+generated. Network throughput is not measured: set --target to your upload
+bandwidth so only compression levels that keep up with it are suggested.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runBench,
+}
+
+func init() {
+	benchCmd.Flags().Var(sizeValue{&benchSize}, "size", "Bytes of sample data per measurement")
+	benchCmd.Flags().Var(sizeValue{&benchTarget}, "target", "Throughput per second compression must sustain, e.g. your upload bandwidth")
+}
+
+// runBench is the main entry point for the bench command
+func runBench(cmd *cobra.Command, args []string) error {
+	opts := bench.DefaultOptions()
+	opts.Size = int64(benchSize)
+	opts.Target = float64(benchTarget)
+	if len(args) == 1 {
+		opts.Path = args[0]
+	}
+
+	fmt.Printf("ℹ Measuring with %s of sample data...\n\n", benchSize)
+	res, err := bench.NewBench(opts).Execute(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if res.Archive != nil {
+		printMeasurements("Archive", []bench.Measurement{*res.Archive}, false)
+	}
+	printMeasurements("Compression (auto)", res.Levels, true)
+	printMeasurements("Codecs", res.Codecs, true)
+	printMeasurements("Encryption", res.Encrypt, false)
+
+	color.Green("✓ Suggested settings for a %s/s target:", benchTarget)
+	for _, name := range []string{"compression-level", "chunk-size"} {
+		v, _ := settings.Value(&res.Suggests, name)
+		fmt.Printf("  burrow config settings set %s %s\n", name, v)
+	}
+	return nil
+}
+
+func printMeasurements(title string, ms []bench.Measurement, ratio bool) {
+	fmt.Println(title)
+	for _, m := range ms {
+		line := fmt.Sprintf("  %-18s %8.1f MiB/s", m.Name, m.Throughput()/(1<<20))
+		if ratio {
+			line += fmt.Sprintf("  ratio %.3f", m.Ratio())
+		}
+		fmt.Println(line)
+	}
+	fmt.Println()
+}
+
+// sizeValue adapts a settings.Size to a flag.
+type sizeValue struct{ s *settings.Size }
+
+func (v sizeValue) String() string { return v.s.String() }
+func (v sizeValue) Type() string   { return "size" }
+
+func (v sizeValue) Set(text string) error {
+	n, err := settings.ParseSize(text)
+	if err != nil {
+		return err
+	}
+	*v.s = n
+	return nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and change the local configuration",
+}
+
+var recipientsCmd = &cobra.Command{
+	Use:   "recipients",
+	Short: "Manage the extra recipients new envelopes are sealed to",
+}
+
+var recipientsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List configured recipients",
+	Args:  cobra.NoArgs,
+	RunE:  runRecipientsList,
+}
+
+var recipientsAddCmd = &cobra.Command{
+	Use:   "add <recipient>...",
+	Short: "Add recipients to the config",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runRecipientsAdd,
+}
+
+var recipientsRemoveCmd = &cobra.Command{
+	Use:   "remove <recipient>...",
+	Short: "Remove recipients from the config",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runRecipientsRemove,
+}
+
+var identitiesCmd = &cobra.Command{
+	Use:   "identities",
+	Short: "Manage extra identity files (age or OpenSSH) used to open envelopes",
+}
+
+var identitiesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List configured identity files",
+	Args:  cobra.NoArgs,
+	RunE:  runIdentitiesList,
+}
+
+var identitiesAddCmd = &cobra.Command{
+	Use:   "add <path>...",
+	Short: "Add identity files to the config",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runIdentitiesAdd,
+}
+
+var identitiesRemoveCmd = &cobra.Command{
+	Use:   "remove <path>...",
+	Short: "Remove identity files from the config",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runIdentitiesRemove,
+}
+
+var signersCmd = &cobra.Command{
+	Use:   "signers",
+	Short: "Manage the Ed25519 keys whose envelope signatures are trusted",
+}
+
+var signersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the own signing key and trusted signers",
+	Args:  cobra.NoArgs,
+	RunE:  runSignersList,
+}
+
+var signersAddCmd = &cobra.Command{
+	Use:   "add <public-key>...",
+	Short: "Trust envelopes signed by these keys",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runSignersAdd,
+}
+
+var signersRemoveCmd = &cobra.Command{
+	Use:   "remove <public-key>...",
+	Short: "Stop trusting envelopes signed by these keys",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runSignersRemove,
+}
+
+var (
+	hardenKDF     string
+	hardenTarget  time.Duration
+	hardenMemory  uint32
+	hardenTime    uint32
+	hardenThreads uint8
+	hardenForce   bool
+)
+
+var kdfCmd = &cobra.Command{
+	Use:   "kdf",
+	Short: "Show the key derivation function protecting the config file",
+	Args:  cobra.NoArgs,
+	RunE:  runKDF,
+}
+
+var hardenCmd = &cobra.Command{
+	Use:   "harden",
+	Short: "Re-encrypt the config file with a more expensive key derivation",
+	Long: `Re-encrypts the config file with new KDF parameters. By default Argon2id is
+calibrated so that unlocking takes about --target on this machine; --time sets
+the Argon2id time cost directly. Parameters that need less memory or less
+work than the current ones, also when switching between Argon2id and scrypt,
+are refused unless --force is given.`,
+	Args: cobra.NoArgs,
+	RunE: runHarden,
+}
+
+func init() {
+	recipientsCmd.AddCommand(recipientsListCmd)
+	recipientsCmd.AddCommand(recipientsAddCmd)
+	recipientsCmd.AddCommand(recipientsRemoveCmd)
+	configCmd.AddCommand(recipientsCmd)
+
+	identitiesCmd.AddCommand(identitiesListCmd)
+	identitiesCmd.AddCommand(identitiesAddCmd)
+	identitiesCmd.AddCommand(identitiesRemoveCmd)
+	configCmd.AddCommand(identitiesCmd)
+
+	signersCmd.AddCommand(signersListCmd)
+	signersCmd.AddCommand(signersAddCmd)
+	signersCmd.AddCommand(signersRemoveCmd)
+	configCmd.AddCommand(signersCmd)
+
+	hardenCmd.Flags().StringVar(&hardenKDF, "kdf", config.KDFArgon2id, "Key derivation function: argon2id or scrypt")
+	hardenCmd.Flags().DurationVar(&hardenTarget, "target", 2*config.DefaultKDFTarget, "How long unlocking should take on this machine")
+	hardenCmd.Flags().Uint32Var(&hardenMemory, "memory", 256, "Argon2id memory cost in MiB")
+	hardenCmd.Flags().Uint32Var(&hardenTime, "time", 0, "Argon2id time cost (skips calibration)")
+	hardenCmd.Flags().Uint8Var(&hardenThreads, "threads", 4, "Argon2id parallelism")
+	hardenCmd.Flags().BoolVar(&hardenForce, "force", false, "Allow parameters weaker than the current ones")
+	configCmd.AddCommand(kdfCmd)
+	configCmd.AddCommand(hardenCmd)
+}
+
+func runRecipientsList(cmd *cobra.Command, args []string) error {
+	cfg, err := loadOrSetupConfig()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	fmt.Printf("%s (own key)\n", cfg.AgePublicKey)
+	for _, r := range cfg.Recipients {
+		fmt.Println(r)
+	}
+	return nil
+}
+
+func runRecipientsAdd(cmd *cobra.Command, args []string) error {
+	for _, r := range args {
+		if err := enc.ValidateRecipient(r); err != nil {
+			return err
+		}
+	}
+
+	cfg, password, err := loadConfigWithPassword()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	cfg.Recipients = config.MergeUnique(cfg.Recipients, args)
+	if err := config.Save(*cfg, password); err != nil {
+		return err
+	}
+
+	color.Green("✓ New envelopes will be sealed to %d extra recipient(s)", len(cfg.Recipients))
+	return nil
+}
+
+func runRecipientsRemove(cmd *cobra.Command, args []string) error {
+	cfg, password, err := loadConfigWithPassword()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	cfg.Recipients = removeAll(cfg.Recipients, args)
+
+	if err := config.Save(*cfg, password); err != nil {
+		return err
+	}
+
+	color.Green("✓ New envelopes will be sealed to %d extra recipient(s)", len(cfg.Recipients))
+	color.Yellow("ℹ Existing envelopes are unchanged; `burrow key rotate --drop-recipient` re-seals them without the recipient")
+	color.Yellow("⚠ That does not revoke access to existing backups: their data keys stay the same, so a removed recipient who kept an envelope or a data key can still decrypt them")
+	return nil
+}
+
+func runIdentitiesList(cmd *cobra.Command, args []string) error {
+	cfg, err := loadOrSetupConfig()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	for _, path := range cfg.IdentityFiles {
+		fmt.Println(path)
+	}
+	return nil
+}
+
+func runIdentitiesAdd(cmd *cobra.Command, args []string) error {
+	paths := make([]string, 0, len(args))
+	for _, p := range args {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		if err := enc.ValidateIdentityFile(abs); err != nil {
+			return err
+		}
+		paths = append(paths, abs)
+	}
+
+	cfg, password, err := loadConfigWithPassword()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	cfg.IdentityFiles = config.MergeUnique(cfg.IdentityFiles, paths)
+	if err := config.Save(*cfg, password); err != nil {
+		return err
+	}
+
+	color.Green("✓ %d identity file(s) configured", len(cfg.IdentityFiles))
+	return nil
+}
+
+func runIdentitiesRemove(cmd *cobra.Command, args []string) error {
+	cfg, password, err := loadConfigWithPassword()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	paths := make([]string, 0, len(args))
+	for _, p := range args {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, abs)
+	}
+	cfg.IdentityFiles = removeAll(cfg.IdentityFiles, paths)
+
+	if err := config.Save(*cfg, password); err != nil {
+		return err
+	}
+
+	color.Green("✓ %d identity file(s) configured", len(cfg.IdentityFiles))
+	return nil
+}
+
+func runSignersList(cmd *cobra.Command, args []string) error {
+	cfg, err := loadOrSetupConfig()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	fmt.Printf("%s (own key)\n", cfg.SigningPublicKey)
+	for _, s := range cfg.TrustedSigners {
+		fmt.Println(s)
+	}
+	return nil
+}
+
+func runSignersAdd(cmd *cobra.Command, args []string) error {
+	for _, s := range args {
+		if err := enc.ValidateSigningPublicKey(s); err != nil {
+			return err
+		}
+	}
+
+	cfg, password, err := loadConfigWithPassword()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	cfg.TrustedSigners = config.MergeUnique(cfg.TrustedSigners, args)
+	if err := config.Save(*cfg, password); err != nil {
+		return err
+	}
+
+	color.Green("✓ Trusting %d signer(s) besides your own key", len(cfg.TrustedSigners))
+	return nil
+}
+
+func runSignersRemove(cmd *cobra.Command, args []string) error {
+	cfg, password, err := loadConfigWithPassword()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	cfg.TrustedSigners = removeAll(cfg.TrustedSigners, args)
+	if err := config.Save(*cfg, password); err != nil {
+		return err
+	}
+
+	color.Green("✓ Trusting %d signer(s) besides your own key", len(cfg.TrustedSigners))
+	return nil
+}
+
+func runKDF(cmd *cobra.Command, args []string) error {
+	kdf, err := config.InspectKDF()
+	if err != nil {
+		return err
+	}
+	fmt.Println(kdf)
+	return nil
+}
+
+func runHarden(cmd *cobra.Command, args []string) error {
+	var kdf config.KDFParams
+	switch hardenKDF {
+	case config.KDFArgon2id:
+		fmt.Println("ℹ Calibrating Argon2id...")
+		kdf = config.CalibrateArgon2id(hardenTarget, hardenMemory*1024, hardenThreads)
+		if hardenTime > 0 {
+			kdf.Time = hardenTime
+		}
+	case config.KDFScrypt:
+		fmt.Println("ℹ Calibrating scrypt...")
+		kdf = config.CalibrateScrypt(hardenTarget)
+	default:
+		return fmt.Errorf("invalid kdf %q (want %s or %s)", hardenKDF, config.KDFArgon2id, config.KDFScrypt)
+	}
+	if err := kdf.Validate(); err != nil {
+		return err
+	}
+
+	current, err := config.InspectKDF()
+	if err != nil {
+		return err
+	}
+	if kdf.WeakerThan(current) && !hardenForce {
+		return fmt.Errorf("%s is weaker than the current %s; use --force to downgrade", kdf, current)
+	}
+
+	cfg, password, err := loadConfigWithPassword()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	start := time.Now()
+	if err := config.SaveWithKDF(*cfg, password, kdf); err != nil {
+		return err
+	}
+
+	color.Green("✓ Config re-encrypted with %s (took %s)", kdf, time.Since(start).Round(time.Millisecond))
+	fmt.Printf("Previously: %s\n", current)
+	return nil
+}
+
+// removeAll returns list without any element of remove.
+func removeAll(list, remove []string) []string {
+	drop := make(map[string]bool, len(remove))
+	for _, r := range remove {
+		drop[r] = true
+	}
+	var kept []string
+	for _, v := range list {
+		if !drop[v] {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
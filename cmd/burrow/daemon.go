@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/thebluefowl/burrow/internal/agent"
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/daemon"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/progress"
+)
+
+// passwordFileEnv names a file holding the master password for the daemon.
+const passwordFileEnv = "BURROW_PASSWORD_FILE"
+
+var (
+	daemonOnce         bool
+	daemonJob          string
+	daemonPasswordFile string
+	daemonStateDir     string
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon <job-file>",
+	Short: "Run scheduled backup jobs",
+	Long: `Runs the backup jobs of a job file on their cron schedules until interrupted.
+Each job has a lock, so a run that is still going when the next one is due is
+skipped, and failed runs are retried with backoff. After a successful run,
+backups the job's retention policy no longer keeps are deleted.
+
+The daemon never prompts. It uses the agent when BURROW_AGENT_SOCK is set,
+otherwise it reads the master password from --password-file or
+BURROW_PASSWORD_FILE.
+
+  burrow daemon --password-file /etc/burrow/password jobs.json
+  burrow daemon --once --job etc jobs.json`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDaemon,
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status <job-file>",
+	Short: "Show the last runs of each job",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDaemonStatus,
+}
+
+func init() {
+	daemonCmd.Flags().BoolVar(&daemonOnce, "once", false, "Run the jobs once now and exit")
+	daemonCmd.PersistentFlags().StringVar(&daemonJob, "job", "", "Only run this job")
+	daemonCmd.Flags().StringVar(&daemonPasswordFile, "password-file", "", "File holding the master password (default $"+passwordFileEnv+")")
+	daemonCmd.PersistentFlags().StringVar(&daemonStateDir, "state-dir", "", "Directory for job state and lock files (default <config dir>/burrow/daemon)")
+
+	daemonCmd.AddCommand(daemonStatusCmd)
+}
+
+// runDaemon is the main entry point for the daemon command
+func runDaemon(cmd *cobra.Command, args []string) error {
+	file, err := loadJobFile(args[0])
+	if err != nil {
+		return err
+	}
+	stateDir, err := jobStateDir(file)
+	if err != nil {
+		return err
+	}
+
+	// Nobody is there to answer prompts or watch progress bars.
+	progress.SetEnabled(false)
+	enc.DefaultSSHPassphrase = func(path string) ([]byte, error) {
+		return nil, fmt.Errorf("SSH key %s needs a passphrase, which the daemon can't ask for", path)
+	}
+
+	cfg, keys, err := daemonKeyring()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	b2Client, err := initB2Client(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	d := daemon.New(file, cfg, keys, b2Client, stateDir, log.New(os.Stderr, "", log.LstdFlags))
+	if !daemonOnce {
+		return d.Run(ctx)
+	}
+
+	var errs []error
+	for _, j := range file.Jobs {
+		if err := d.RunJob(ctx, j); err != nil {
+			errs = append(errs, fmt.Errorf("job %s: %w", j.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runDaemonStatus prints the state of each job.
+func runDaemonStatus(cmd *cobra.Command, args []string) error {
+	file, err := loadJobFile(args[0])
+	if err != nil {
+		return err
+	}
+	stateDir, err := jobStateDir(file)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i, j := range file.Jobs {
+		state, err := daemon.LoadState(stateDir, j.Name)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s (%s)\n", j.Name, j.Schedule)
+		fmt.Printf("  Next run:     %s\n", j.NextRun(now).Format(time.RFC3339))
+		switch {
+		case state.LastRun.IsZero():
+			fmt.Println("  Last run:     never")
+		case state.LastError != "":
+			color.Red("  Last run:     %s, failed: %s", state.LastRun.Format(time.RFC3339), state.LastError)
+		default:
+			color.Green("  Last run:     %s, succeeded", state.LastRun.Format(time.RFC3339))
+		}
+		if !state.LastSuccess.IsZero() {
+			fmt.Printf("  Last success: %s (%s)\n", state.LastSuccess.Format(time.RFC3339), state.LastObjectID)
+		}
+		if state.Failures > 0 {
+			fmt.Printf("  Failures:     %d since the last success\n", state.Failures)
+		}
+		fmt.Printf("  Backups:      %d kept\n", len(state.Backups))
+	}
+	return nil
+}
+
+// loadJobFile loads the job file, narrowed to --job if it is set.
+func loadJobFile(path string) (*daemon.File, error) {
+	file, err := daemon.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if daemonJob != "" {
+		j := file.Job(daemonJob)
+		if j == nil {
+			return nil, fmt.Errorf("no job %q in %s", daemonJob, path)
+		}
+		file.Jobs = []*daemon.Job{j}
+	}
+	return file, nil
+}
+
+// jobStateDir returns --state-dir, the job file's state_dir or the default.
+func jobStateDir(file *daemon.File) (string, error) {
+	switch {
+	case daemonStateDir != "":
+		return daemonStateDir, nil
+	case file.StateDir != "":
+		return file.StateDir, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config directory: %w", err)
+	}
+	return filepath.Join(dir, "burrow", "daemon"), nil
+}
+
+// daemonKeyring is loadKeyring without prompts: it uses the agent or a
+// password file.
+func daemonKeyring() (*config.Config, keyring.Keyring, error) {
+	if os.Getenv(agent.SocketEnv) != "" {
+		return loadKeyring()
+	}
+
+	path := daemonPasswordFile
+	if path == "" {
+		path = os.Getenv(passwordFileEnv)
+	}
+	if path == "" {
+		return nil, nil, fmt.Errorf("no credentials: set %s, --password-file or %s", agent.SocketEnv, passwordFileEnv)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read password file: %w", err)
+	}
+	password := strings.TrimRight(string(b), "\r\n")
+
+	if !config.Exists() {
+		return nil, nil, errors.New("no config; run `burrow init` first")
+	}
+	cfg, err := config.Load(password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	generated, err := cfg.EnsureSigningKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	if generated {
+		if err := config.Save(*cfg, password); err != nil {
+			return nil, nil, err
+		}
+	}
+	return cfg, keyring.NewLocal(cfg), nil
+}
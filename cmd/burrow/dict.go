@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/thebluefowl/burrow/internal/compress"
+)
+
+// Samples larger than this are split; zstd trains on small, similar inputs.
+const dictSampleSize = 128 << 10
+
+var (
+	dictOutput   string
+	dictMaxSize  int
+	dictMaxInput int64
+)
+
+var dictCmd = &cobra.Command{
+	Use:   "dict",
+	Short: "Manage zstd compression dictionaries",
+}
+
+var dictTrainCmd = &cobra.Command{
+	Use:   "train <file-or-directory>...",
+	Short: "Train a zstd dictionary from sample files",
+	Long: `Trains a zstd dictionary from the given files (directories are walked). A
+dictionary helps most when backing up many small, similar files such as logs
+or JSON documents. Use it with 'burrow upload --dict <file>'; each envelope
+keeps a copy, so the dictionary file is not needed to restore.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runDictTrain,
+}
+
+func init() {
+	dictTrainCmd.Flags().StringVarP(&dictOutput, "output", "o", "burrow.dict", "File to write the dictionary to")
+	dictTrainCmd.Flags().IntVar(&dictMaxSize, "size", compress.DefaultDictionarySize, "Maximum dictionary size in bytes")
+	dictTrainCmd.Flags().Int64Var(&dictMaxInput, "max-input", 64<<20, "Maximum number of sample bytes to read")
+	dictCmd.AddCommand(dictTrainCmd)
+}
+
+// runDictTrain is the main entry point for the dict train command
+func runDictTrain(cmd *cobra.Command, args []string) error {
+	samples, total, err := collectSamples(args, dictMaxInput)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("ℹ Training on %d samples (%d bytes)...\n", len(samples), total)
+
+	d, err := compress.TrainDictionary(samples, dictMaxSize)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dictOutput, d, 0o644); err != nil {
+		return fmt.Errorf("write dictionary: %w", err)
+	}
+
+	color.Green("✓ Wrote %d byte dictionary to %s", len(d), dictOutput)
+	return nil
+}
+
+// collectSamples reads regular files under paths, split into samples of at
+// most dictSampleSize bytes, until limit bytes have been read.
+func collectSamples(paths []string, limit int64) ([][]byte, int64, error) {
+	var samples [][]byte
+	var total int64
+
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			if total >= limit {
+				return filepath.SkipAll
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if rest := limit - total; int64(len(data)) > rest {
+				data = data[:rest]
+			}
+			total += int64(len(data))
+			for len(data) > 0 {
+				n := min(len(data), dictSampleSize)
+				samples = append(samples, data[:n])
+				data = data[n:]
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("read samples: %w", err)
+		}
+	}
+	return samples, total, nil
+}
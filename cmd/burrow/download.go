@@ -3,27 +3,46 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 
+	"github.com/thebluefowl/burrow/internal/config"
 	"github.com/thebluefowl/burrow/internal/download"
+	"github.com/thebluefowl/burrow/internal/glob"
 )
 
 var (
-	unarchiveFlag bool
+	unarchiveFlag     bool
+	allowUnsignedFlag bool
+	downloadPaths     []string
+	pathIgnoreCase    bool
+	preDownloadHook   string
+	postDownloadHook  string
 )
 
 var downloadCmd = &cobra.Command{
-	Use:   "download <object-id> <destination>",
+	Use:   "download <object-id> <destination|->",
 	Short: "Download and decrypt a file or directory from Backblaze B2",
-	Long:  `Downloads the specified object from Backblaze B2, decrypts it, and optionally extracts it.`,
-	Args:  cobra.ExactArgs(2),
-	RunE:  runDownload,
+	Long: `Downloads the specified object from Backblaze B2, decrypts it, and optionally extracts it.
+
+A single file is restored as itself and a directory as <name>.tar unless
+--extract is given. An existing directory destination receives the object
+under its original name; any other destination is the exact output path.
+
+With "-" as the destination the decrypted object is written to stdout, like 'burrow cat'.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runDownload,
 }
 
 func init() {
 	downloadCmd.Flags().BoolVarP(&unarchiveFlag, "extract", "x", false, "Extract tar archive to destination directory")
+	downloadCmd.Flags().BoolVar(&allowUnsignedFlag, "allow-unsigned", false, "Accept envelopes written before signing was introduced")
+	downloadCmd.Flags().StringArrayVar(&downloadPaths, "path", nil, "Extract only paths inside the archive matching this glob `pattern` (see 'burrow ls'), and everything below matching directories; repeatable")
+	downloadCmd.Flags().BoolVar(&pathIgnoreCase, "ignore-case", false, "Match --path patterns case-insensitively")
+	downloadCmd.Flags().StringVar(&preDownloadHook, "pre-hook", "", "Shell `command` to run before the download; if it fails, nothing is downloaded (default from config)")
+	downloadCmd.Flags().StringVar(&postDownloadHook, "post-hook", "", "Shell `command` to run after the download, even if it failed (default from config)")
 }
 
 // runDownload is the main entry point for the download command
@@ -31,19 +50,39 @@ func runDownload(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 	objectID := args[0]
 	destPath := args[1]
+	if destPath == "-" {
+		if unarchiveFlag || len(downloadPaths) > 0 {
+			return fmt.Errorf("--extract and --path need a destination directory, not stdout")
+		}
+		return catObject(ctx, objectID)
+	}
 
-	cfg, err := loadOrSetupConfig()
+	cfg, keys, err := loadKeyring()
 	if err != nil {
 		return fmt.Errorf("config error: %w", err)
 	}
+	applyDownloadHooks(cfg)
 
 	b2Client, err := initB2Client(ctx, cfg)
 	if err != nil {
 		return err
 	}
 
-	downloader := download.NewDownloader(cfg, objectID, destPath, unarchiveFlag, b2Client)
-	if err := downloader.Execute(); err != nil {
+	if len(downloadPaths) > 0 {
+		browser := download.NewBrowser(cfg, keys, objectID, allowUnsignedFlag, b2Client)
+		err := browser.Extract(ctx, destPath, downloadPaths, glob.Options{CaseInsensitive: pathIgnoreCase})
+		printWarnings(browser.Warnings())
+		if err != nil {
+			return err
+		}
+		color.Green("✓ Extracted paths matching %d pattern(s) from %s to %s\n", len(downloadPaths), objectID, destPath)
+		return nil
+	}
+
+	downloader := download.NewDownloader(cfg, keys, objectID, destPath, unarchiveFlag, allowUnsignedFlag, b2Client)
+	err = downloader.Execute()
+	printWarnings(downloader.Warnings())
+	if err != nil {
 		return err
 	}
 
@@ -51,6 +90,16 @@ func runDownload(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// applyDownloadHooks sets the download hooks given as flags.
+func applyDownloadHooks(cfg *config.Config) {
+	if preDownloadHook != "" {
+		cfg.Hooks.PreDownload = preDownloadHook
+	}
+	if postDownloadHook != "" {
+		cfg.Hooks.PostDownload = postDownloadHook
+	}
+}
+
 // printDownloadSuccess displays a success message
 func printDownloadSuccess(objectID, destPath string) {
 	if unarchiveFlag {
@@ -59,3 +108,47 @@ func printDownloadSuccess(objectID, destPath string) {
 		color.Green("✓ Successfully downloaded %s to %s\n", objectID, destPath)
 	}
 }
+
+var catCmd = &cobra.Command{
+	Use:   "cat <object-id>",
+	Short: "Decrypt an object from Backblaze B2 to stdout",
+	Long: `Downloads and decrypts the specified object and writes it to stdout, e.g.
+  burrow cat <object-id> | psql mydb
+
+Directories are written as a tar archive.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runCat,
+}
+
+func init() {
+	catCmd.Flags().BoolVar(&allowUnsignedFlag, "allow-unsigned", false, "Accept envelopes written before signing was introduced")
+	catCmd.Flags().StringVar(&preDownloadHook, "pre-hook", "", "Shell `command` to run before the download; if it fails, nothing is written (default from config)")
+	catCmd.Flags().StringVar(&postDownloadHook, "post-hook", "", "Shell `command` to run after the download, even if it failed (default from config)")
+}
+
+// runCat is the main entry point for the cat command
+func runCat(cmd *cobra.Command, args []string) error {
+	return catObject(context.Background(), args[0])
+}
+
+// catObject writes the decrypted object to stdout. Prompts and messages go
+// to the terminal and stderr.
+func catObject(ctx context.Context, objectID string) error {
+	promptOnTerminal()
+
+	cfg, keys, err := loadKeyring()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+	applyDownloadHooks(cfg)
+
+	b2Client, err := initB2Client(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	downloader := download.NewStreamDownloader(cfg, keys, objectID, os.Stdout, allowUnsignedFlag, b2Client)
+	err = downloader.Execute()
+	printWarnings(downloader.Warnings())
+	return err
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+
+	"github.com/thebluefowl/burrow/internal/config"
+)
+
+var (
+	fromRepoFlag bool
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Set up burrow on this machine",
+	Long: `Creates the local encrypted configuration. With --from-repo, the master key and
+age identity are recovered from the bucket's repository key using the repository
+passphrase, so a new machine only needs bucket credentials to regain access.`,
+	Args: cobra.NoArgs,
+	RunE: runInit,
+}
+
+func init() {
+	initCmd.Flags().BoolVar(&fromRepoFlag, "from-repo", false, "Recover keys from the repository key stored in the bucket")
+}
+
+// runInit is the main entry point for the init command
+func runInit(cmd *cobra.Command, args []string) error {
+	if config.Exists() {
+		return errors.New("burrow is already configured on this machine")
+	}
+
+	_, err := runSetup(fromRepoFlag)
+	return err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/rotate"
+	"github.com/thebluefowl/burrow/internal/storage"
+)
+
+var (
+	rotateMasterKeyFlag bool
+	dropRecipients      []string
+)
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Manage encryption keys",
+}
+
+var keyRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Generate a new age identity and re-seal every envelope",
+	Long: `Generates a new age identity and re-seals every envelope in the repository to it.
+With --master-key, envelopes are first switched to wrapped mode (the data key is
+stored inside the envelope) and the master key is replaced, so no data object
+needs to be re-encrypted. Bundles (upload --bundle) hold their envelope, so
+each is rewritten whole, its data streamed through once. Unsigned envelopes,
+written before signing existed, are signed as by 'burrow migrate envelopes',
+so rotate only against a bucket you trust.`,
+	Args: cobra.NoArgs,
+	RunE: runKeyRotate,
+}
+
+func init() {
+	keyRotateCmd.Flags().BoolVar(&rotateMasterKeyFlag, "master-key", false, "Also rotate the master key")
+	keyRotateCmd.Flags().StringArrayVar(&dropRecipients, "drop-recipient", nil, "Remove a recipient from every envelope and the config (repeatable)")
+	keyCmd.AddCommand(keyRotateCmd)
+}
+
+// runKeyRotate is the main entry point for the key rotate command
+func runKeyRotate(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	cfg, password, err := loadConfigWithPassword()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	b2Client, err := initB2Client(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := repositoryPassphraseForUpdate(ctx, b2Client)
+	if err != nil {
+		return err
+	}
+
+	persist := func(cfg *config.Config) error {
+		if err := config.Save(*cfg, password); err != nil {
+			return err
+		}
+		blob, err := config.NewRecoveryKey(cfg).Seal(passphrase)
+		if err != nil {
+			return err
+		}
+		if err := b2Client.Upload(ctx, config.RecoveryKeyObject, bytes.NewReader(blob), "application/octet-stream", nil); err != nil {
+			return fmt.Errorf("failed to upload repository key: %w", err)
+		}
+		return nil
+	}
+
+	rotator := rotate.NewRotator(cfg, b2Client, rotate.Options{
+		RotateMasterKey: rotateMasterKeyFlag,
+		DropRecipients:  dropRecipients,
+	}, persist)
+	result, err := rotator.Execute(ctx)
+	if err != nil {
+		if result != nil {
+			color.Yellow("⚠ Re-sealed %d envelopes before failing; re-run to finish rotation", result.Resealed)
+		}
+		return err
+	}
+
+	color.Green("✓ Re-sealed %d envelopes (%d switched to wrapped mode)", result.Resealed, result.Wrapped)
+	if len(dropRecipients) > 0 {
+		color.Yellow("⚠ Data keys were not changed: dropped recipients who kept an envelope or a data key can still decrypt existing backups")
+	}
+	if rotateMasterKeyFlag {
+		color.Green("✓ Master key rotated")
+	}
+	fmt.Printf("New Public Key: %s\n", cfg.AgePublicKey)
+	return nil
+}
+
+// repositoryPassphraseForUpdate returns the passphrase used to re-seal the
+// repository key. An existing repository key must open with it; otherwise a
+// new passphrase is set up.
+func repositoryPassphraseForUpdate(ctx context.Context, s storage.Storage) (string, error) {
+	blob, err := fetchRecoveryKey(ctx, s)
+	if err != nil {
+		return "", err
+	}
+
+	if blob == nil {
+		color.Yellow("ℹ No repository key found, one will be created")
+		return setupRepositoryPassphrase()
+	}
+
+	passphrase, err := askRepositoryPassphrase()
+	if err != nil {
+		return "", fmt.Errorf("failed to get repository passphrase: %w", err)
+	}
+	if _, err := config.OpenRecoveryKey(blob, passphrase); err != nil {
+		return "", err
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/thebluefowl/burrow/internal/download"
+)
+
+var lsLong bool
+
+var lsCmd = &cobra.Command{
+	Use:   "ls <object-id>",
+	Short: "List the files in a backup without downloading it",
+	Long: `Lists the entries of an archived backup. Only the parts of the object that
+hold tar headers are downloaded and decrypted, so this works for backups
+uploaded with auto or zstd-seekable compression.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runLs,
+}
+
+func init() {
+	lsCmd.Flags().BoolVarP(&lsLong, "long", "l", false, "Show mode and size")
+	lsCmd.Flags().BoolVar(&allowUnsignedFlag, "allow-unsigned", false, "Accept envelopes written before signing was introduced")
+}
+
+// runLs is the main entry point for the ls command
+func runLs(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	cfg, keys, err := loadKeyring()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	b2Client, err := initB2Client(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	browser := download.NewBrowser(cfg, keys, args[0], allowUnsignedFlag, b2Client)
+	return browser.List(ctx, func(hdr *tar.Header) error {
+		if lsLong {
+			fmt.Printf("%s %12d %s\n", hdr.FileInfo().Mode(), hdr.Size, hdr.Name)
+		} else {
+			fmt.Println(hdr.Name)
+		}
+		return nil
+	})
+}
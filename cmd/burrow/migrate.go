@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/migrate"
+)
+
+var (
+	migrateDryRun bool
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade repository data to the current format",
+}
+
+var migrateEnvelopesCmd = &cobra.Command{
+	Use:   "envelopes",
+	Short: "Rewrite old or unsigned envelopes in the current envelope version",
+	Long: `Opens every envelope in the repository and re-seals those stored in an older
+version in the current one, in place. Unsigned envelopes are signed with your
+signing key, so only run this against a bucket you trust has not been tampered
+with. Recipients and key modes are preserved; data objects are not touched,
+except bundles, which hold their envelope and are rewritten whole.`,
+	Args: cobra.NoArgs,
+	RunE: runMigrateEnvelopes,
+}
+
+func init() {
+	migrateEnvelopesCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Only report which envelopes would be rewritten")
+	migrateCmd.AddCommand(migrateEnvelopesCmd)
+}
+
+// runMigrateEnvelopes is the main entry point for the migrate envelopes command
+func runMigrateEnvelopes(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	cfg, keys, err := loadKeyring()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	b2Client, err := initB2Client(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	result, err := migrate.NewMigrator(cfg, keys, b2Client, migrate.Options{DryRun: migrateDryRun}).Execute(ctx)
+	if err != nil {
+		if result != nil && result.Migrated > 0 {
+			color.Yellow("⚠ Migrated %d envelopes before failing; re-run to finish", result.Migrated)
+		}
+		return err
+	}
+
+	versions := make([]string, 0, len(result.Versions))
+	for v := range result.Versions {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	for _, v := range versions {
+		fmt.Printf("  %s: %d\n", v, result.Versions[v])
+	}
+
+	if migrateDryRun {
+		color.Yellow("Would migrate %d of %d envelopes to %s\n", result.Migrated, result.Checked, envelope.CurrentVersion)
+		return nil
+	}
+	color.Green("✓ Migrated %d of %d envelopes to %s\n", result.Migrated, result.Checked, envelope.CurrentVersion)
+	return nil
+}
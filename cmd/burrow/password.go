@@ -1,6 +1,29 @@
 package main
 
-import "github.com/AlecAivazis/survey/v2"
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/fatih/color"
+)
+
+// promptOpts are passed to every prompt; see promptOnTerminal.
+var promptOpts []survey.AskOpt
+
+// promptOnTerminal moves prompts and status output off stdin and stdout,
+// which carry data when burrow is used in a shell pipeline. Prompts use the
+// controlling terminal, or fail without one; use `burrow agent` there.
+func promptOnTerminal() {
+	color.Output = os.Stderr
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		promptOpts = []survey.AskOpt{survey.WithStdio(os.Stdin, os.Stderr, os.Stderr)}
+		return
+	}
+	promptOpts = []survey.AskOpt{survey.WithStdio(tty, tty, os.Stderr)}
+}
 
 func askMasterPassword() (string, error) {
 	question := []*survey.Question{
@@ -13,9 +36,75 @@ func askMasterPassword() (string, error) {
 	}
 
 	var password string
-	if err := survey.Ask(question, &password); err != nil {
+	if err := survey.Ask(question, &password, promptOpts...); err != nil {
 		return "", err
 	}
 
 	return password, nil
 }
+
+func askRepositoryPassphrase() (string, error) {
+	question := []*survey.Question{
+		{
+			Name: "passphrase",
+			Prompt: &survey.Password{
+				Message: "Repository Passphrase:",
+			},
+			Validate: survey.Required,
+		},
+	}
+
+	var passphrase string
+	if err := survey.Ask(question, &passphrase, promptOpts...); err != nil {
+		return "", err
+	}
+
+	return passphrase, nil
+}
+
+func setupRepositoryPassphrase() (string, error) {
+	questions := []*survey.Question{
+		{
+			Name: "passphrase",
+			Prompt: &survey.Password{
+				Message: "Repository Passphrase:",
+			},
+			Validate: survey.Required,
+		},
+		{
+			Name: "confirm",
+			Prompt: &survey.Password{
+				Message: "Confirm Repository Passphrase:",
+			},
+			Validate: survey.Required,
+		},
+	}
+
+	var answers struct {
+		Passphrase string
+		Confirm    string
+	}
+
+	if err := survey.Ask(questions, &answers, promptOpts...); err != nil {
+		return "", err
+	}
+
+	if answers.Passphrase != answers.Confirm {
+		return "", errors.New("passphrases do not match")
+	}
+
+	return answers.Passphrase, nil
+}
+
+func askSSHPassphrase(path string) ([]byte, error) {
+	prompt := &survey.Password{
+		Message: fmt.Sprintf("Passphrase for %s:", path),
+	}
+
+	var passphrase string
+	if err := survey.AskOne(prompt, &passphrase, promptOpts...); err != nil {
+		return nil, err
+	}
+
+	return []byte(passphrase), nil
+}
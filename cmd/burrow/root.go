@@ -7,6 +7,9 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/progress"
+	"github.com/thebluefowl/burrow/internal/storage"
 	"github.com/thebluefowl/burrow/internal/storage/b2"
 )
 
@@ -14,8 +17,13 @@ var rootCmd = &cobra.Command{
 	Use:   "burrow",
 	Short: "Backblaze B2 backup tool with encryption",
 	Long:  `A CLI tool for securely backing up files to Backblaze B2 with encryption`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		progress.SetEnabled(!noProgressFlag)
+	},
 }
 
+var noProgressFlag bool
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
@@ -23,25 +31,38 @@ func Execute() {
 }
 
 func init() {
+	enc.DefaultSSHPassphrase = askSSHPassphrase
+
+	rootCmd.PersistentFlags().BoolVar(&noProgressFlag, "no-progress", false, "Don't show progress bars (they are written to stderr)")
+
+	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(uploadCmd)
 	rootCmd.AddCommand(downloadCmd)
+	rootCmd.AddCommand(catCmd)
+	rootCmd.AddCommand(keyCmd)
+	rootCmd.AddCommand(shareCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(agentCmd)
+	rootCmd.AddCommand(dictCmd)
+	rootCmd.AddCommand(benchCmd)
+	rootCmd.AddCommand(lsCmd)
+	rootCmd.AddCommand(daemonCmd)
+	rootCmd.AddCommand(watchCmd)
 }
 
-// initB2Client creates a B2 client from config
-func initB2Client(ctx context.Context, cfg *config.Config) (*b2.B2Client, error) {
-	const (
-		b2PartSizeMB  = 16
-		b2Concurrency = 4
-	)
-
+// initB2Client creates a B2 client from config. Tests replace it with
+// in-memory storage.
+var initB2Client = func(ctx context.Context, cfg *config.Config) (storage.Storage, error) {
+	set := cfg.Settings.WithDefaults()
 	opts := &b2.Opts{
 		Bucket:      cfg.BucketName,
 		Region:      cfg.Region,
 		Endpoint:    fmt.Sprintf("https://s3.%s.backblazeb2.com", cfg.Region),
 		AccessKey:   cfg.KeyID,
 		SecretKey:   cfg.AppKey,
-		PartSizeMB:  b2PartSizeMB,
-		Concurrency: b2Concurrency,
+		PartSizeMB:  set.PartSizeMB(),
+		Concurrency: set.Concurrency,
 	}
 
 	client, err := b2.New(ctx, opts)
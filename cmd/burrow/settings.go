@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/settings"
+)
+
+var settingsCmd = &cobra.Command{
+	Use:   "settings",
+	Short: "Show the compression, encryption and upload settings",
+	Long: `Shows the pipeline settings stored in the config and their defaults. Each
+setting can also be given as a flag to 'burrow upload' for a single run.`,
+	Args: cobra.NoArgs,
+	RunE: runSettingsList,
+}
+
+var settingsSetCmd = &cobra.Command{
+	Use:   "set <name> <value>",
+	Short: "Change a setting",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runSettingsSet,
+}
+
+var settingsUnsetCmd = &cobra.Command{
+	Use:   "unset <name>",
+	Short: "Reset a setting to its default",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSettingsUnset,
+}
+
+func init() {
+	settingsCmd.AddCommand(settingsSetCmd)
+	settingsCmd.AddCommand(settingsUnsetCmd)
+	configCmd.AddCommand(settingsCmd)
+}
+
+// addSettingsFlags registers a flag for every setting, writing into s.
+func addSettingsFlags(cmd *cobra.Command, s *settings.Settings) {
+	for _, f := range settings.Fields {
+		v, _ := settings.Value(s, f.Name)
+		cmd.Flags().Var(v, f.Name, f.Usage+" (default from config)")
+	}
+}
+
+// applySettings merges per-command overrides into the config's settings.
+func applySettings(cfg *config.Config, overrides settings.Settings) error {
+	merged := cfg.Settings.Merge(overrides)
+	if err := merged.Validate(); err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
+	}
+	cfg.Settings = merged
+	return nil
+}
+
+func runSettingsList(cmd *cobra.Command, args []string) error {
+	cfg, err := loadOrSetupConfig()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	current := cfg.Settings.WithDefaults()
+	defaults := settings.Default()
+	for _, f := range settings.Fields {
+		v, _ := settings.Value(&current, f.Name)
+		d, _ := settings.Value(&defaults, f.Name)
+		line := fmt.Sprintf("%-18s %s", f.Name, v)
+		if v.String() != d.String() {
+			line += fmt.Sprintf("  (default %s)", d)
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func runSettingsSet(cmd *cobra.Command, args []string) error {
+	var update settings.Settings
+	v, err := settings.Value(&update, args[0])
+	if err != nil {
+		return err
+	}
+	if err := v.Set(args[1]); err != nil {
+		return err
+	}
+	if err := update.Validate(); err != nil {
+		return err
+	}
+
+	cfg, password, err := loadConfigWithPassword()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	cfg.Settings = cfg.Settings.Merge(update)
+	if err := config.Save(*cfg, password); err != nil {
+		return err
+	}
+
+	color.Green("✓ %s set to %s", args[0], v)
+	return nil
+}
+
+func runSettingsUnset(cmd *cobra.Command, args []string) error {
+	cfg, password, err := loadConfigWithPassword()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	v, err := settings.Value(&cfg.Settings, args[0])
+	if err != nil {
+		return err
+	}
+	if err := v.Set("0"); err != nil {
+		return err
+	}
+	if err := config.Save(*cfg, password); err != nil {
+		return err
+	}
+
+	color.Green("✓ %s reset to its default", args[0])
+	return nil
+}
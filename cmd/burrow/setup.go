@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
@@ -10,9 +12,17 @@ import (
 	"github.com/fatih/color"
 	"github.com/thebluefowl/burrow/internal/config"
 	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/storage"
 )
 
 func setup() (*config.Config, error) {
+	return runSetup(false)
+}
+
+// runSetup creates a new local config. If the bucket already holds a
+// repository key, the key material is recovered from it instead of being
+// generated; requireRepoKey makes a missing repository key an error.
+func runSetup(requireRepoKey bool) (*config.Config, error) {
 	color.New(color.BgWhite).Println("Set up master password")
 	color.Yellow(Wrap("⚠ Forgetting your master password will result in data loss.  Be sure to write it down somewhere safe.", 60))
 	fmt.Println()
@@ -25,7 +35,7 @@ func setup() (*config.Config, error) {
 	color.New(color.BgWhite).Println("Set up config")
 	fmt.Println()
 
-	cfg, err := setupConfig(password)
+	cfg, err := setupConfig(password, requireRepoKey)
 	if err != nil {
 		return nil, err
 	}
@@ -37,12 +47,12 @@ func setup() (*config.Config, error) {
 		Padding(0, 1).
 		BorderForeground(lipgloss.Color("63"))
 
-	fmt.Println(boxStyle.Render(fmt.Sprintf("Public Key: %s", cfg.AgePublicKey)))
+	fmt.Println(boxStyle.Render(fmt.Sprintf("Public Key: %s\nSigning Key: %s", cfg.AgePublicKey, cfg.SigningPublicKey)))
 
 	return cfg, nil
 }
 
-func setupConfig(password string) (*config.Config, error) {
+func setupConfig(password string, requireRepoKey bool) (*config.Config, error) {
 	questions := []*survey.Question{
 		{
 			Name: "keyid",
@@ -83,37 +93,153 @@ func setupConfig(password string) (*config.Config, error) {
 		Region     string
 	}
 
-	if err := survey.Ask(questions, &configAnswers); err != nil {
+	if err := survey.Ask(questions, &configAnswers, promptOpts...); err != nil {
+		return nil, err
+	}
+
+	cfg := config.Config{
+		KeyID:      configAnswers.KeyID,
+		AppKey:     configAnswers.AppKey,
+		BucketName: configAnswers.BucketName,
+		Region:     configAnswers.Region,
+	}
+
+	ctx := context.Background()
+	b2Client, err := initB2Client(ctx, &cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := fetchRecoveryKey(ctx, b2Client)
+	if err != nil {
+		return nil, err
+	}
+
+	useRepoKey := blob != nil
+	if useRepoKey && !requireRepoKey {
+		color.Yellow("\nℹ This bucket already has a repository key.")
+		prompt := &survey.Confirm{
+			Message: "Recover keys from it?",
+			Default: true,
+			Help:    "Answer no to create your own identity, e.g. to receive shared backups from the repository owner.",
+		}
+		if err := survey.AskOne(prompt, &useRepoKey, promptOpts...); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case useRepoKey:
+		if err := recoverKeys(&cfg, blob); err != nil {
+			return nil, err
+		}
+	case blob != nil:
+		// Personal identity in a shared bucket: keep the owner's repository key.
+		if err := generateKeys(&cfg); err != nil {
+			return nil, err
+		}
+	case requireRepoKey:
+		return nil, fmt.Errorf("no repository key found at %s in bucket %s", config.RecoveryKeyObject, cfg.BucketName)
+	default:
+		if err := generateKeys(&cfg); err != nil {
+			return nil, err
+		}
+		if err := publishRecoveryKey(ctx, b2Client, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.Save(cfg, password); err != nil {
 		return nil, err
 	}
 
+	return &cfg, nil
+}
+
+// generateKeys fills cfg with a fresh age identity, master key and signing key.
+func generateKeys(cfg *config.Config) error {
 	fmt.Println("\nℹ Generating encryption keys...")
 
 	publicKey, privateKey, err := enc.GenerateKey()
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate encryption keys: %w", err)
+		return fmt.Errorf("failed to generate encryption keys: %w", err)
 	}
 
 	masterKey := make([]byte, 64)
 	if _, err := rand.Read(masterKey); err != nil {
-		return nil, fmt.Errorf("failed to generate master key: %w", err)
+		return fmt.Errorf("failed to generate master key: %w", err)
 	}
 
-	cfg := config.Config{
-		KeyID:         configAnswers.KeyID,
-		AppKey:        configAnswers.AppKey,
-		BucketName:    configAnswers.BucketName,
-		Region:        configAnswers.Region,
-		AgePublicKey:  publicKey,
-		AgePrivateKey: privateKey,
-		MasterKey:     masterKey,
+	cfg.AgePublicKey = publicKey
+	cfg.AgePrivateKey = privateKey
+	cfg.MasterKey = masterKey
+
+	if _, err := cfg.EnsureSigningKey(); err != nil {
+		return fmt.Errorf("failed to generate signing key: %w", err)
 	}
+	return nil
+}
 
-	if err := config.Save(cfg, password); err != nil {
-		return nil, err
+// fetchRecoveryKey downloads the repository key blob, returning nil if the
+// bucket does not have one yet.
+func fetchRecoveryKey(ctx context.Context, s storage.Storage) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, _, err := s.Download(ctx, config.RecoveryKeyObject, &buf); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to check for repository key: %w", err)
 	}
+	return buf.Bytes(), nil
+}
 
-	return &cfg, nil
+// recoverKeys asks for the repository passphrase and applies the key material
+// stored in blob to cfg.
+func recoverKeys(cfg *config.Config, blob []byte) error {
+	passphrase, err := askRepositoryPassphrase()
+	if err != nil {
+		return fmt.Errorf("failed to get repository passphrase: %w", err)
+	}
+
+	rk, err := config.OpenRecoveryKey(blob, passphrase)
+	if err != nil {
+		return err
+	}
+	rk.Apply(cfg)
+
+	// Repository keys published before envelope signing carry no signing key.
+	if _, err := cfg.EnsureSigningKey(); err != nil {
+		return fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	color.Green("✓ Keys recovered from repository!")
+	return nil
+}
+
+// publishRecoveryKey seals the key material in cfg to a new repository
+// passphrase and uploads it to the bucket.
+func publishRecoveryKey(ctx context.Context, s storage.Storage, cfg *config.Config) error {
+	fmt.Println()
+	color.New(color.BgWhite).Println("Set up repository passphrase")
+	color.Yellow(Wrap("⚠ The repository passphrase lets any machine with your bucket credentials recover your keys. Keep it separate from your master password.", 60))
+	fmt.Println()
+
+	passphrase, err := setupRepositoryPassphrase()
+	if err != nil {
+		return err
+	}
+
+	blob, err := config.NewRecoveryKey(cfg).Seal(passphrase)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Upload(ctx, config.RecoveryKeyObject, bytes.NewReader(blob), "application/octet-stream", nil); err != nil {
+		return fmt.Errorf("failed to upload repository key: %w", err)
+	}
+
+	color.Green("✓ Repository key uploaded to %s", config.RecoveryKeyObject)
+	return nil
 }
 
 func setupMasterPassword() (string, error) {
@@ -140,7 +266,7 @@ func setupMasterPassword() (string, error) {
 		Confirm  string
 	}
 
-	if err := survey.Ask(masterPasswordQuestions, &passwordAnswers); err != nil {
+	if err := survey.Ask(masterPasswordQuestions, &passwordAnswers, promptOpts...); err != nil {
 		return "", err
 	}
 
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/thebluefowl/burrow/internal/share"
+)
+
+var (
+	shareRecipients []string
+)
+
+var shareCmd = &cobra.Command{
+	Use:   "share <object-id>",
+	Short: "Re-seal a backup's envelope to additional recipients",
+	Long: `Re-seals the envelope of the specified object so that the given age recipients can
+open it. The data key is stored in the envelope, so recipients can restore the
+backup with only their own age identity. An unsigned envelope, written before
+signing existed, is signed with your key, so share only from a bucket you trust.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runShare,
+}
+
+func init() {
+	shareCmd.Flags().StringArrayVarP(&shareRecipients, "recipient", "r", nil, "Recipient public key (repeatable)")
+	_ = shareCmd.MarkFlagRequired("recipient")
+}
+
+// runShare is the main entry point for the share command
+func runShare(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	objectID := args[0]
+
+	cfg, keys, err := loadKeyring()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	b2Client, err := initB2Client(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := share.Share(ctx, cfg, keys, b2Client, objectID, shareRecipients); err != nil {
+		return err
+	}
+
+	color.Green("✓ Shared %s with %d recipient(s)\n", objectID, len(shareRecipients))
+	return nil
+}
@@ -3,38 +3,176 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"github.com/thebluefowl/burrow/internal/archive"
+	"github.com/thebluefowl/burrow/internal/compress"
 	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/settings"
 	"github.com/thebluefowl/burrow/internal/upload"
 )
 
+var (
+	keyModeFlag string
+	privateFlag bool
+	bundleFlag  bool
+	nameFlag    string
+
+	excludeFlags          []string
+	excludeFromFlags      []string
+	excludeCachesFlag     bool
+	ignoreCaseFlag        bool
+	excludeLargerThanFlag settings.Size
+	specialFilesFlag      bool
+	onChangeFlag          string
+	snapshotPreFlag       string
+	snapshotPostFlag      string
+	snapshotOfFlag        string
+	preUploadHook         string
+	postUploadHook        string
+
+	compressionFlag string
+	dictFlag        string
+	uploadSettings  settings.Settings
+)
+
 var uploadCmd = &cobra.Command{
-	Use:   "upload <file-or-directory>",
+	Use:   "upload <file-or-directory>... | -",
 	Short: "Encrypt and upload a file or directory to Backblaze B2",
-	Long:  `Encrypts the specified file or directory (as a tar archive) and uploads it to Backblaze B2.`,
-	Args:  cobra.ExactArgs(1),
-	RunE:  runUpload,
+	Long: `Encrypts the specified files and directories (as one tar archive) and uploads it to Backblaze B2.
+
+Paths matching --exclude patterns or the rules of a .burrowignore file
+(gitignore syntax, applying to its directory and below) are left out.
+
+With "-" the raw bytes read from stdin are uploaded instead, e.g.
+  pg_dump mydb | burrow upload - --name db.sql`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runUpload,
+}
+
+func init() {
+	uploadCmd.Flags().StringVar(&keyModeFlag, "key-mode", "", "How the envelope holds the data key: derived or wrapped (default from config)")
+	uploadCmd.Flags().BoolVar(&privateFlag, "private", false, "Use a random object ID and pad the encrypted size (default from config)")
+	uploadCmd.Flags().BoolVar(&bundleFlag, "bundle", false, "Store the envelope inside the data object; implies --private (default from config)")
+	uploadCmd.Flags().StringVar(&nameFlag, "name", "stdin", "File `name` recorded for an upload from stdin")
+	uploadCmd.Flags().StringVar(&compressionFlag, "compression", "", "Compression codec or auto: "+codecNames()+" (default from config)")
+	uploadCmd.Flags().StringVar(&dictFlag, "dict", "", "Trained zstd dictionary `file` to compress with (see 'burrow dict train')")
+	uploadCmd.Flags().StringArrayVar(&excludeFlags, "exclude", nil, "Skip paths matching this glob `pattern` (e.g. '*.tmp', 'node_modules/', '!keep.tmp'); repeatable")
+	uploadCmd.Flags().StringArrayVar(&excludeFromFlags, "exclude-from", nil, "Read exclude patterns from `file`, one per line; repeatable")
+	uploadCmd.Flags().BoolVar(&ignoreCaseFlag, "ignore-case", false, "Match exclude patterns and .burrowignore rules case-insensitively")
+	uploadCmd.Flags().BoolVar(&excludeCachesFlag, "exclude-caches", false, "Skip the content of directories tagged with CACHEDIR.TAG")
+	uploadCmd.Flags().Var(sizeValue{&excludeLargerThanFlag}, "exclude-larger-than", "Skip files larger than this size, e.g. 1GiB")
+	uploadCmd.Flags().BoolVar(&specialFilesFlag, "special-files", false, "Archive device nodes and named pipes instead of skipping them")
+	uploadCmd.Flags().StringVar(&onChangeFlag, "on-change", "", "What to do with a file that changes while it is read: fail, warn or retry (default from config, else fail)")
+	uploadCmd.Flags().StringVar(&snapshotPreFlag, "snapshot-pre", "", "Shell `command` that creates and mounts a snapshot and prints its mount point; sources are read from it")
+	uploadCmd.Flags().StringVar(&snapshotPostFlag, "snapshot-post", "", "Shell `command` that releases the snapshot ($BURROW_SNAPSHOT), run even if the upload fails")
+	uploadCmd.Flags().StringVar(&snapshotOfFlag, "snapshot-of", "", "Directory the snapshot is taken of (default from config, else /)")
+	uploadCmd.Flags().StringVar(&preUploadHook, "pre-hook", "", "Shell `command` to run before the upload; if it fails, nothing is uploaded (default from config)")
+	uploadCmd.Flags().StringVar(&postUploadHook, "post-hook", "", "Shell `command` to run after the upload, even if it failed (default from config)")
+	addSettingsFlags(uploadCmd, &uploadSettings)
+}
+
+// codecNames lists the registered compression codecs for help texts.
+func codecNames() string {
+	names := []string{string(compress.CompressAuto)}
+	for _, c := range compress.Codecs() {
+		names = append(names, string(c))
+	}
+	return strings.Join(names, ", ")
 }
 
 // runUpload is the main entry point for the upload command
 func runUpload(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
-	sourcePath := args[0]
+	stdin := args[0] == "-"
+	if stdin {
+		if len(args) > 1 {
+			return fmt.Errorf("- (stdin) cannot be combined with other paths")
+		}
+		promptOnTerminal()
+	}
 
-	cfg, err := loadOrSetupConfig()
+	switch keyModeFlag {
+	case "", envelope.KeyModeDerived, envelope.KeyModeWrapped:
+	default:
+		return fmt.Errorf("invalid key mode %q (want %s or %s)", keyModeFlag, envelope.KeyModeDerived, envelope.KeyModeWrapped)
+	}
+
+	cfg, keys, err := loadKeyring()
 	if err != nil {
 		return fmt.Errorf("config error: %w", err)
 	}
+	if keyModeFlag != "" {
+		cfg.KeyMode = keyModeFlag
+	}
+	if privateFlag {
+		cfg.Privacy = true
+	}
+	if bundleFlag {
+		cfg.Bundle = true
+	}
+	if err := applySettings(cfg, uploadSettings); err != nil {
+		return err
+	}
+	if compressionFlag != "" {
+		cfg.Compression = compressionFlag
+	}
+	if dictFlag != "" {
+		cfg.CompressionDictionary = dictFlag
+	}
+	if specialFilesFlag {
+		cfg.SpecialFiles = true
+	}
+	if onChangeFlag != "" {
+		cfg.ChangePolicy = onChangeFlag
+	}
+	if _, err := archive.ParseChangePolicy(cfg.ChangePolicy); err != nil {
+		return err
+	}
+	if snapshotPreFlag != "" {
+		cfg.Snapshot.Pre = snapshotPreFlag
+	}
+	if snapshotPostFlag != "" {
+		cfg.Snapshot.Post = snapshotPostFlag
+	}
+	if snapshotOfFlag != "" {
+		cfg.Snapshot.Of = snapshotOfFlag
+	}
+	if preUploadHook != "" {
+		cfg.Hooks.PreUpload = preUploadHook
+	}
+	if postUploadHook != "" {
+		cfg.Hooks.PostUpload = postUploadHook
+	}
+	if err := applyExcludes(cfg); err != nil {
+		return err
+	}
+	mode, err := compress.ParseMode(cfg.Compression)
+	if err != nil {
+		return err
+	}
+	if cfg.CompressionDictionary != "" && !compress.SupportsDictionary(mode) {
+		return fmt.Errorf("codec %q does not support dictionaries", mode)
+	}
 
 	b2Client, err := initB2Client(ctx, cfg)
 	if err != nil {
 		return err
 	}
 
-	uploader := upload.NewUploader(cfg, sourcePath, b2Client)
-	if err := uploader.Execute(); err != nil {
+	var uploader *upload.Uploader
+	if stdin {
+		uploader = upload.NewStreamUploader(cfg, keys, os.Stdin, nameFlag, b2Client)
+	} else {
+		uploader = upload.NewUploader(cfg, keys, args, b2Client)
+	}
+	err = uploader.Execute()
+	printWarnings(uploader.Warnings())
+	if err != nil {
 		return err
 	}
 
@@ -42,6 +180,53 @@ func runUpload(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// printWarnings shows the problems that didn't stop an upload or download.
+func printWarnings(warnings []error) {
+	for _, w := range warnings {
+		color.Yellow("⚠ %v", w)
+	}
+}
+
+// applyExcludes adds the exclusion flags to the config's defaults.
+func applyExcludes(cfg *config.Config) error {
+	cfg.Exclude = append(cfg.Exclude, excludeFlags...)
+	for _, path := range excludeFromFlags {
+		patterns, err := readPatterns(path)
+		if err != nil {
+			return fmt.Errorf("exclude-from: %w", err)
+		}
+		cfg.Exclude = append(cfg.Exclude, patterns...)
+	}
+	if ignoreCaseFlag {
+		cfg.ExcludeIgnoreCase = true
+	}
+	if excludeCachesFlag {
+		cfg.ExcludeCaches = true
+	}
+	if excludeLargerThanFlag > 0 {
+		cfg.ExcludeLargerThan = excludeLargerThanFlag
+	}
+	return archive.ValidateOptions(archive.Options{Exclude: cfg.Exclude, IgnoreCase: cfg.ExcludeIgnoreCase})
+}
+
+// readPatterns reads one pattern per line, skipping blank lines and
+// comments starting with "#".
+func readPatterns(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
+}
+
 // printUploadSuccess displays a success message
 func printUploadSuccess(objectID string) {
 	color.Green("✓ Successfully uploaded to B2: %s\n", objectID+".enc")
@@ -53,15 +238,34 @@ func loadOrSetupConfig() (*config.Config, error) {
 		return setup()
 	}
 
+	cfg, _, err := loadConfigWithPassword()
+	return cfg, err
+}
+
+// loadConfigWithPassword loads the existing config and also returns the master
+// password, for commands that need to save the config again.
+func loadConfigWithPassword() (*config.Config, string, error) {
 	password, err := askMasterPassword()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get master password: %w", err)
+		return nil, "", fmt.Errorf("failed to get master password: %w", err)
 	}
 
 	cfg, err := config.Load(password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load config: %w", err)
+		return nil, "", fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Configs created before envelope signing get a signing key on first use.
+	generated, err := cfg.EnsureSigningKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	if generated {
+		if err := config.Save(*cfg, password); err != nil {
+			return nil, "", err
+		}
+		color.Yellow("ℹ Generated an envelope signing key; run `burrow migrate envelopes` to sign existing backups")
 	}
 
-	return cfg, nil
+	return cfg, password, nil
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/thebluefowl/burrow/internal/agent"
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/download"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/storage"
+)
+
+// memStorage is an in-memory storage.Storage.
+type memStorage struct {
+	objects map[string][]byte
+}
+
+func (m *memStorage) Upload(_ context.Context, key string, body io.Reader, _ string, _ map[string]string) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	m.objects[key] = b
+	return nil
+}
+
+func (m *memStorage) Download(_ context.Context, key string, w io.Writer) (string, map[string]string, error) {
+	b, ok := m.objects[key]
+	if !ok {
+		return "", nil, storage.ErrNotFound
+	}
+	_, err := w.Write(b)
+	return "", nil, err
+}
+
+func (m *memStorage) GetMetadata(_ context.Context, key string) (map[string]string, error) {
+	if _, ok := m.objects[key]; !ok {
+		return nil, storage.ErrNotFound
+	}
+	return nil, nil
+}
+
+func (m *memStorage) List(_ context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	var out []storage.ObjectInfo
+	for k, v := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, storage.ObjectInfo{Key: k, Size: int64(len(v))})
+		}
+	}
+	return out, nil
+}
+
+// useTestRepo serves a fresh config from an agent, so that commands don't
+// prompt for a password, and replaces B2 with in-memory storage. It returns
+// a copy of the config and the storage.
+func useTestRepo(t *testing.T) (*config.Config, *memStorage) {
+	t.Helper()
+	pub, priv, err := enc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := make([]byte, 64)
+	rand.Read(master)
+	cfg := &config.Config{AgePublicKey: pub, AgePrivateKey: priv, MasterKey: master}
+	if _, err := cfg.EnsureSigningKey(); err != nil {
+		t.Fatal(err)
+	}
+	// Keep an independent copy: NewServer wipes the master key from cfg.
+	local := *cfg
+	local.MasterKey = bytes.Clone(cfg.MasterKey)
+
+	// Unix socket paths are short; t.TempDir() may exceed the limit.
+	dir, err := os.MkdirTemp("", "burrow-cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "s", "agent.sock")
+	server, err := agent.NewServer(cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := agent.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go server.Serve(ctx, l)
+	t.Cleanup(cancel)
+	t.Setenv(agent.SocketEnv, path)
+
+	s := &memStorage{objects: map[string][]byte{}}
+	orig := initB2Client
+	initB2Client = func(context.Context, *config.Config) (storage.Storage, error) { return s, nil }
+	t.Cleanup(func() { initB2Client = orig })
+	return &local, s
+}
+
+func TestUploadSeveralPaths(t *testing.T) {
+	cfg, s := useTestRepo(t)
+	root := t.TempDir()
+	a := filepath.Join(root, "a.txt")
+	b := filepath.Join(root, "docs")
+	os.WriteFile(a, []byte("a"), 0o644)
+	os.Mkdir(b, 0o755)
+	os.WriteFile(filepath.Join(b, "b.txt"), []byte("b"), 0o644)
+
+	rootCmd.SetArgs([]string{"upload", "--no-progress", a, b})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, _ := s.List(context.Background(), "keys/")
+	if len(infos) != 1 {
+		t.Fatalf("%d envelopes stored, want 1", len(infos))
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(infos[0].Key, "keys/"), ".envelope")
+	var buf bytes.Buffer
+	if err := download.NewStreamDownloader(cfg, keyring.NewLocal(cfg), id, &buf, false, s).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	for _, want := range []string{"a.txt", "docs/b.txt"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("archive holds %v, want %s", names, want)
+		}
+	}
+}
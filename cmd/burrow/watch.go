@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/thebluefowl/burrow/internal/compress"
+	"github.com/thebluefowl/burrow/internal/progress"
+	"github.com/thebluefowl/burrow/internal/watch"
+)
+
+var (
+	watchDebounce    time.Duration
+	watchMaxDelay    time.Duration
+	watchMinInterval time.Duration
+	watchStateDir    string
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch <directory>",
+	Short: "Back up a directory continuously as it changes",
+	Long: `Watches a directory and uploads its changes until interrupted. The first
+upload holds the whole directory; later ones hold only the files that changed
+since the previous upload and record the paths deleted since, each naming its
+predecessor in the envelope.
+
+Changes are batched: a batch is uploaded once the directory has been quiet for
+--debounce, or after --max-delay while changes keep coming, and never sooner
+than --min-interval after the previous upload. On start the directory is
+rescanned against the file index of the last upload, so changes made while
+no watcher ran are uploaded first.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runWatch,
+}
+
+func init() {
+	watchCmd.Flags().DurationVar(&watchDebounce, "debounce", 10*time.Second, "Upload once the directory has been quiet this long")
+	watchCmd.Flags().DurationVar(&watchMaxDelay, "max-delay", 10*time.Minute, "Upload a batch after this long even if changes keep coming")
+	watchCmd.Flags().DurationVar(&watchMinInterval, "min-interval", time.Minute, "Least time between two uploads")
+	watchCmd.Flags().StringVar(&watchStateDir, "state-dir", "", "Directory for the file index and lock (default <config dir>/burrow/watch)")
+	watchCmd.Flags().StringVar(&compressionFlag, "compression", "", "Compression codec or auto: "+codecNames()+" (default from config)")
+	watchCmd.Flags().StringArrayVar(&excludeFlags, "exclude", nil, "Skip paths matching this glob `pattern`; repeatable")
+	watchCmd.Flags().StringArrayVar(&excludeFromFlags, "exclude-from", nil, "Read exclude patterns from `file`, one per line; repeatable")
+	watchCmd.Flags().BoolVar(&ignoreCaseFlag, "ignore-case", false, "Match exclude patterns and .burrowignore rules case-insensitively")
+	watchCmd.Flags().BoolVar(&excludeCachesFlag, "exclude-caches", false, "Skip the content of directories tagged with CACHEDIR.TAG")
+	watchCmd.Flags().Var(sizeValue{&excludeLargerThanFlag}, "exclude-larger-than", "Skip files larger than this size, e.g. 1GiB")
+}
+
+// runWatch is the main entry point for the watch command
+func runWatch(cmd *cobra.Command, args []string) error {
+	stateDir := watchStateDir
+	if stateDir == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return fmt.Errorf("failed to get config directory: %w", err)
+		}
+		stateDir = filepath.Join(dir, "burrow", "watch")
+	}
+
+	cfg, keys, err := loadKeyring()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+	if compressionFlag != "" {
+		cfg.Compression = compressionFlag
+	}
+	if _, err := compress.ParseMode(cfg.Compression); err != nil {
+		return err
+	}
+	if err := applyExcludes(cfg); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	b2Client, err := initB2Client(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	w, err := watch.New(cfg, keys, b2Client, args[0], watch.Options{
+		Debounce:    watchDebounce,
+		MaxDelay:    watchMaxDelay,
+		MinInterval: watchMinInterval,
+		StateDir:    stateDir,
+		Log:         log.New(os.Stderr, "", log.LstdFlags),
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	// Uploads are logged instead.
+	progress.SetEnabled(false)
+	color.Green("✓ Watching %s; press Ctrl-C to stop", w.State().Dir)
+	return w.Run(ctx)
+}
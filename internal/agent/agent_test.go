@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/keyring"
+)
+
+func newTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	pub, priv, err := enc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{BucketName: "bucket", AgePublicKey: pub, AgePrivateKey: priv, MasterKey: bytes.Repeat([]byte{7}, 64)}
+	if _, err := cfg.EnsureSigningKey(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// startAgent serves cfg on a fresh socket and returns a connected client.
+func startAgent(t *testing.T, cfg *config.Config, ttl time.Duration) (*Client, <-chan error) {
+	t.Helper()
+	// Unix socket paths are short; t.TempDir() may exceed the limit.
+	dir, err := os.MkdirTemp("", "burrow-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "s", "agent.sock")
+
+	server, err := NewServer(cfg, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, l) }()
+	t.Cleanup(cancel)
+
+	client, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, done
+}
+
+func TestAgentKeyOperations(t *testing.T) {
+	cfg := newTestConfig(t)
+	// Keep an independent copy: NewServer wipes the master key from cfg.
+	local := *cfg
+	local.MasterKey = bytes.Clone(cfg.MasterKey)
+	localKeys := keyring.NewLocal(&local)
+
+	client, _ := startAgent(t, cfg, 0)
+
+	public, err := client.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public.BucketName != "bucket" || public.AgePublicKey != local.AgePublicKey {
+		t.Errorf("Config() lost public fields: %+v", public)
+	}
+	if len(public.MasterKey) != 0 || public.AgePrivateKey != "" || public.SigningPrivateKey != "" {
+		t.Error("Config() must not return key material")
+	}
+
+	want, err := localKeys.DeriveDataKey("obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := client.DeriveDataKey("obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("agent derived a different data key")
+	}
+
+	env := envelope.NewEnvelope("obj", "file.txt")
+	env.SetDerivedKey(want)
+	if env.Encryption.Params, err = enc.NewAEADParams("obj", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Sign(client); err != nil {
+		t.Fatalf("Sign() via agent: %v", err)
+	}
+	sealed, err := env.Seal([]string{local.AgePublicKey}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e envelope.Envelope
+	opened, err := e.Open(sealed, client.DecryptConfig())
+	if err != nil {
+		t.Fatalf("Open() via agent: %v", err)
+	}
+	if err := opened.Verify(envelope.Trust{Signers: local.Signers()}); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	if _, err := opened.ResolveDataKey(client); err != nil {
+		t.Errorf("ResolveDataKey() via agent: %v", err)
+	}
+
+	other, _, err := enc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := env.Seal([]string{other}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Open(foreign, client.DecryptConfig()); err == nil {
+		t.Error("agent should not open envelopes for other identities")
+	}
+}
+
+func TestAgentExpires(t *testing.T) {
+	client, done := startAgent(t, newTestConfig(t), 100*time.Millisecond)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Serve() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not expire")
+	}
+	if _, err := client.DeriveDataKey("obj"); err == nil {
+		t.Error("expired agent should not answer")
+	}
+}
+
+func TestAgentExpiresDuringRequests(t *testing.T) {
+	client, done := startAgent(t, newTestConfig(t), 50*time.Millisecond)
+
+	// Requests racing the expiry either get an answer or fail to connect;
+	// none may see the wiped keys.
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, _, err := client.Sign([]byte("msg")); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := <-done; err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+}
+
+func TestListenLeavesSharedDirectories(t *testing.T) {
+	dir, err := os.MkdirTemp("", "burrow-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if l, err := Listen(filepath.Join(dir, "agent.sock")); err == nil {
+		l.Close()
+		t.Fatal("Listen() in a directory others can access succeeded")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o755 {
+		t.Errorf("directory mode changed to %v", info.Mode().Perm())
+	}
+}
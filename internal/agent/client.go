@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"filippo.io/age"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
+)
+
+// Client talks to a running agent. It implements keyring.Keyring.
+type Client struct {
+	path string
+}
+
+// Dial connects to the agent at path and checks that it is answering.
+func Dial(path string) (*Client, error) {
+	c := &Client{path: path}
+	if _, err := c.Config(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Config returns the agent's config without any key material.
+func (c *Client) Config() (*config.Config, error) {
+	resp, err := c.call(&request{Op: opConfig})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Config == nil {
+		return nil, errors.New("agent returned no config")
+	}
+	return resp.Config, nil
+}
+
+func (c *Client) DeriveDataKey(objectID string) ([]byte, error) {
+	resp, err := c.call(&request{Op: opDerive, ObjectID: objectID})
+	if err != nil {
+		return nil, err
+	}
+	return resp.DataKey, nil
+}
+
+func (c *Client) DecryptConfig() enc.DecryptConfig {
+	return enc.DecryptConfig{AgeIdentities: []age.Identity{identity{c}}}
+}
+
+func (c *Client) Sign(msg []byte) (string, []byte, error) {
+	resp, err := c.call(&request{Op: opSign, Message: msg})
+	if err != nil {
+		return "", nil, err
+	}
+	return resp.PublicKey, resp.Signature, nil
+}
+
+func (c *Client) call(req *request) (*response, error) {
+	conn, err := net.DialTimeout("unix", c.path, connTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connect to agent at %s: %w", c.path, err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("agent %s request: %w", req.Op, err)
+	}
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("agent %s response: %w", req.Op, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("agent %s: %s", req.Op, resp.Error)
+	}
+	return &resp, nil
+}
+
+// identity is an age identity whose file key unwrapping happens in the agent.
+type identity struct {
+	c *Client
+}
+
+func (i identity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	resp, err := i.c.call(&request{Op: opUnwrap, Stanzas: stanzas})
+	if err != nil {
+		return nil, err
+	}
+	if resp.NoMatch {
+		return nil, age.ErrIncorrectIdentity
+	}
+	return resp.FileKey, nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package agent
+
+// lockMemory is a no-op on platforms without mlock.
+func lockMemory(b []byte) error { return nil }
+
+func unlockMemory(b []byte) error { return nil }
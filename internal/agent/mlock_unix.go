@@ -0,0 +1,20 @@
+//go:build unix
+
+package agent
+
+import "golang.org/x/sys/unix"
+
+// lockMemory keeps b out of swap.
+func lockMemory(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return unix.Mlock(b)
+}
+
+func unlockMemory(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return unix.Munlock(b)
+}
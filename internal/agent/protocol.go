@@ -0,0 +1,41 @@
+// Package agent implements burrow agent, a daemon that keeps an unlocked
+// config in memory and performs key operations for other burrow processes
+// over a Unix socket, so the master key never leaves it.
+package agent
+
+import (
+	"filippo.io/age"
+
+	"github.com/thebluefowl/burrow/internal/config"
+)
+
+// SocketEnv is the environment variable burrow reads the agent socket from.
+const SocketEnv = "BURROW_AGENT_SOCK"
+
+// Operations understood by the agent. Each connection carries exactly one
+// JSON request followed by one JSON response.
+const (
+	opConfig = "config"
+	opDerive = "derive"
+	opUnwrap = "unwrap"
+	opSign   = "sign"
+)
+
+type request struct {
+	Op       string        `json:"op"`
+	ObjectID string        `json:"object_id,omitempty"`
+	Stanzas  []*age.Stanza `json:"stanzas,omitempty"`
+	Message  []byte        `json:"message,omitempty"`
+}
+
+type response struct {
+	Error string `json:"error,omitempty"`
+	// NoMatch is set when no identity in the agent can unwrap the stanzas.
+	NoMatch bool `json:"no_match,omitempty"`
+
+	Config    *config.Config `json:"config,omitempty"`
+	DataKey   []byte         `json:"data_key,omitempty"`
+	FileKey   []byte         `json:"file_key,omitempty"`
+	PublicKey string         `json:"public_key,omitempty"`
+	Signature []byte         `json:"signature,omitempty"`
+}
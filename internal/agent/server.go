@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
+)
+
+// connTimeout bounds how long a single client may hold a connection.
+const connTimeout = 30 * time.Second
+
+// Server holds an unlocked config and answers key operations.
+type Server struct {
+	public     *config.Config
+	masterKey  []byte
+	identities []age.Identity
+	signer     enc.Signer
+	ttl        time.Duration
+	locked     bool
+
+	// handlers counts the connections being answered, which must finish
+	// before the keys are wiped.
+	handlers sync.WaitGroup
+}
+
+// NewServer takes ownership of the secrets in cfg. The master key is moved
+// into locked memory where the platform allows it (see MemoryLocked) and
+// wiped from cfg. The age identities and the signing key stay in the
+// types of the age and enc packages, which cannot be locked. A ttl of zero keeps the agent running until its context is
+// cancelled.
+func NewServer(cfg *config.Config, ttl time.Duration) (*Server, error) {
+	identities, err := enc.ParseIdentities(enc.DecryptConfig{Identities: cfg.Identities()})
+	if err != nil {
+		return nil, err
+	}
+
+	masterKey := make([]byte, len(cfg.MasterKey))
+	locked := lockMemory(masterKey) == nil
+	copy(masterKey, cfg.MasterKey)
+	clear(cfg.MasterKey)
+
+	return &Server{
+		public:     publicConfig(cfg),
+		masterKey:  masterKey,
+		identities: identities,
+		signer:     enc.KeySigner(cfg.SigningPrivateKey),
+		ttl:        ttl,
+		locked:     locked,
+	}, nil
+}
+
+// MemoryLocked reports whether the master key is kept out of swap.
+func (s *Server) MemoryLocked() bool {
+	return s.locked
+}
+
+// publicConfig returns a copy of cfg without key material. Bucket
+// credentials are kept, since clients talk to storage themselves.
+func publicConfig(cfg *config.Config) *config.Config {
+	public := *cfg
+	public.MasterKey = nil
+	public.AgePrivateKey = ""
+	public.RetiredAgePrivateKeys = nil
+	public.SigningPrivateKey = ""
+	public.IdentityFiles = nil
+	return &public
+}
+
+// DefaultSocketPath returns the socket path used when none is given:
+// $XDG_RUNTIME_DIR/burrow/agent.sock, or a per-user directory in the
+// system temp dir.
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "burrow", "agent.sock")
+	}
+	return filepath.Join(os.TempDir(), "burrow-"+strconv.Itoa(os.Getuid()), "agent.sock")
+}
+
+// Listen creates the socket at path in a directory only the current user can
+// access. The directory is created if it doesn't exist; an existing one must
+// already be private, as it is never chmodded. A stale socket from a
+// previous agent is replaced.
+func Listen(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	err := os.Mkdir(dir, 0o700)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err = os.MkdirAll(filepath.Dir(dir), 0o700); err == nil {
+			err = os.Mkdir(dir, 0o700)
+		}
+	}
+	switch {
+	case err == nil:
+		// Ours, so fix whatever the umask did.
+		if err := os.Chmod(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("restrict socket directory: %w", err)
+		}
+	case !errors.Is(err, fs.ErrExist):
+		return nil, fmt.Errorf("create socket directory: %w", err)
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("an agent is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("restrict socket: %w", err)
+	}
+	return l, nil
+}
+
+// Serve answers requests on l until ctx is cancelled or the TTL expires. l
+// is closed and the requests being answered are finished before the keys
+// are wiped and Serve returns.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	defer s.wipe()
+	defer s.handlers.Wait()
+	defer l.Close()
+
+	if s.ttl > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.ttl)
+		defer cancel()
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		s.handlers.Add(1)
+		go func() {
+			defer s.handlers.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) wipe() {
+	clear(s.masterKey)
+	if s.locked {
+		_ = unlockMemory(s.masterKey)
+	}
+	s.identities = nil
+	s.signer = nil
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(connTimeout))
+
+	var req request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	_ = json.NewEncoder(conn).Encode(s.respond(&req))
+}
+
+func (s *Server) respond(req *request) *response {
+	switch req.Op {
+	case opConfig:
+		return &response{Config: s.public}
+
+	case opDerive:
+		key, err := enc.DeriveDataKey(s.masterKey, req.ObjectID)
+		if err != nil {
+			return &response{Error: err.Error()}
+		}
+		return &response{DataKey: key}
+
+	case opUnwrap:
+		for _, id := range s.identities {
+			fileKey, err := id.Unwrap(req.Stanzas)
+			if errors.Is(err, age.ErrIncorrectIdentity) {
+				continue
+			}
+			if err != nil {
+				return &response{Error: err.Error()}
+			}
+			return &response{FileKey: fileKey}
+		}
+		return &response{NoMatch: true}
+
+	case opSign:
+		pub, sig, err := s.signer.Sign(req.Message)
+		if err != nil {
+			return &response{Error: err.Error()}
+		}
+		return &response{PublicKey: pub, Signature: sig}
+
+	default:
+		return &response{Error: fmt.Sprintf("unknown operation %q", req.Op)}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package agent
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkPrivateDir checks that dir is a directory. Ownership and modes are not
+// checked on platforms without Unix permissions.
+func checkPrivateDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("socket directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("socket directory %s is not a directory", dir)
+	}
+	return nil
+}
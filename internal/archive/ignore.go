@@ -0,0 +1,119 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/thebluefowl/burrow/internal/glob"
+)
+
+// IgnoreFileName is the per-directory file of exclusion rules StreamTar
+// honors when Options.IgnoreFile is set to it.
+const IgnoreFileName = ".burrowignore"
+
+// CacheDirTag marks a cache directory (see https://bford.info/cachedir/).
+// Its content must start with cacheDirSignature.
+const CacheDirTag = "CACHEDIR.TAG"
+
+var cacheDirSignature = []byte("Signature: 8a477f597d28d172789f06886806bc55")
+
+// parseIgnore reads the rules of an ignore file, with glob (gitignore)
+// syntax. Blank lines and lines starting with "#" are skipped; trailing
+// whitespace is dropped.
+func parseIgnore(r io.Reader, opts glob.Options) (glob.List, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return glob.CompileList(lines, opts)
+}
+
+// ignoreTree holds the rules of the ignore files found so far, keyed by the
+// tar path of their directory.
+type ignoreTree struct {
+	file  string
+	opts  glob.Options
+	rules map[string]glob.List
+}
+
+func newIgnoreTree(file string, opts glob.Options) *ignoreTree {
+	return &ignoreTree{file: file, opts: opts, rules: make(map[string]glob.List)}
+}
+
+// load reads the ignore file of the directory dir, archived as dirName.
+func (t *ignoreTree) load(dir, dirName string) error {
+	if t.file == "" {
+		return nil
+	}
+	f, err := os.Open(filepath.Join(dir, t.file))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rules, err := parseIgnore(f, t.opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filepath.Join(dir, t.file), err)
+	}
+	if len(rules) > 0 {
+		t.rules[dirName] = rules
+	}
+	return nil
+}
+
+// forget drops the rules of dirName once its subtree has been walked.
+func (t *ignoreTree) forget(dirName string) {
+	delete(t.rules, dirName)
+}
+
+// ignored reports whether the rules of name's ancestor directories exclude
+// it. Rules in deeper directories and later lines take precedence.
+func (t *ignoreTree) ignored(name string, isDir bool) bool {
+	if len(t.rules) == 0 {
+		return false
+	}
+	var dirs []string
+	for d := path.Dir(name); d != "." && d != "/"; d = path.Dir(d) {
+		dirs = append(dirs, d)
+	}
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel := strings.TrimPrefix(name, dirs[i]+"/")
+		for _, p := range t.rules[dirs[i]] {
+			if p.Match(rel, isDir) {
+				ignored = !p.Negated()
+			}
+		}
+	}
+	return ignored
+}
+
+// isCacheDir reports whether dir holds a valid CACHEDIR.TAG.
+func isCacheDir(dir string) bool {
+	f, err := os.Open(filepath.Join(dir, CacheDirTag))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(cacheDirSignature))
+	if _, err := io.ReadFull(f, head); err != nil {
+		return false
+	}
+	return bytes.Equal(head, cacheDirSignature)
+}
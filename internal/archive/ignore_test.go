@@ -0,0 +1,107 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thebluefowl/burrow/internal/glob"
+)
+
+func TestParseIgnore(t *testing.T) {
+	rules, err := parseIgnore(strings.NewReader("# comment\n\n*.log\n!keep.log\nbuild/  \n\\#hash\n"), glob.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range rules {
+		got = append(got, p.String())
+	}
+	if want := []string{"*.log", "!keep.log", "build/", `\#hash`}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("parseIgnore() = %q, want %q", got, want)
+	}
+	if !rules.Match("#hash", false) {
+		t.Error(`\#hash should match a literal "#hash"`)
+	}
+
+	if _, err := parseIgnore(strings.NewReader("[a-\n"), glob.Options{}); err == nil {
+		t.Error("malformed pattern should fail")
+	}
+}
+
+func TestStreamTarIgnoreFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	writeTree(t, src, map[string]string{
+		".burrowignore":          "*.log\n!keep.log\nbuild/\n/top.txt\n",
+		"a.log":                  "",
+		"keep.log":               "",
+		"top.txt":                "",
+		"main.go":                "",
+		"build/out":              "",
+		"sub/top.txt":            "",
+		"sub/b.log":              "",
+		"sub/build":              "a file, not a directory",
+		"sub/.burrowignore":      "!b.log\n",
+		"sub/deep/x.tmp":         "",
+		"sub/deep/.burrowignore": "*.tmp\n",
+	})
+
+	got := tarNames(t, []string{src}, Options{IncludeRoot: true, Deterministic: true, IgnoreFile: IgnoreFileName})
+	want := []string{
+		"src/", "src/.burrowignore", "src/keep.log", "src/main.go",
+		"src/sub/", "src/sub/.burrowignore", "src/sub/b.log", "src/sub/build",
+		"src/sub/deep/", "src/sub/deep/.burrowignore", "src/sub/top.txt",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("archived %v\nwant %v", got, want)
+	}
+}
+
+func TestStreamTarExcludes(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{
+		"small.txt":          "x",
+		"large.bin":          strings.Repeat("x", 2048),
+		"cache/CACHEDIR.TAG": string(cacheDirSignature) + "\n",
+		"cache/blob":         "",
+		"fake/CACHEDIR.TAG":  "not a cache",
+		"fake/blob":          "",
+		"node_modules/x.js":  "",
+	})
+	other := filepath.Join(dir, "other.txt")
+	writeTree(t, dir, map[string]string{"other.txt": "y"})
+
+	got := tarNames(t, []string{src, other}, Options{
+		IncludeRoot:       true,
+		Deterministic:     true,
+		Exclude:           []string{"src/node_modules/"},
+		ExcludeCaches:     true,
+		ExcludeLargerThan: 1024,
+	})
+	want := []string{
+		"other.txt", "src/", "src/cache/", "src/cache/CACHEDIR.TAG",
+		"src/fake/", "src/fake/CACHEDIR.TAG", "src/fake/blob", "src/small.txt",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("archived %v\nwant %v", got, want)
+	}
+
+	got = tarNames(t, []string{src}, Options{Deterministic: true, Exclude: []string{"*.TXT", "/SRC/CACHE/"}, IgnoreCase: true})
+	want = []string{
+		"src/fake/", "src/fake/CACHEDIR.TAG", "src/fake/blob", "src/large.bin",
+		"src/node_modules/", "src/node_modules/x.js",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("case-insensitive: archived %v\nwant %v", got, want)
+	}
+
+	dup := filepath.Join(dir, "x", "src")
+	os.MkdirAll(dup, 0o755)
+	if err := StreamTarPaths(context.Background(), io.Discard, []string{src, dup}, Options{}); err == nil {
+		t.Error("sources with the same base name should be rejected")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+)
+
+// makeSpecial is not supported on this platform.
+func makeSpecial(target string, hdr *tar.Header) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,24 @@
+//go:build linux || darwin
+
+package archive
+
+import (
+	"archive/tar"
+
+	"golang.org/x/sys/unix"
+)
+
+// makeSpecial creates the device node or named pipe hdr describes. Device
+// nodes usually need root.
+func makeSpecial(target string, hdr *tar.Header) error {
+	mode := uint32(hdr.Mode & 0o7777)
+	switch hdr.Typeflag {
+	case tar.TypeChar:
+		mode |= unix.S_IFCHR
+	case tar.TypeBlock:
+		mode |= unix.S_IFBLK
+	default:
+		return unix.Mkfifo(target, mode)
+	}
+	return unix.Mknod(target, mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))))
+}
@@ -0,0 +1,45 @@
+//go:build linux || darwin
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStreamTarSpecialFiles(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"file": "x"})
+	if err := unix.Mkfifo(filepath.Join(src, "pipe"), 0o600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+
+	// Special files are skipped unless asked for.
+	if got := tarNames(t, []string{src}, Options{Deterministic: true}); len(got) != 1 {
+		t.Errorf("archived %v, want only the regular file", got)
+	}
+
+	var buf bytes.Buffer
+	if err := StreamTar(context.Background(), &buf, src, Options{Deterministic: true, SpecialFiles: true}); err != nil {
+		t.Fatal(err)
+	}
+	if hdr := tarHeaders(t, buf.Bytes())["src/pipe"]; hdr == nil || hdr.Typeflag != tar.TypeFifo || hdr.Mode != 0o600 {
+		t.Fatalf("pipe header = %+v", hdr)
+	}
+
+	dest := filepath.Join(dir, "out")
+	if err := ExtractTar(bytes.NewReader(buf.Bytes()), dest); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(filepath.Join(dest, "src", "pipe"))
+	if err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("extracted pipe = %v, %v", fi, err)
+	}
+}
@@ -0,0 +1,44 @@
+//go:build linux || darwin || freebsd
+
+package archive
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// seekFragments finds the data fragments of f with SEEK_DATA and
+// SEEK_HOLE. It returns nil if the filesystem doesn't support them, and an
+// empty map for a file that is one hole.
+func seekFragments(f *os.File, size int64) ([]fragment, error) {
+	frags := []fragment{}
+	for off := int64(0); off < size; {
+		data, err := f.Seek(off, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			break // only a hole is left
+		}
+		if errors.Is(err, unix.EINVAL) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if data >= size {
+			break
+		}
+		hole, err := f.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		hole = min(hole, size)
+		frags = append(frags, fragment{offset: data, length: hole - data})
+		off = hole
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return frags, nil
+}
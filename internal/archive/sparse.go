@@ -0,0 +1,243 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// Sparse files are written in the PAX sparse format 1.0 that GNU tar and
+// archive/tar read: a PAX header carrying GNU.sparse.* records, then a ustar
+// header whose content is the map of data fragments, padded to a block,
+// followed by the fragments themselves. tar.Writer refuses to encode the
+// PAX header, so addSparse writes it to the underlying writer.
+
+const blockSize = 512
+
+// sparseBlock is the granularity at which extraction recreates holes.
+const sparseBlock = 4096
+
+// fragment is a run of data in a sparse file; everything between fragments
+// is a hole.
+type fragment struct {
+	offset, length int64
+}
+
+// sparseMap returns the data fragments of f, or nil if f has no holes or
+// the platform can't tell where they are. Files whose allocated size isn't
+// below their length are not probed.
+func sparseMap(f *os.File, info fs.FileInfo) ([]fragment, error) {
+	size := info.Size()
+	if alloc, ok := allocatedSize(info); !ok || size == 0 || alloc >= size {
+		return nil, nil
+	}
+	frags, err := seekFragments(f, size)
+	if err != nil || frags == nil {
+		return nil, err
+	}
+
+	var data int64
+	for _, fr := range frags {
+		data += fr.length
+	}
+	if data == size {
+		// Allocated less than its length but without holes, e.g. on a
+		// compressing filesystem.
+		return nil, nil
+	}
+	// The map ends with the end of the file, even if that is a hole.
+	if n := len(frags); n == 0 || frags[n-1].offset+frags[n-1].length < size {
+		frags = append(frags, fragment{offset: size})
+	}
+	return frags, nil
+}
+
+// addSparse writes hdr and the data fragments of f as a sparse entry. Like
+// copyN, it reports whether f ended before its last fragment.
+func (a *archiver) addSparse(hdr *tar.Header, f *os.File, frags []fragment) (short bool, err error) {
+	var m bytes.Buffer
+	fmt.Fprintf(&m, "%d\n", len(frags))
+	var data int64
+	for _, fr := range frags {
+		fmt.Fprintf(&m, "%d\n%d\n", fr.offset, fr.length)
+		data += fr.length
+	}
+	m.Write(make([]byte, padding(int64(m.Len()))))
+
+	records := paxRecord("GNU.sparse.major", "1") +
+		paxRecord("GNU.sparse.minor", "0") +
+		paxRecord("GNU.sparse.name", hdr.Name) +
+		paxRecord("GNU.sparse.realsize", strconv.FormatInt(hdr.Size, 10))
+
+	h := *hdr
+	h.Name = sparseHeaderName(hdr.Name)
+	h.Size = int64(m.Len()) + data
+	h.Format = tar.FormatUSTAR
+	records += fitUSTAR(&h)
+
+	// Finish the previous entry's padding before writing around tw.
+	if err := a.tw.Flush(); err != nil {
+		return false, err
+	}
+	if err := writePAXHeader(a.w, "PaxHeaders.0/"+path.Base(h.Name), records); err != nil {
+		return false, err
+	}
+	if err := a.tw.WriteHeader(&h); err != nil {
+		return false, err
+	}
+	if _, err := a.tw.Write(m.Bytes()); err != nil {
+		return false, err
+	}
+	for _, fr := range frags {
+		s, err := copyN(a.tw, io.NewSectionReader(f, fr.offset, fr.length), fr.length)
+		if err != nil {
+			return false, err
+		}
+		short = short || s
+	}
+	return short, nil
+}
+
+// sparseHeaderName is the ustar name of a sparse entry, as GNU tar names
+// it. Readers take the real name from GNU.sparse.name.
+func sparseHeaderName(name string) string {
+	s := "GNUSparseFile.0/" + path.Base(name)
+	if len(s) > 100 || !isASCII(s) {
+		s = "GNUSparseFile.0/file"
+	}
+	return s
+}
+
+// fitUSTAR moves the fields of h that a ustar header can't hold into PAX
+// records, which it returns.
+func fitUSTAR(h *tar.Header) string {
+	const maxID = 1<<21 - 1 // 7 octal digits
+	var records string
+	if h.Uid < 0 || h.Uid > maxID {
+		records += paxRecord("uid", strconv.Itoa(h.Uid))
+		h.Uid = 0
+	}
+	if h.Gid < 0 || h.Gid > maxID {
+		records += paxRecord("gid", strconv.Itoa(h.Gid))
+		h.Gid = 0
+	}
+	if len(h.Uname) > 32 || !isASCII(h.Uname) {
+		records += paxRecord("uname", h.Uname)
+		h.Uname = ""
+	}
+	if len(h.Gname) > 32 || !isASCII(h.Gname) {
+		records += paxRecord("gname", h.Gname)
+		h.Gname = ""
+	}
+	if secs := h.ModTime.Unix(); secs < 0 || secs > 1<<33-1 {
+		records += paxRecord("mtime", strconv.FormatInt(secs, 10))
+		h.ModTime = time.Unix(0, 0)
+	}
+	h.ModTime = h.ModTime.Truncate(time.Second)
+	h.AccessTime = time.Time{}
+	h.ChangeTime = time.Time{}
+	return records
+}
+
+// paxRecord formats a PAX record, "<length> <key>=<value>\n", where length
+// counts the whole record including its own digits.
+func paxRecord(k, v string) string {
+	size := len(k) + len(v) + 3 // ' ', '=' and '\n'
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	if len(record) != size {
+		// Adding the length's digits carried it to one more digit.
+		size = len(record)
+		record = strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	}
+	return record
+}
+
+// writePAXHeader writes a PAX extended header holding records.
+func writePAXHeader(w io.Writer, name, records string) error {
+	var blk [blockSize]byte
+	copy(blk[0:100], name)
+	copy(blk[100:108], "0000644\x00")
+	copy(blk[108:116], "0000000\x00")
+	copy(blk[116:124], "0000000\x00")
+	copy(blk[124:136], fmt.Sprintf("%011o\x00", len(records)))
+	copy(blk[136:148], "00000000000\x00")
+	blk[156] = tar.TypeXHeader
+	copy(blk[257:265], "ustar\x0000")
+
+	// The checksum is computed with its own field set to spaces.
+	copy(blk[148:156], "        ")
+	var sum int64
+	for _, c := range blk {
+		sum += int64(c)
+	}
+	copy(blk[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	if _, err := w.Write(blk[:]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, records); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, padding(int64(len(records)))))
+	return err
+}
+
+// padding returns how many bytes pad n to a whole block.
+func padding(n int64) int64 {
+	return -n & (blockSize - 1)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// isSparse reports whether hdr is a sparse entry, whose holes the tar
+// reader returns as zeros.
+func isSparse(hdr *tar.Header) bool {
+	return hdr.Typeflag == tar.TypeGNUSparse || hdr.PAXRecords["GNU.sparse.major"] != "" ||
+		hdr.PAXRecords["GNU.sparse.map"] != ""
+}
+
+// copySparse copies size bytes from r to f, seeking over blocks of zeros
+// instead of writing them so that holes are recreated.
+func copySparse(f *os.File, r io.Reader, size int64) error {
+	buf := make([]byte, sparseBlock)
+	for off := int64(0); off < size; {
+		n, err := io.ReadFull(r, buf[:min(int64(len(buf)), size-off)])
+		if err != nil {
+			return err
+		}
+		if isZero(buf[:n]) {
+			_, err = f.Seek(int64(n), io.SeekCurrent)
+		} else {
+			_, err = f.Write(buf[:n])
+		}
+		if err != nil {
+			return err
+		}
+		off += int64(n)
+	}
+	// A trailing hole was only seeked over.
+	return f.Truncate(size)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package archive
+
+import "io/fs"
+
+type fileKey struct{}
+
+// hardlinkKey can't identify files on this platform, so every link is
+// archived with its content.
+func hardlinkKey(info fs.FileInfo) (fileKey, bool) { return fileKey{}, false }
+
+func allocatedSize(info fs.FileInfo) (int64, bool) { return 0, false }
@@ -3,6 +3,7 @@ package archive
 
 import (
 	"archive/tar"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -14,8 +15,43 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	"github.com/thebluefowl/burrow/internal/glob"
+)
+
+// ChangePolicy says what happens when a file changes while it is archived.
+type ChangePolicy string
+
+const (
+	// ChangeFail aborts the archive. It is the default.
+	ChangeFail ChangePolicy = "fail"
+	// ChangeWarn keeps the entry, which may mix old and new content, and
+	// reports it to Options.Warn.
+	ChangeWarn ChangePolicy = "warn"
+	// ChangeRetry archives the file again as a later entry of the same
+	// name, which replaces the earlier one on extraction, and fails after
+	// Options.ChangeRetries attempts.
+	ChangeRetry ChangePolicy = "retry"
 )
 
+// defaultChangeRetries is how often ChangeRetry archives a file again.
+const defaultChangeRetries = 3
+
+// ErrFileChanged is returned when a file's size or modification time changed
+// while it was read.
+var ErrFileChanged = errors.New("file changed while being archived")
+
+// ParseChangePolicy parses a policy name; empty means ChangeFail.
+func ParseChangePolicy(s string) (ChangePolicy, error) {
+	switch p := ChangePolicy(s); p {
+	case "":
+		return ChangeFail, nil
+	case ChangeFail, ChangeWarn, ChangeRetry:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid change policy %q (want %s, %s or %s)", s, ChangeFail, ChangeWarn, ChangeRetry)
+}
+
 // Options controls how StreamTar behaves.
 type Options struct {
 	// Include a header for the top-level directory when srcPath is a directory.
@@ -25,177 +61,425 @@ type Options struct {
 	// - zero uid/gid and owner names
 	// - sort entries lexicographically
 	Deterministic bool
-	// Exclude is a list of glob patterns matched against the *tar path*
-	// (forward-slash separated, rooted at the archive root).
-	// Examples: ".git/**", "node_modules/**", "*.tmp"
+	// Exclude is a list of glob patterns (see package glob) matched against
+	// the *tar path* (forward-slash separated, rooted at the archive root).
+	// Patterns without a "/" match at any depth.
+	// Examples: ".git/", "src/**/testdata/", "*.tmp", "!keep.tmp"
 	Exclude []string
+	// IgnoreCase matches Exclude patterns and ignore files case-insensitively.
+	IgnoreCase bool
+	// IgnoreFile names a file of gitignore-style rules (usually
+	// IgnoreFileName) that excludes paths in its directory and below.
+	IgnoreFile string
+	// ExcludeCaches skips the content of directories tagged with a
+	// CACHEDIR.TAG, keeping the directory and the tag itself.
+	ExcludeCaches bool
+	// ExcludeLargerThan skips regular files larger than this many bytes;
+	// zero means no limit.
+	ExcludeLargerThan int64
+	// SpecialFiles archives character and block devices and named pipes
+	// instead of skipping them. Sockets are always skipped.
+	SpecialFiles bool
 	// FollowSymlinks: if true, dereference regular-file symlinks.
 	// Directory symlinks are not followed (to avoid cycles); we emit a symlink header instead.
 	FollowSymlinks bool
+	// ChangePolicy decides what happens when a regular file's size or
+	// modification time changes while it is read; empty means ChangeFail.
+	ChangePolicy ChangePolicy
+	// ChangeRetries bounds the attempts of ChangeRetry; zero means 3.
+	ChangeRetries int
+	// Warn, if set, is called with the problems that don't abort the
+	// archive, such as files kept under ChangeWarn.
+	Warn func(err error)
+	// ReadFrom, if set, maps each source path to the path its content is
+	// read from, such as the same path inside a filesystem snapshot. The
+	// archive still names entries after the source.
+	ReadFrom func(srcPath string) string
+	// Select, if set, is called with the tar path and lstat info of each
+	// entry left after exclusions, and only the entries it returns true for
+	// are written. Directories are walked whether or not they are selected.
+	Select func(name string, info fs.FileInfo) bool
+	// OnFile, if set, is called before each regular file is written, with
+	// its tar path, size and up to HeadSize leading bytes of its content.
+	// An error aborts the archive.
+	OnFile   func(name string, size int64, head []byte) error
+	HeadSize int
 }
 
 // StreamTar writes a tar archive of srcPath into w according to opts.
 // For a file, it tars just that file. For a directory, it walks recursively.
 // The archive root is the basename of srcPath (normalized).
 func StreamTar(ctx context.Context, w io.Writer, srcPath string, opts Options) error {
+	return StreamTarPaths(ctx, w, []string{srcPath}, opts)
+}
+
+// StreamTarPaths writes one tar archive of several sources into w. Each
+// source is archived as by StreamTar, under its own basename; two sources
+// with the same basename are an error.
+//
+// Entries are written as the walk finds them, so memory is bounded by the
+// width of the widest directory rather than the size of the tree. With
+// Deterministic, entries are in lexicographic order of their tar paths.
+func StreamTarPaths(ctx context.Context, w io.Writer, srcPaths []string, opts Options) error {
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	srcPath = filepath.Clean(srcPath)
+	if len(srcPaths) == 0 {
+		return errors.New("no source paths")
+	}
 
-	rootName := filepath.Base(srcPath)
-	if rootName == "" || rootName == string(filepath.Separator) {
-		rootName = "archive"
+	globOpts := glob.Options{CaseInsensitive: opts.IgnoreCase}
+	exclude, err := glob.CompileList(opts.Exclude, globOpts)
+	if err != nil {
+		return fmt.Errorf("exclude: %w", err)
 	}
-	rootName = normalizeTarPath(rootName)
 
-	tw := tar.NewWriter(w)
-	defer tw.Close()
+	a := &archiver{ctx: ctx, w: w, opts: opts, exclude: exclude, links: make(map[fileKey]string)}
 
-	info, err := os.Lstat(srcPath)
-	if err != nil {
-		return fmt.Errorf("stat %q: %w", srcPath, err)
+	// Check every source before writing anything.
+	var items []item
+	roots := make(map[string]string)
+	for _, srcPath := range srcPaths {
+		srcPath = filepath.Clean(srcPath)
+		rootName := rootName(srcPath)
+		if prev, ok := roots[rootName]; ok {
+			return fmt.Errorf("%q and %q would both be archived as %q", prev, srcPath, rootName)
+		}
+		roots[rootName] = srcPath
+
+		readPath := srcPath
+		if opts.ReadFrom != nil {
+			readPath = opts.ReadFrom(srcPath)
+		}
+		rootItems, err := a.rootItems(readPath, rootName)
+		if err != nil {
+			return err
+		}
+		items = append(items, rootItems...)
 	}
 
-	// Collect entries in a slice so we can sort for determinism.
-	type entry struct {
-		full string      // filesystem path
-		name string      // path inside tar (normalized)
-		info fs.FileInfo // lstat info
+	a.tw = tar.NewWriter(w)
+	defer a.tw.Close()
+	if opts.Deterministic {
+		sortItems(items)
+	}
+	return a.write(items)
+}
+
+// rootName returns the tar path srcPath is archived under.
+func rootName(srcPath string) string {
+	name := filepath.Base(srcPath)
+	if name == "" || name == string(filepath.Separator) {
+		name = "archive"
 	}
-	var entries []entry
+	return normalizeTarPath(name)
+}
+
+// archiver walks sources and writes their entries as it goes.
+type archiver struct {
+	ctx     context.Context
+	w       io.Writer // under tw, for entries tar.Writer can't encode
+	tw      *tar.Writer
+	opts    Options
+	exclude glob.List
+	links   map[fileKey]string // tar path of the first link of each file
+}
+
+// item is either one entry of the archive or the content of a directory,
+// which is walked when the item is written. Sorting a directory's items by
+// key puts the content of child "d" at "d/", so a walk of sorted items
+// yields the same order as sorting all tar paths.
+type item struct {
+	key     string
+	full    string      // filesystem path
+	name    string      // path inside tar (normalized)
+	info    fs.FileInfo // lstat info
+	subtree bool
+	ignores *ignoreTree
+}
+
+func sortItems(items []item) {
+	sort.Slice(items, func(i, j int) bool { return items[i].key < items[j].key })
+}
 
-	emit := func(full, name string, fi fs.FileInfo) {
-		entries = append(entries, entry{full: full, name: normalizeTarPath(name), info: fi})
+// rootItems returns the items of one source, archived under rootName.
+func (a *archiver) rootItems(srcPath, rootName string) ([]item, error) {
+	info, err := os.Lstat(srcPath)
+	if err != nil {
+		return nil, fmt.Errorf("stat %q: %w", srcPath, err)
 	}
 
-	// Build entries
 	switch {
 	case info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0:
-		if shouldExclude(rootName, opts.Exclude) {
-			return nil
+		if a.exclude.Match(rootName, false) || a.tooLarge(info) {
+			return nil, nil
 		}
-		emit(srcPath, rootName, info)
+		return []item{{key: rootName, full: srcPath, name: rootName, info: info}}, nil
 
 	case info.IsDir():
-		if opts.IncludeRoot && !shouldExclude(rootName, opts.Exclude) {
-			emit(srcPath, rootName, info)
+		var items []item
+		if a.opts.IncludeRoot && !a.exclude.Match(rootName, true) {
+			items = append(items, item{key: rootName, full: srcPath, name: rootName, info: info})
 		}
-		err = filepath.WalkDir(srcPath, func(p string, d fs.DirEntry, walkErr error) error {
-			if walkErr != nil {
-				return walkErr
-			}
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
+		ignores := newIgnoreTree(a.opts.IgnoreFile, glob.Options{CaseInsensitive: a.opts.IgnoreCase})
+		return append(items, item{key: rootName + "/", full: srcPath, name: rootName, subtree: true, ignores: ignores}), nil
 
-			// Skip the root itself; we added it above if IncludeRoot=true
-			if filepath.Clean(p) == srcPath {
-				return nil
-			}
+	default:
+		return nil, fmt.Errorf("unsupported file type: %s", srcPath)
+	}
+}
 
-			fi, err := d.Info()
-			if err != nil {
-				return err
-			}
-			rel, err := filepath.Rel(srcPath, p)
-			if err != nil {
-				return err
-			}
-			// name inside tar is rootName/rel
-			nameInTar := filepath.Join(rootName, rel)
-			nameInTar = normalizeTarPath(nameInTar)
-
-			if shouldExclude(nameInTar, opts.Exclude) {
-				if fi.IsDir() {
-					// Skip walking this directory
-					return fs.SkipDir
-				}
-				return nil
-			}
-			emit(p, nameInTar, fi)
-			return nil
-		})
+// write writes items in order, walking subtrees as they come up.
+func (a *archiver) write(items []item) error {
+	for _, it := range items {
+		if err := a.ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		if it.subtree {
+			err = a.walk(it.full, it.name, it.ignores)
+		} else {
+			err = a.writeEntry(it.full, it.name, it.info)
+		}
 		if err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-	default:
-		return fmt.Errorf("unsupported file type: %s", srcPath)
+// readDirBatch is how many directory entries are read at a time when the
+// order does not matter.
+const readDirBatch = 1024
+
+// walk writes the content of the directory dir, archived as name. For a
+// tagged cache directory it writes only the tag.
+func (a *archiver) walk(dir, name string, ignores *ignoreTree) error {
+	if a.opts.ExcludeCaches && isCacheDir(dir) {
+		tag := filepath.Join(dir, CacheDirTag)
+		if fi, err := os.Lstat(tag); err == nil {
+			return a.writeEntry(tag, name+"/"+CacheDirTag, fi)
+		}
+		return nil
+	}
+	if err := ignores.load(dir, name); err != nil {
+		return err
 	}
+	defer ignores.forget(name)
 
-	// Deterministic: sort by name (tar path)
-	if opts.Deterministic {
-		sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	// Write headers + contents
-	for _, e := range entries {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+	// A deterministic archive needs the whole directory to sort it; otherwise
+	// entries are written in directory order, a batch at a time.
+	batch := readDirBatch
+	if a.opts.Deterministic {
+		batch = -1
+	}
+	for {
+		entries, err := f.ReadDir(batch)
+		items, ierr := a.childItems(dir, name, entries, ignores)
+		if ierr != nil {
+			return ierr
+		}
+		if a.opts.Deterministic {
+			sortItems(items)
 		}
-		if err := writeEntry(tw, e.full, e.name, e.info, opts); err != nil {
+		if werr := a.write(items); werr != nil {
+			return werr
+		}
+		if err == io.EOF || (err == nil && batch < 0) {
+			return nil
+		}
+		if err != nil {
 			return err
 		}
 	}
+}
 
-	return nil
+// childItems returns the items for the entries of the directory dir,
+// leaving out excluded ones.
+func (a *archiver) childItems(dir, name string, entries []fs.DirEntry, ignores *ignoreTree) ([]item, error) {
+	items := make([]item, 0, len(entries))
+	for _, d := range entries {
+		fi, err := d.Info()
+		if err != nil {
+			return nil, err
+		}
+		// name inside tar is rootName/rel
+		childName := normalizeTarPath(name + "/" + d.Name())
+		if a.exclude.Match(childName, fi.IsDir()) || ignores.ignored(childName, fi.IsDir()) || a.tooLarge(fi) {
+			continue
+		}
+		full := filepath.Join(dir, d.Name())
+		items = append(items, item{key: d.Name(), full: full, name: childName, info: fi})
+		if fi.IsDir() {
+			items = append(items, item{key: d.Name() + "/", full: full, name: childName, subtree: true, ignores: ignores})
+		}
+	}
+	return items, nil
+}
+
+// tooLarge reports whether fi is a regular file above ExcludeLargerThan.
+func (a *archiver) tooLarge(fi fs.FileInfo) bool {
+	return a.opts.ExcludeLargerThan > 0 && fi.Mode().IsRegular() && fi.Size() > a.opts.ExcludeLargerThan
 }
 
 // ---- helpers ----
 
-func writeEntry(tw *tar.Writer, fullPath, nameInTar string, info fs.FileInfo, opts Options) error {
+func (a *archiver) writeEntry(fullPath, nameInTar string, info fs.FileInfo) error {
+	if a.opts.Select != nil && !a.opts.Select(nameInTar, info) {
+		return nil
+	}
 	mode := info.Mode()
 
+	// Later links to an already archived file only refer to it.
+	key, linked := hardlinkKey(info)
+	if linked {
+		if first, ok := a.links[key]; ok {
+			return addHardlink(a.tw, nameInTar, first, info, a.opts)
+		}
+	}
+
+	var err error
 	switch {
 	case mode.IsRegular():
 		// If following symlinks and this file is actually a symlink to a regular file, dereference.
-		if opts.FollowSymlinks && (mode&os.ModeSymlink) != 0 {
+		if a.opts.FollowSymlinks && (mode&os.ModeSymlink) != 0 {
 			target, err := os.Readlink(fullPath)
 			if err == nil {
 				// Try stat on the target
 				if st, err2 := os.Stat(resolveSymlink(fullPath, target)); err2 == nil && st.Mode().IsRegular() {
-					return addFile(tw, resolveSymlink(fullPath, target), nameInTar, st, opts)
+					return a.addFile(resolveSymlink(fullPath, target), nameInTar, st)
 				}
 			}
 			// fall through to symlink header if not a regular file target
 		}
-		return addFile(tw, fullPath, nameInTar, info, opts)
+		err = a.addFile(fullPath, nameInTar, info)
 
 	case mode.IsDir():
-		return addDirHeader(tw, nameInTar, info, opts)
+		return addDirHeader(a.tw, nameInTar, info, a.opts)
 
 	case mode&os.ModeSymlink != 0:
-		return addSymlink(tw, fullPath, nameInTar, info, opts)
+		err = addSymlink(a.tw, fullPath, nameInTar, info, a.opts)
+
+	case mode&(os.ModeDevice|os.ModeNamedPipe) != 0 && a.opts.SpecialFiles:
+		err = addSpecial(a.tw, nameInTar, info, a.opts)
 
 	default:
-		// Skip devices, sockets, FIFOs, etc.
+		// Skip sockets, and devices and FIFOs unless asked for.
 		return nil
 	}
+	if err == nil && linked {
+		a.links[key] = strings.TrimLeft(normalizeTarPath(nameInTar), "/")
+	}
+	return err
 }
 
-func addFile(tw *tar.Writer, fullPath, nameInTar string, info fs.FileInfo, opts Options) error {
-	hdr, err := tar.FileInfoHeader(info, "")
-	if err != nil {
+// addFile writes a regular file, checking that it didn't change while it
+// was read and applying ChangePolicy if it did.
+func (a *archiver) addFile(fullPath, nameInTar string, info fs.FileInfo) error {
+	retries := a.opts.ChangeRetries
+	if retries <= 0 {
+		retries = defaultChangeRetries
+	}
+	for attempt := 1; ; attempt++ {
+		changed, err := a.copyFile(fullPath, nameInTar, info)
+		if err != nil || !changed {
+			return err
+		}
+		err = fmt.Errorf("%s: %w", fullPath, ErrFileChanged)
+		switch a.opts.ChangePolicy {
+		case ChangeWarn:
+			if a.opts.Warn != nil {
+				a.opts.Warn(err)
+			}
+			return nil
+		case ChangeRetry:
+			if attempt <= retries {
+				continue
+			}
+			return fmt.Errorf("%w (after %d attempts)", err, attempt)
+		}
 		return err
 	}
-	applyHeaderFixups(hdr, nameInTar, info, opts)
+}
 
+// copyFile writes one entry for a regular file and reports whether the file
+// changed while it was read. The entry always holds as many bytes as its
+// header announces, so the archive stays valid either way.
+func (a *archiver) copyFile(fullPath, nameInTar string, info fs.FileInfo) (changed bool, err error) {
 	f, err := os.Open(fullPath)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer f.Close()
 
-	if err := tw.WriteHeader(hdr); err != nil {
-		return err
+	// Describe the file as it is now rather than when the walk saw it.
+	if st, err := f.Stat(); err == nil && st.Mode().IsRegular() {
+		info = st
 	}
-	_, err = io.Copy(tw, f)
-	return err
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return false, err
+	}
+	applyHeaderFixups(hdr, nameInTar, info, a.opts)
+
+	frags, err := sparseMap(f, info)
+	if err != nil {
+		return false, fmt.Errorf("map holes of %s: %w", fullPath, err)
+	}
+
+	var content io.Reader = f
+	if a.opts.OnFile != nil {
+		head := make([]byte, min(int64(a.opts.HeadSize), info.Size()))
+		n, err := io.ReadFull(io.NewSectionReader(f, 0, info.Size()), head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return false, err
+		}
+		head = head[:n]
+		if err := a.opts.OnFile(hdr.Name, info.Size(), head); err != nil {
+			return false, err
+		}
+		content = io.MultiReader(bytes.NewReader(head), io.NewSectionReader(f, int64(n), info.Size()-int64(n)))
+	}
+
+	var short bool
+	if frags != nil {
+		short, err = a.addSparse(hdr, f, frags)
+	} else if err = a.tw.WriteHeader(hdr); err == nil {
+		short, err = copyN(a.tw, content, hdr.Size)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	return short || st.Size() != info.Size() || !st.ModTime().Equal(info.ModTime()), nil
+}
+
+// copyN copies n bytes from r to w. If r ends early, the rest is filled with
+// zeros and short is true.
+func copyN(w io.Writer, r io.Reader, n int64) (short bool, err error) {
+	written, err := io.CopyN(w, r, n)
+	if err != io.EOF {
+		return false, err
+	}
+	_, err = io.CopyN(w, zeroReader{}, n-written)
+	return true, err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
 }
 
 func addDirHeader(tw *tar.Writer, nameInTar string, info fs.FileInfo, opts Options) error {
@@ -227,6 +511,30 @@ func addSymlink(tw *tar.Writer, fullPath, nameInTar string, info fs.FileInfo, op
 	return tw.WriteHeader(hdr)
 }
 
+// addHardlink writes a link to target, an entry already in the archive.
+func addHardlink(tw *tar.Writer, nameInTar, target string, info fs.FileInfo, opts Options) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	applyHeaderFixups(hdr, nameInTar, info, opts)
+	hdr.Typeflag = tar.TypeLink
+	hdr.Linkname = target
+	hdr.Size = 0
+	return tw.WriteHeader(hdr)
+}
+
+// addSpecial writes a character or block device or a named pipe.
+// tar.FileInfoHeader fills in the device numbers.
+func addSpecial(tw *tar.Writer, nameInTar string, info fs.FileInfo, opts Options) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	applyHeaderFixups(hdr, nameInTar, info, opts)
+	return tw.WriteHeader(hdr)
+}
+
 func applyHeaderFixups(hdr *tar.Header, nameInTar string, info fs.FileInfo, opts Options) {
 	// Normalize path (no leading slashes)
 	hdr.Name = strings.TrimLeft(normalizeTarPath(nameInTar), "/")
@@ -281,61 +589,6 @@ func resolveSymlink(base, target string) string {
 	return filepath.Clean(filepath.Join(filepath.Dir(base), target))
 }
 
-func shouldExclude(nameInTar string, patterns []string) bool {
-	if len(patterns) == 0 {
-		return false
-	}
-	// nameInTar is normalized with forward slashes.
-	for _, pat := range patterns {
-		pat = strings.TrimSpace(pat)
-		if pat == "" {
-			continue
-		}
-		p := pat
-		// Support "**" by a simple expansion: check prefix/contains forms
-		// and also exact/glob via path.Match semantics.
-		if matchGlob(p, nameInTar) {
-			return true
-		}
-		// Also try matching against the base name for patterns like "*.tmp"
-		if matchGlob(p, filepath.Base(nameInTar)) {
-			return true
-		}
-	}
-	return false
-}
-
-// matchGlob provides minimal glob matching with '*' and '?' and supports
-// '**' to span directories by translating to a simple contains check.
-func matchGlob(pattern, s string) bool {
-	pattern = strings.ReplaceAll(pattern, "\\", "/")
-	s = strings.ReplaceAll(s, "\\", "/")
-
-	// Fast path for "**" at ends.
-	if strings.HasPrefix(pattern, "**/") {
-		pattern = strings.TrimPrefix(pattern, "**/")
-		if hasSuffixGlob(pattern) {
-			// fallback to filepath.Match later
-		} else if strings.HasSuffix(s, pattern) {
-			return true
-		}
-	}
-	if strings.HasSuffix(pattern, "/**") {
-		prefix := strings.TrimSuffix(pattern, "/**")
-		if strings.HasPrefix(s, prefix) {
-			return true
-		}
-	}
-
-	// Use filepath.Match for standard globbing (*, ?)
-	ok, _ := filepath.Match(pattern, s)
-	return ok
-}
-
-func hasSuffixGlob(p string) bool {
-	return strings.ContainsAny(p, "*?")
-}
-
 // Convenience: small wrapper for simple use without exclusions.
 func StreamTarSimple(w io.Writer, srcPath string) error {
 	return StreamTar(context.Background(), w, srcPath, Options{})
@@ -343,17 +596,32 @@ func StreamTarSimple(w io.Writer, srcPath string) error {
 
 // ValidateOptions can be called by callers if desired.
 func ValidateOptions(opts Options) error {
-	for _, p := range opts.Exclude {
-		if strings.TrimSpace(p) == "" {
-			return errors.New("exclude patterns must not be empty")
-		}
-	}
-	return nil
+	_, err := glob.CompileList(opts.Exclude, glob.Options{CaseInsensitive: opts.IgnoreCase})
+	return err
 }
 
 func ExtractTar(r io.Reader, destDir string) error {
+	return ExtractTarFiltered(r, destDir, nil)
+}
+
+// ExtractTarFiltered extracts the entries of the tar stream r whose header
+// match accepts (all entries if match is nil). If r is an io.Seeker, the
+// content of skipped entries is seeked over instead of read.
+//
+// A hard link whose target was skipped gets the target's content instead,
+// and later links to the same target link to it. That content is read by
+// seeking back in r, so it fails for streams that are not io.Seekers.
+//
+// Entries replace what exists at their path in destDir, except that
+// directories are merged.
+func ExtractTarFiltered(r io.Reader, destDir string, match func(hdr *tar.Header) bool) error {
 	tr := tar.NewReader(r)
 
+	// skipped holds the regular files not extracted; relocated maps those
+	// that were extracted at a link instead to its path.
+	skipped := make(map[string]bool)
+	relocated := make(map[string]string)
+
 	for {
 		hdr, err := tr.Next()
 		if err == io.EOF {
@@ -363,6 +631,13 @@ func ExtractTar(r io.Reader, destDir string) error {
 			return fmt.Errorf("read tar: %w", err)
 		}
 
+		if match != nil && !match(hdr) {
+			if isRegular(hdr) {
+				skipped[filepath.Clean(hdr.Name)] = true
+			}
+			continue
+		}
+
 		// Clean up paths
 		name := filepath.Clean(hdr.Name)
 		if strings.HasPrefix(name, "..") {
@@ -372,41 +647,70 @@ func ExtractTar(r io.Reader, destDir string) error {
 
 		switch hdr.Typeflag {
 		case tar.TypeDir:
+			if err := clearPath(target, true); err != nil {
+				return err
+			}
 			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)); err != nil {
 				return fmt.Errorf("mkdir %s: %w", target, err)
 			}
 
-		case tar.TypeReg, tar.TypeRegA:
-			// Ensure parent dir exists
-			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+			delete(skipped, name)
+			if err := extractFile(tr, hdr, target); err != nil {
 				return err
 			}
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
-			if err != nil {
-				return fmt.Errorf("create file %s: %w", target, err)
-			}
-			if _, err := io.CopyN(f, tr, hdr.Size); err != nil && err != io.EOF {
-				f.Close()
-				return fmt.Errorf("write file %s: %w", target, err)
-			}
-			f.Close()
 
 		case tar.TypeSymlink:
 			// Ensure parent dir exists
 			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
 				return err
 			}
+			if err := clearPath(target, false); err != nil {
+				return err
+			}
 			if err := os.Symlink(hdr.Linkname, target); err != nil {
 				return fmt.Errorf("symlink %s -> %s: %w", target, hdr.Linkname, err)
 			}
 
 		case tar.TypeLink:
-			// Hard link — rarely used, but handle it.
-			linkTarget := filepath.Join(destDir, hdr.Linkname)
+			// The target is an earlier entry: extracted, or skipped.
+			linkName := filepath.Clean(hdr.Linkname)
+			if strings.HasPrefix(linkName, "..") || filepath.IsAbs(linkName) {
+				return fmt.Errorf("illegal link target: %s", hdr.Linkname)
+			}
+			linkTarget := filepath.Join(destDir, linkName)
+			if skipped[linkName] {
+				path, ok := relocated[linkName]
+				if !ok {
+					if err := extractLinkTarget(r, linkName, target); err != nil {
+						return fmt.Errorf("hardlink %s: %w", target, err)
+					}
+					relocated[linkName] = target
+					continue
+				}
+				linkTarget = path
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := clearPath(target, false); err != nil {
+				return err
+			}
 			if err := os.Link(linkTarget, target); err != nil {
 				return fmt.Errorf("hardlink %s -> %s: %w", target, linkTarget, err)
 			}
 
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := clearPath(target, false); err != nil {
+				return err
+			}
+			if err := makeSpecial(target, hdr); err != nil {
+				return fmt.Errorf("create %s: %w", target, err)
+			}
+
 		default:
 			// Skip other types
 			continue
@@ -414,3 +718,108 @@ func ExtractTar(r io.Reader, destDir string) error {
 	}
 	return nil
 }
+
+// RemoveEntries removes the tar paths names, and everything below them,
+// from destDir. Paths that don't exist are ignored.
+func RemoveEntries(destDir string, names []string) error {
+	for _, name := range names {
+		name = filepath.Clean(name)
+		if strings.HasPrefix(name, "..") || filepath.IsAbs(name) || name == "." {
+			return fmt.Errorf("illegal path: %s", name)
+		}
+		if err := os.RemoveAll(filepath.Join(destDir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearPath removes what is at target so that an entry can be written
+// there, unless it is a directory and dir is true. Removing rather than
+// overwriting also keeps writes from going through a symlink.
+func clearPath(target string, dir bool) error {
+	fi, err := os.Lstat(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		if dir {
+			return nil
+		}
+		return os.RemoveAll(target)
+	}
+	return os.Remove(target)
+}
+
+func isRegular(hdr *tar.Header) bool {
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+		return true
+	}
+	return false
+}
+
+// extractFile writes the content of the regular entry hdr, read from r, to
+// target.
+func extractFile(r io.Reader, hdr *tar.Header, target string) error {
+	// Ensure parent dir exists
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if err := clearPath(target, false); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
+	if err != nil {
+		return fmt.Errorf("create file %s: %w", target, err)
+	}
+	if isSparse(hdr) {
+		err = copySparse(f, r, hdr.Size)
+	} else if _, err = io.CopyN(f, r, hdr.Size); err == io.EOF {
+		err = nil
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("write file %s: %w", target, err)
+	}
+	return f.Close()
+}
+
+// extractLinkTarget writes the content of the regular entry name of the tar
+// stream r to target. It rereads r from the start and then seeks back to
+// where r was.
+func extractLinkTarget(r io.Reader, name, target string) (err error) {
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return fmt.Errorf("its target %s was not extracted", name)
+	}
+	pos, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if _, serr := s.Seek(pos, io.SeekStart); err == nil {
+			err = serr
+		}
+	}()
+	if _, err := s.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("its target %s is missing", name)
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %w", err)
+		}
+		if isRegular(hdr) && filepath.Clean(hdr.Name) == name {
+			return extractFile(tr, hdr, target)
+		}
+	}
+}
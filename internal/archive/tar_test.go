@@ -0,0 +1,341 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func tarNames(t *testing.T, srcs []string, opts Options) []string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := StreamTarPaths(context.Background(), &buf, srcs, opts); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestStreamTarOrder(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	writeTree(t, src, map[string]string{
+		"a/x":   "",
+		"a/y/z": "",
+		"a-b/q": "",
+		"a.txt": "",
+		"a b/c": "",
+		"ab":    "",
+		"A":     "",
+	})
+
+	// Deterministic archives are sorted by tar path as a whole, so "a-b/q"
+	// and "a.txt" come between "a/" and "a/x".
+	got := tarNames(t, []string{src}, Options{IncludeRoot: true, Deterministic: true})
+	want := []string{
+		"src/", "src/A", "src/a/", "src/a b/", "src/a b/c", "src/a-b/", "src/a-b/q",
+		"src/a.txt", "src/a/x", "src/a/y/", "src/a/y/z", "src/ab",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("archived %v\nwant %v", got, want)
+	}
+
+	// Without Deterministic the order is the directory's, but the content
+	// is the same.
+	unsorted := tarNames(t, []string{src}, Options{IncludeRoot: true})
+	sort.Slice(unsorted, func(i, j int) bool {
+		return strings.TrimSuffix(unsorted[i], "/") < strings.TrimSuffix(unsorted[j], "/")
+	})
+	if strings.Join(unsorted, ",") != strings.Join(want, ",") {
+		t.Errorf("non-deterministic archive holds %v", unsorted)
+	}
+}
+
+func TestStreamTarSelect(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	writeTree(t, src, map[string]string{
+		"keep.txt":     "",
+		"drop.txt":     "",
+		"sub/keep.txt": "",
+		"sub/x.tmp":    "",
+	})
+
+	var seen []string
+	got := tarNames(t, []string{src}, Options{
+		IncludeRoot:   true,
+		Deterministic: true,
+		Exclude:       []string{"*.tmp"},
+		Select: func(name string, info os.FileInfo) bool {
+			seen = append(seen, name)
+			return strings.HasSuffix(name, "keep.txt")
+		},
+	})
+	// Unselected directories are still walked, and excluded paths are never
+	// offered.
+	if want := "src/keep.txt,src/sub/keep.txt"; strings.Join(got, ",") != want {
+		t.Errorf("archived %v, want %s", got, want)
+	}
+	if want := "src,src/drop.txt,src/keep.txt,src/sub,src/sub/keep.txt"; strings.Join(seen, ",") != want {
+		t.Errorf("Select saw %v, want %s", seen, want)
+	}
+}
+
+func TestStreamTarHardlinks(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"a": "shared", "b/c": "other"})
+	if err := os.Link(filepath.Join(src, "a"), filepath.Join(src, "b", "d")); err != nil {
+		t.Skipf("hardlinks unsupported: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := StreamTar(context.Background(), &buf, src, Options{IncludeRoot: true, Deterministic: true}); err != nil {
+		t.Fatal(err)
+	}
+	hdrs := tarHeaders(t, buf.Bytes())
+	if hdr := hdrs["src/b/d"]; hdr == nil || hdr.Typeflag != tar.TypeLink || hdr.Linkname != "src/a" {
+		t.Fatalf("second link = %+v, want a hardlink to src/a", hdr)
+	}
+	if hdr := hdrs["src/a"]; hdr.Typeflag != tar.TypeReg || hdr.Size != 6 {
+		t.Fatalf("first link = %+v, want the content", hdr)
+	}
+
+	dest := filepath.Join(dir, "out")
+	if err := ExtractTar(bytes.NewReader(buf.Bytes()), dest); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := os.Stat(filepath.Join(dest, "src", "a"))
+	d, err := os.Stat(filepath.Join(dest, "src", "b", "d"))
+	if err != nil || !os.SameFile(a, d) {
+		t.Errorf("extracted links are not the same file (%v)", err)
+	}
+}
+
+func TestExtractTarFilteredLinkTarget(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	writeTree(t, src, map[string]string{"a": "shared", "b/c": "other"})
+	for _, name := range []string{"d", "e"} {
+		if err := os.Link(filepath.Join(src, "a"), filepath.Join(src, "b", name)); err != nil {
+			t.Skipf("hardlinks unsupported: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := StreamTar(context.Background(), &buf, src, Options{IncludeRoot: true, Deterministic: true}); err != nil {
+		t.Fatal(err)
+	}
+	// Only the links in b/ are selected, not src/a, which holds the content.
+	inB := func(hdr *tar.Header) bool { return strings.HasPrefix(hdr.Name, "src/b/") }
+
+	dest := filepath.Join(dir, "out")
+	if err := ExtractTarFiltered(bytes.NewReader(buf.Bytes()), dest, inB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "src", "a")); !os.IsNotExist(err) {
+		t.Error("the unselected link target was extracted")
+	}
+	if got, err := os.ReadFile(filepath.Join(dest, "src", "b", "d")); err != nil || string(got) != "shared" {
+		t.Fatalf("first selected link = %q, %v; want the target's content", got, err)
+	}
+	d, _ := os.Stat(filepath.Join(dest, "src", "b", "d"))
+	e, err := os.Stat(filepath.Join(dest, "src", "b", "e"))
+	if err != nil || !os.SameFile(d, e) {
+		t.Errorf("later link is not linked to the first selected one (%v)", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dest, "src", "b", "c")); string(got) != "other" {
+		t.Errorf("other selected entry = %q", got)
+	}
+
+	// Without seeking, the content can't be recovered.
+	err = ExtractTarFiltered(io.MultiReader(bytes.NewReader(buf.Bytes())), filepath.Join(dir, "out2"), inB)
+	if err == nil {
+		t.Error("extracting a link without its target from a stream succeeded")
+	}
+}
+
+func TestStreamTarSparse(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "disk.img")
+	const size = 8 << 20
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("boot"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("middle"), 4<<20); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	frags, err := sparseMap(f, mustStat(t, src))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frags == nil {
+		t.Skip("filesystem does not report holes")
+	}
+
+	var buf bytes.Buffer
+	var hinted int64
+	opts := Options{Deterministic: true, HeadSize: 4, OnFile: func(name string, size int64, head []byte) error {
+		if string(head) != "boot" {
+			t.Errorf("OnFile head = %q", head)
+		}
+		hinted = size
+		return nil
+	}}
+	if err := StreamTar(context.Background(), &buf, src, opts); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 1<<20 {
+		t.Errorf("sparse archive is %d bytes", buf.Len())
+	}
+	if hinted != size {
+		t.Errorf("OnFile size = %d, want %d", hinted, size)
+	}
+	hdr := tarHeaders(t, buf.Bytes())["disk.img"]
+	if hdr == nil || hdr.Size != size || !isSparse(hdr) {
+		t.Fatalf("header = %+v, want a sparse disk.img of %d bytes", hdr, size)
+	}
+
+	dest := filepath.Join(dir, "out")
+	if err := ExtractTar(bytes.NewReader(buf.Bytes()), dest); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := os.ReadFile(src)
+	got, err := os.ReadFile(filepath.Join(dest, "disk.img"))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("extracted content differs (%v)", err)
+	}
+	if alloc, ok := allocatedSize(mustStat(t, filepath.Join(dest, "disk.img"))); ok && alloc >= size {
+		t.Errorf("extracted file allocates %d bytes; holes were not recreated", alloc)
+	}
+}
+
+func tarHeaders(t *testing.T, b []byte) map[string]*tar.Header {
+	t.Helper()
+	hdrs := make(map[string]*tar.Header)
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return hdrs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdrs[hdr.Name] = hdr
+	}
+}
+
+func mustStat(t *testing.T, name string) os.FileInfo {
+	t.Helper()
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi
+}
+
+func TestStreamTarChangedFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "log")
+	archive := func(policy ChangePolicy, changes int) ([]string, []error, error) {
+		if err := os.WriteFile(src, []byte("first"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var warnings []error
+		opts := Options{
+			ChangePolicy:  policy,
+			ChangeRetries: 2,
+			Warn:          func(err error) { warnings = append(warnings, err) },
+			// Append to the file after its header is prepared.
+			OnFile: func(name string, size int64, head []byte) error {
+				if changes > 0 {
+					changes--
+					f, err := os.OpenFile(src, os.O_APPEND|os.O_WRONLY, 0)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					_, err = f.WriteString(" more")
+					return err
+				}
+				return nil
+			},
+		}
+		var buf bytes.Buffer
+		if err := StreamTar(context.Background(), &buf, src, opts); err != nil {
+			return nil, warnings, err
+		}
+		var contents []string
+		tr := tar.NewReader(&buf)
+		for {
+			_, err := tr.Next()
+			if err == io.EOF {
+				return contents, warnings, nil
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(tr)
+			contents = append(contents, string(b))
+		}
+	}
+
+	if _, _, err := archive("", 1); !errors.Is(err, ErrFileChanged) {
+		t.Errorf("default policy: err = %v, want ErrFileChanged", err)
+	}
+
+	contents, warnings, err := archive(ChangeWarn, 1)
+	if err != nil || len(warnings) != 1 || !errors.Is(warnings[0], ErrFileChanged) {
+		t.Errorf("warn: err = %v, warnings = %v", err, warnings)
+	}
+	if len(contents) != 1 || contents[0] != "first" {
+		t.Errorf("warn: archived %q, want the size the header announced", contents)
+	}
+
+	contents, _, err = archive(ChangeRetry, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"first", "first more"}; strings.Join(contents, ",") != strings.Join(want, ",") {
+		t.Errorf("retry: archived %q, want %q", contents, want)
+	}
+
+	if _, _, err := archive(ChangeRetry, 5); !errors.Is(err, ErrFileChanged) {
+		t.Errorf("retry of a file that keeps changing: err = %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+// Package bench measures the local throughput of the upload pipeline stages
+// and suggests settings for this machine.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/thebluefowl/burrow/internal/archive"
+	"github.com/thebluefowl/burrow/internal/compress"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/settings"
+)
+
+// Options control what is measured.
+type Options struct {
+	// Size is how many bytes each measurement processes.
+	Size int64
+	// Path, if set, is archived and its tar stream used as sample data;
+	// otherwise the sample is generated, half text and half random.
+	Path string
+	// Target is the throughput (bytes/s) the pipeline should sustain,
+	// usually the upload bandwidth. Compression levels slower than this
+	// are not suggested.
+	Target float64
+
+	Levels     []int
+	ChunkSizes []int
+}
+
+// DefaultOptions measures 32 MiB against a 50 MiB/s upload.
+func DefaultOptions() Options {
+	return Options{
+		Size:       32 << 20,
+		Target:     50 << 20,
+		Levels:     []int{1, 3, 6, 9, 12, 15},
+		ChunkSizes: []int{256 << 10, 1 << 20, 4 << 20, 16 << 20},
+	}
+}
+
+// Measurement is one timed run.
+type Measurement struct {
+	Name     string
+	In       int64
+	Out      int64
+	Duration time.Duration
+}
+
+// Throughput returns input bytes per second.
+func (m Measurement) Throughput() float64 {
+	if m.Duration <= 0 {
+		return 0
+	}
+	return float64(m.In) / m.Duration.Seconds()
+}
+
+// Ratio returns output size over input size.
+func (m Measurement) Ratio() float64 {
+	if m.In == 0 {
+		return 0
+	}
+	return float64(m.Out) / float64(m.In)
+}
+
+// Result holds all measurements and the suggested settings.
+type Result struct {
+	Archive  *Measurement // nil without Options.Path
+	Levels   []Measurement
+	Codecs   []Measurement
+	Encrypt  []Measurement
+	Suggests settings.Settings
+}
+
+// Bench runs the measurements.
+type Bench struct {
+	opts Options
+}
+
+// NewBench creates a Bench; zero fields of opts take their defaults.
+func NewBench(opts Options) *Bench {
+	def := DefaultOptions()
+	if opts.Size <= 0 {
+		opts.Size = def.Size
+	}
+	if opts.Target <= 0 {
+		opts.Target = def.Target
+	}
+	if len(opts.Levels) == 0 {
+		opts.Levels = def.Levels
+	}
+	if len(opts.ChunkSizes) == 0 {
+		opts.ChunkSizes = def.ChunkSizes
+	}
+	return &Bench{opts: opts}
+}
+
+// Execute runs every measurement.
+func (b *Bench) Execute(ctx context.Context) (*Result, error) {
+	res := &Result{}
+
+	sample, err := b.sample(ctx, res)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, level := range b.opts.Levels {
+		m, err := measureCompress(fmt.Sprintf("auto, level %d", level), sample,
+			compress.CompressorConfig{Mode: compress.CompressAuto, ZstdLevel: level})
+		if err != nil {
+			return nil, err
+		}
+		res.Levels = append(res.Levels, m)
+	}
+
+	for _, codec := range compress.Codecs() {
+		if codec == compress.CompressNone {
+			continue
+		}
+		m, err := measureCompress(string(codec), sample, compress.CompressorConfig{Mode: codec})
+		if err != nil {
+			return nil, err
+		}
+		res.Codecs = append(res.Codecs, m)
+	}
+
+	for _, chunk := range b.opts.ChunkSizes {
+		m, err := measureEncrypt(sample, chunk)
+		if err != nil {
+			return nil, err
+		}
+		res.Encrypt = append(res.Encrypt, m)
+	}
+
+	res.Suggests = b.suggest(res)
+	return res, nil
+}
+
+// sample returns Size bytes of test data, archiving Path if given.
+func (b *Bench) sample(ctx context.Context, res *Result) ([]byte, error) {
+	if b.opts.Path == "" {
+		return generate(b.opts.Size), nil
+	}
+
+	buf := &limitBuffer{limit: b.opts.Size}
+	start := time.Now()
+	err := archive.StreamTar(ctx, buf, b.opts.Path, archive.Options{IncludeRoot: true, Deterministic: true})
+	if err != nil && !errors.Is(err, errLimit) {
+		return nil, fmt.Errorf("archive %s: %w", b.opts.Path, err)
+	}
+	res.Archive = &Measurement{Name: "archive", In: int64(buf.Len()), Out: int64(buf.Len()), Duration: time.Since(start)}
+	if buf.Len() == 0 {
+		return nil, fmt.Errorf("nothing to read in %s", b.opts.Path)
+	}
+	return buf.Bytes(), nil
+}
+
+// suggest picks the strongest compression level that keeps up with the
+// target and the smallest chunk size within 5% of the fastest.
+func (b *Bench) suggest(res *Result) settings.Settings {
+	var s settings.Settings
+
+	s.CompressionLevel = b.opts.Levels[0]
+	for i, m := range res.Levels {
+		if m.Throughput() >= b.opts.Target {
+			s.CompressionLevel = b.opts.Levels[i]
+		}
+	}
+
+	var fastest float64
+	for _, m := range res.Encrypt {
+		fastest = max(fastest, m.Throughput())
+	}
+	for i, m := range res.Encrypt {
+		if m.Throughput() >= 0.95*fastest {
+			s.ChunkSize = settings.Size(b.opts.ChunkSizes[i])
+			break
+		}
+	}
+	return s
+}
+
+func measureCompress(name string, data []byte, cfg compress.CompressorConfig) (Measurement, error) {
+	start := time.Now()
+	w, info, err := compress.NewCompressorWithInfo(io.Discard, cfg)
+	if err != nil {
+		return Measurement{}, fmt.Errorf("%s: %w", name, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return Measurement{}, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := w.Close(); err != nil {
+		return Measurement{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return Measurement{Name: name, In: info.BytesInUncompressed, Out: info.BytesOutCompressed, Duration: time.Since(start)}, nil
+}
+
+func measureEncrypt(data []byte, chunkSize int) (Measurement, error) {
+	key, err := enc.NewDataKey()
+	if err != nil {
+		return Measurement{}, err
+	}
+	params, err := enc.NewAEADParams("bench", chunkSize)
+	if err != nil {
+		return Measurement{}, err
+	}
+	out := &countWriter{}
+	start := time.Now()
+	if _, err := enc.EncryptAEAD(out, bytes.NewReader(data), key, params); err != nil {
+		return Measurement{}, fmt.Errorf("encrypt: %w", err)
+	}
+	name := fmt.Sprintf("chunk %s", settings.Size(chunkSize))
+	return Measurement{Name: name, In: int64(len(data)), Out: out.n, Duration: time.Since(start)}, nil
+}
+
+// generate returns n bytes alternating 1 MiB of text-like and random data.
+func generate(n int64) []byte {
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"burrow", "backup", "archive", "block", "cipher", "stream", "object", "bucket", "envelope", "\n"}
+	var b bytes.Buffer
+	b.Grow(int(n))
+	for int64(b.Len()) < n {
+		end := min(int64(b.Len())+1<<20, n)
+		if (b.Len()>>20)%2 == 0 {
+			for int64(b.Len()) < end {
+				b.WriteString(words[rng.Intn(len(words))])
+				b.WriteByte(' ')
+			}
+		} else {
+			chunk := make([]byte, end-int64(b.Len()))
+			rng.Read(chunk)
+			b.Write(chunk)
+		}
+	}
+	return b.Bytes()[:n]
+}
+
+var errLimit = errors.New("sample limit reached")
+
+// limitBuffer collects up to limit bytes and then fails writes, which stops
+// the archiver early.
+type limitBuffer struct {
+	bytes.Buffer
+	limit int64
+}
+
+func (l *limitBuffer) Write(p []byte) (int, error) {
+	rest := l.limit - int64(l.Len())
+	if int64(len(p)) > rest {
+		l.Buffer.Write(p[:rest])
+		return int(rest), errLimit
+	}
+	return l.Buffer.Write(p)
+}
+
+type countWriter struct{ n int64 }
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,48 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBenchSuggests(t *testing.T) {
+	b := NewBench(Options{
+		Size:       2 << 20,
+		Target:     1, // every level keeps up
+		Levels:     []int{1, 3},
+		ChunkSizes: []int{64 << 10, 1 << 20},
+	})
+	res, err := b.Execute(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Levels) != 2 || len(res.Encrypt) != 2 || len(res.Codecs) == 0 {
+		t.Fatalf("unexpected measurements: %+v", res)
+	}
+	if res.Suggests.CompressionLevel != 3 {
+		t.Errorf("suggested level %d, want 3", res.Suggests.CompressionLevel)
+	}
+	if res.Suggests.ChunkSize == 0 {
+		t.Error("no chunk size suggested")
+	}
+	if err := res.Suggests.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBenchArchivesPath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), generate(3<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b := NewBench(Options{Path: dir, Size: 1 << 20, Levels: []int{1}, ChunkSizes: []int{1 << 20}})
+	res, err := b.Execute(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Archive == nil || res.Archive.In != 1<<20 {
+		t.Fatalf("archive measurement %+v", res.Archive)
+	}
+}
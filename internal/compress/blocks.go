@@ -0,0 +1,340 @@
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressBlocks is the block-framed format auto mode wrote before it
+// switched to CompressSeekable. The stream is cut into blocks that are each
+// either stored or an independent zstd frame, so compressible and
+// incompressible parts of one stream (a tar of text files and videos) are
+// each handled appropriately.
+//
+// Every block is a 9-byte header followed by its payload:
+//
+//	kind        uint8   // 0 = stored, 1 = zstd
+//	raw_len     uint32  // big endian, length after decoding
+//	payload_len uint32  // big endian
+const CompressBlocks CompressionMode = "zstd-blocks"
+
+const (
+	blockStored byte = 0
+	blockZstd   byte = 1
+
+	blockHeaderSize = 9
+
+	// DefaultBlockSize is the raw size of each block.
+	DefaultBlockSize = 1 << 20
+	// MinBlockSize is the smallest block size worth its header and frame.
+	MinBlockSize = 64 << 10
+	// MaxBlockSize bounds what a decoder will allocate for one block.
+	MaxBlockSize = 64 << 20
+
+	// probeSize is how much of a stored block is test-compressed to keep
+	// the savings estimate current.
+	probeSize = 64 << 10
+	// estimateWeight is the weight of the newest block in the estimate.
+	estimateWeight = 0.5
+)
+
+type blocksCodec struct{}
+
+func (blocksCodec) Name() CompressionMode { return CompressBlocks }
+
+func (blocksCodec) NewWriter(w io.Writer, opts CodecOptions) (io.WriteCloser, error) {
+	return newBlockWriter(w, CompressorConfig{ZstdLevel: opts.Level, Dictionary: opts.Dictionary}, &CompressInfo{}, false)
+}
+
+func (blocksCodec) NewReader(r io.Reader, opts CodecOptions) (io.ReadCloser, error) {
+	dopts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	if len(opts.Dictionary) > 0 {
+		dopts = append(dopts, zstd.WithDecoderDicts(opts.Dictionary))
+	}
+	dec, err := zstd.NewReader(nil, dopts...)
+	if err != nil {
+		return nil, err
+	}
+	return &blockReader{r: r, dec: dec}, nil
+}
+
+// blockWriter buffers a block at a time and emits it stored or compressed,
+// depending on a rolling estimate of how well the stream compresses. Blocks
+// are framed as CompressBlocks, or as zstd frames with a seek table
+// (CompressSeekable).
+type blockWriter struct {
+	out       io.Writer
+	enc       *zstd.Encoder
+	blockSize int
+	minSaving float64
+	info      *CompressInfo
+	seekable  bool
+	frames    []seekEntry
+
+	buf      []byte
+	scratch  []byte
+	estimate float64
+	store    bool // hinted incompressible: store without probing
+	inN      int64
+	outN     int64
+	closed   bool
+}
+
+func newBlockWriter(w io.Writer, cfg CompressorConfig, info *CompressInfo, seekable bool) (*blockWriter, error) {
+	if cfg.SampleBytes <= 0 || cfg.SampleBytes > MaxBlockSize {
+		cfg.SampleBytes = DefaultBlockSize
+	}
+	if cfg.AutoMinSaving <= 0 {
+		cfg.AutoMinSaving = 0.05
+	}
+	if cfg.ZstdLevel == 0 {
+		cfg.ZstdLevel = 3
+	}
+	eopts := []zstd.EOption{
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(min(max(cfg.ZstdLevel, MinZstdLevel), MaxZstdLevel))),
+		zstd.WithEncoderConcurrency(1),
+	}
+	if len(cfg.Dictionary) > 0 {
+		eopts = append(eopts, zstd.WithEncoderDict(cfg.Dictionary))
+	}
+	enc, err := zstd.NewWriter(nil, eopts...)
+	if err != nil {
+		return nil, err
+	}
+	info.ModeUsed = CompressBlocks
+	if seekable {
+		info.ModeUsed = CompressSeekable
+	}
+	info.Decided = true
+	return &blockWriter{
+		out:       w,
+		enc:       enc,
+		blockSize: cfg.SampleBytes,
+		minSaving: cfg.AutoMinSaving,
+		info:      info,
+		seekable:  seekable,
+		buf:       make([]byte, 0, cfg.SampleBytes),
+		// Start optimistic: the first block is compressed and measured.
+		estimate: 1,
+	}, nil
+}
+
+func (b *blockWriter) Write(p []byte) (int, error) {
+	if b.closed {
+		return 0, errors.New("write to closed compressor")
+	}
+	n := len(p)
+	b.inN += int64(n)
+	for len(p) > 0 {
+		k := min(len(p), b.blockSize-len(b.buf))
+		b.buf = append(b.buf, p[:k]...)
+		p = p[k:]
+		if len(b.buf) == b.blockSize {
+			if err := b.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush writes the buffered block.
+func (b *blockWriter) flush() error {
+	raw := b.buf
+	if len(raw) == 0 {
+		return nil
+	}
+
+	kind, payload := blockStored, raw
+	if b.store {
+		b.info.BlocksHinted++
+	} else if b.estimate < b.minSaving {
+		// Stored blocks are probed so the estimate recovers when the
+		// content becomes compressible again.
+		n := min(len(raw), probeSize)
+		start := (len(raw) - n) / 2
+		probe := raw[start : start+n]
+		b.scratch = b.enc.EncodeAll(probe, b.scratch[:0])
+		b.observe(probe, len(b.scratch))
+		b.info.SampledBytes += len(probe)
+	}
+	if !b.store && b.estimate >= b.minSaving {
+		b.scratch = b.enc.EncodeAll(raw, b.scratch[:0])
+		b.observe(raw, len(b.scratch))
+		if len(b.scratch) < len(raw) {
+			kind, payload = blockZstd, b.scratch
+		}
+	}
+
+	if err := b.writeBlock(kind, raw, payload); err != nil {
+		return err
+	}
+	if kind == blockZstd {
+		b.info.BlocksCompressed++
+	} else {
+		b.info.BlocksStored++
+	}
+	b.buf = b.buf[:0]
+	return nil
+}
+
+// writeBlock frames and writes one block.
+func (b *blockWriter) writeBlock(kind byte, raw, payload []byte) error {
+	if b.seekable {
+		if kind == blockStored {
+			b.scratch = appendRawFrame(b.scratch[:0], raw)
+			payload = b.scratch
+		}
+		if _, err := b.out.Write(payload); err != nil {
+			return err
+		}
+		b.outN += int64(len(payload))
+		b.frames = append(b.frames, seekEntry{compressed: uint32(len(payload)), raw: uint32(len(raw))})
+		return nil
+	}
+
+	var hdr [blockHeaderSize]byte
+	hdr[0] = kind
+	binary.BigEndian.PutUint32(hdr[1:5], uint32(len(raw)))
+	binary.BigEndian.PutUint32(hdr[5:9], uint32(len(payload)))
+	if _, err := b.out.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := b.out.Write(payload); err != nil {
+		return err
+	}
+	b.outN += int64(blockHeaderSize + len(payload))
+	return nil
+}
+
+// Hint implements Hinter. Switching between hinted-incompressible and other
+// data ends the current block, so blocks don't mix the two.
+func (b *blockWriter) Hint(h Hint) error {
+	store := h == HintStore
+	if store == b.store {
+		return nil
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+	b.store = store
+	return nil
+}
+
+// observe folds the savings of one compression attempt into the estimate.
+func (b *blockWriter) observe(raw []byte, compressed int) {
+	saving := 1 - float64(compressed)/float64(len(raw))
+	b.estimate = estimateWeight*saving + (1-estimateWeight)*b.estimate
+	b.info.EstimatedSavings = b.estimate
+}
+
+func (b *blockWriter) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	err := b.flush()
+	if err == nil && b.seekable {
+		table := appendSeekTable(nil, b.frames)
+		_, err = b.out.Write(table)
+		b.outN += int64(len(table))
+		b.info.SeekFrames = len(b.frames)
+		b.info.SeekTableSize = int64(len(table))
+	}
+	if cerr := b.enc.Close(); err == nil {
+		err = cerr
+	}
+	b.info.BytesInUncompressed = b.inN
+	b.info.BytesOutCompressed = b.outN
+	if b.inN > 0 {
+		b.info.FinalSavings = 1 - float64(b.outN)/float64(b.inN)
+	} else {
+		b.info.FinalSavings = -1
+	}
+	return err
+}
+
+// blockReader decodes a block-framed stream one block at a time.
+type blockReader struct {
+	r       io.Reader
+	dec     *zstd.Decoder
+	payload []byte
+	block   []byte
+	pending []byte
+	err     error
+}
+
+func (b *blockReader) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		b.err = b.next()
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+// next reads and decodes the following block into pending.
+func (b *blockReader) next() error {
+	var hdr [blockHeaderSize]byte
+	if _, err := io.ReadFull(b.r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return fmt.Errorf("block header: %w", noEOF(err))
+	}
+	kind := hdr[0]
+	rawLen := int(binary.BigEndian.Uint32(hdr[1:5]))
+	payloadLen := int(binary.BigEndian.Uint32(hdr[5:9]))
+	if rawLen > MaxBlockSize || payloadLen > MaxBlockSize {
+		return fmt.Errorf("block too large (%d bytes)", max(rawLen, payloadLen))
+	}
+
+	if cap(b.payload) < payloadLen {
+		b.payload = make([]byte, payloadLen)
+	}
+	b.payload = b.payload[:payloadLen]
+	if _, err := io.ReadFull(b.r, b.payload); err != nil {
+		return fmt.Errorf("block payload: %w", noEOF(err))
+	}
+
+	switch kind {
+	case blockStored:
+		if payloadLen != rawLen {
+			return fmt.Errorf("stored block length mismatch")
+		}
+		b.block = append(b.block[:0], b.payload...)
+	case blockZstd:
+		out, err := b.dec.DecodeAll(b.payload, b.block[:0])
+		if err != nil {
+			return fmt.Errorf("zstd block: %w", err)
+		}
+		if len(out) != rawLen {
+			return fmt.Errorf("zstd block decoded to %d bytes, want %d", len(out), rawLen)
+		}
+		b.block = out
+	default:
+		return fmt.Errorf("unknown block kind %d", kind)
+	}
+	b.pending = b.block
+	return nil
+}
+
+func (b *blockReader) Close() error {
+	b.dec.Close()
+	return nil
+}
+
+// noEOF turns a clean EOF inside a block into ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
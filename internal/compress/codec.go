@@ -0,0 +1,258 @@
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// Codecs in addition to none and zstd.
+const (
+	CompressZstdLong CompressionMode = "zstd-long"
+	CompressLZ4      CompressionMode = "lz4"
+	CompressGzip     CompressionMode = "gzip"
+	CompressXZ       CompressionMode = "xz"
+)
+
+// Zstd compression levels accepted by the zstd codecs.
+const (
+	MinZstdLevel = 1
+	MaxZstdLevel = 19
+)
+
+// zstdLongWindow is the window size of zstd-long (128 MiB, like `zstd --long`).
+const zstdLongWindow = 1 << 27
+
+// CodecOptions tune a codec. Zero values select the codec's defaults.
+type CodecOptions struct {
+	// Level is the zstd level; 0 selects the default. Other codecs always
+	// use their default level.
+	Level int
+	// Dictionary is a trained zstd dictionary. Only zstd codecs accept one,
+	// and the same dictionary is needed to decompress.
+	Dictionary []byte
+}
+
+// Codec is a stream compression format. The codec name is what gets recorded
+// in the envelope, so it must never change once released.
+type Codec interface {
+	Name() CompressionMode
+	NewWriter(w io.Writer, opts CodecOptions) (io.WriteCloser, error)
+	NewReader(r io.Reader, opts CodecOptions) (io.ReadCloser, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[CompressionMode]Codec{}
+)
+
+// Register makes a codec available by name. It panics on duplicates.
+func Register(c Codec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[c.Name()]; dup {
+		panic(fmt.Sprintf("compress: codec %q registered twice", c.Name()))
+	}
+	registry[c.Name()] = c
+}
+
+// Lookup returns the codec registered under name. An empty name is treated as
+// none, which older envelopes may record.
+func Lookup(name CompressionMode) (Codec, error) {
+	if name == "" {
+		name = CompressNone
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression codec %q", name)
+	}
+	return c, nil
+}
+
+// Codecs returns the names of all registered codecs, sorted.
+func Codecs() []CompressionMode {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]CompressionMode, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// ParseMode validates a compression mode given by the user: "auto" or the
+// name of a registered codec. An empty string selects auto.
+func ParseMode(s string) (CompressionMode, error) {
+	mode := CompressionMode(s)
+	if mode == "" || mode == CompressAuto {
+		return CompressAuto, nil
+	}
+	if _, err := Lookup(mode); err != nil {
+		return "", err
+	}
+	return mode, nil
+}
+
+// SupportsDictionary reports whether the named codec accepts a dictionary.
+// Auto mode compresses with zstd, so it does.
+func SupportsDictionary(mode CompressionMode) bool {
+	switch mode {
+	case CompressAuto, CompressZstd, CompressZstdLong, CompressBlocks, CompressSeekable:
+		return true
+	}
+	return false
+}
+
+// NewDecompressor returns a reader that decompresses r with the named codec.
+func NewDecompressor(r io.Reader, name CompressionMode, opts CodecOptions) (io.ReadCloser, error) {
+	c, err := Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.NewReader(r, opts)
+}
+
+func init() {
+	Register(noneCodec{})
+	Register(zstdCodec{name: CompressZstd})
+	Register(zstdCodec{name: CompressZstdLong, window: zstdLongWindow})
+	Register(lz4Codec{})
+	Register(gzipCodec{})
+	Register(xzCodec{})
+	Register(blocksCodec{})
+	Register(seekableCodec{})
+}
+
+// errNoDictionary is returned by codecs that cannot use a dictionary.
+func errNoDictionary(name CompressionMode) error {
+	return fmt.Errorf("codec %q does not support dictionaries", name)
+}
+
+// ---- none ----
+
+type noneCodec struct{}
+
+func (noneCodec) Name() CompressionMode { return CompressNone }
+
+func (noneCodec) NewWriter(w io.Writer, opts CodecOptions) (io.WriteCloser, error) {
+	if len(opts.Dictionary) > 0 {
+		return nil, errNoDictionary(CompressNone)
+	}
+	return nopWriteCloser{w}, nil
+}
+
+func (noneCodec) NewReader(r io.Reader, _ CodecOptions) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// ---- zstd, zstd-long ----
+
+type zstdCodec struct {
+	name   CompressionMode
+	window int // 0 selects the encoder default
+}
+
+func (c zstdCodec) Name() CompressionMode { return c.name }
+
+func (c zstdCodec) NewWriter(w io.Writer, opts CodecOptions) (io.WriteCloser, error) {
+	level := opts.Level
+	if level == 0 {
+		level = 3
+	}
+	eopts := []zstd.EOption{
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(min(max(level, MinZstdLevel), MaxZstdLevel))),
+		zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0)),
+	}
+	if c.window > 0 {
+		eopts = append(eopts, zstd.WithWindowSize(c.window))
+	}
+	if len(opts.Dictionary) > 0 {
+		eopts = append(eopts, zstd.WithEncoderDict(opts.Dictionary))
+	}
+	return zstd.NewWriter(w, eopts...)
+}
+
+func (c zstdCodec) NewReader(r io.Reader, opts CodecOptions) (io.ReadCloser, error) {
+	dopts := []zstd.DOption{zstd.WithDecoderMaxWindow(zstd.MaxWindowSize)}
+	if len(opts.Dictionary) > 0 {
+		dopts = append(dopts, zstd.WithDecoderDicts(opts.Dictionary))
+	}
+	d, err := zstd.NewReader(r, dopts...)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+// ---- lz4 ----
+
+type lz4Codec struct{}
+
+func (lz4Codec) Name() CompressionMode { return CompressLZ4 }
+
+func (lz4Codec) NewWriter(w io.Writer, opts CodecOptions) (io.WriteCloser, error) {
+	if len(opts.Dictionary) > 0 {
+		return nil, errNoDictionary(CompressLZ4)
+	}
+	zw := lz4.NewWriter(w)
+	if err := zw.Apply(lz4.ConcurrencyOption(runtime.GOMAXPROCS(0))); err != nil {
+		return nil, fmt.Errorf("lz4 options: %w", err)
+	}
+	return zw, nil
+}
+
+func (lz4Codec) NewReader(r io.Reader, _ CodecOptions) (io.ReadCloser, error) {
+	return io.NopCloser(lz4.NewReader(r)), nil
+}
+
+// ---- gzip ----
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() CompressionMode { return CompressGzip }
+
+func (gzipCodec) NewWriter(w io.Writer, opts CodecOptions) (io.WriteCloser, error) {
+	if len(opts.Dictionary) > 0 {
+		return nil, errNoDictionary(CompressGzip)
+	}
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCodec) NewReader(r io.Reader, _ CodecOptions) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// ---- xz ----
+
+type xzCodec struct{}
+
+func (xzCodec) Name() CompressionMode { return CompressXZ }
+
+func (xzCodec) NewWriter(w io.Writer, opts CodecOptions) (io.WriteCloser, error) {
+	if len(opts.Dictionary) > 0 {
+		return nil, errNoDictionary(CompressXZ)
+	}
+	return xz.NewWriter(w)
+}
+
+func (xzCodec) NewReader(r io.Reader, _ CodecOptions) (io.ReadCloser, error) {
+	xr, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(xr), nil
+}
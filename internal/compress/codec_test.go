@@ -0,0 +1,146 @@
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func compressible(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < n; i++ {
+		fmt.Fprintf(&b, `{"id":%d,"name":"item-%d","tags":["a","b"]}`+"\n", i, i%17)
+	}
+	return b.Bytes()[:n]
+}
+
+func roundTrip(t *testing.T, cfg CompressorConfig, data []byte) *CompressInfo {
+	t.Helper()
+	var out bytes.Buffer
+	w, info, err := NewCompressorWithInfo(&out, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewDecompressor(&out, info.ModeUsed, CodecOptions{Dictionary: cfg.Dictionary})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("%s: round trip mismatch (%d != %d bytes)", info.ModeUsed, len(got), len(data))
+	}
+	return info
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	data := compressible(256 << 10)
+	for _, name := range Codecs() {
+		t.Run(string(name), func(t *testing.T) {
+			info := roundTrip(t, CompressorConfig{Mode: name}, data)
+			if info.ModeUsed != name {
+				t.Fatalf("ModeUsed = %q", info.ModeUsed)
+			}
+			if name != CompressNone && info.FinalSavings <= 0 {
+				t.Errorf("no savings on compressible input: %v", info.FinalSavings)
+			}
+		})
+	}
+}
+
+func TestAutoMixedBlocks(t *testing.T) {
+	noise := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(noise)
+	data := append(compressible(2<<20), noise...)
+	data = append(data, compressible(2<<20)...)
+
+	info := roundTrip(t, CompressorConfig{Mode: CompressAuto, SampleBytes: 1 << 20}, data)
+	if info.ModeUsed != CompressSeekable {
+		t.Fatalf("ModeUsed = %q, want %s", info.ModeUsed, CompressSeekable)
+	}
+	// The text at the start and at the end is compressed, the noise is not.
+	if info.BlocksCompressed < 4 || info.BlocksStored < 6 {
+		t.Errorf("compressed %d, stored %d blocks", info.BlocksCompressed, info.BlocksStored)
+	}
+	if info.BytesOutCompressed > int64(len(noise))+1<<20 {
+		t.Errorf("output %d bytes for %d bytes of noise", info.BytesOutCompressed, len(noise))
+	}
+}
+
+func TestBlocksTruncated(t *testing.T) {
+	var out bytes.Buffer
+	w, err := blocksCodec{}.NewWriter(&out, CodecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(compressible(64 << 10))
+	w.Close()
+
+	r, err := NewDecompressor(bytes.NewReader(out.Bytes()[:out.Len()-3]), CompressBlocks, CodecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("decoded a truncated stream")
+	}
+}
+
+func TestDictionary(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 500; i++ {
+		samples = append(samples, compressible(512+i))
+	}
+	d, err := TrainDictionary(samples, 16<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	small := compressible(300)
+	for _, mode := range []CompressionMode{CompressZstd, CompressZstdLong, CompressAuto} {
+		roundTrip(t, CompressorConfig{Mode: mode, Dictionary: d}, small)
+	}
+
+	// Without the dictionary the stream cannot be decoded.
+	var out bytes.Buffer
+	w, _, err := NewCompressorWithInfo(&out, CompressorConfig{Mode: CompressZstd, Dictionary: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(small)
+	w.Close()
+	r, err := NewDecompressor(&out, CompressZstd, CodecOptions{})
+	if err == nil {
+		_, err = io.ReadAll(r)
+	}
+	if err == nil {
+		t.Fatal("decoded a dictionary stream without the dictionary")
+	}
+
+	if _, _, err := NewCompressorWithInfo(io.Discard, CompressorConfig{Mode: CompressGzip, Dictionary: d}); err == nil {
+		t.Fatal("gzip accepted a dictionary")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	if c, err := Lookup(""); err != nil || c.Name() != CompressNone {
+		t.Fatalf("Lookup(\"\") = %v, %v", c, err)
+	}
+	if _, err := Lookup("brotli"); err == nil {
+		t.Fatal("Lookup accepted an unknown codec")
+	}
+	if _, err := ParseMode("auto"); err != nil {
+		t.Fatal(err)
+	}
+}
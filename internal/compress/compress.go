@@ -1,12 +1,8 @@
 package compress
 
 import (
-	"bytes"
 	"fmt"
 	"io"
-	"runtime"
-
-	"github.com/klauspost/compress/zstd"
 )
 
 type CompressionMode string
@@ -19,18 +15,20 @@ const (
 
 // CompressorConfig controls compression behavior.
 type CompressorConfig struct {
-	Mode          CompressionMode // none | zstd | auto
+	Mode          CompressionMode // auto or any registered codec (see Codecs)
 	ZstdLevel     int             // 1..19 (3 is a great default)
-	AutoMinSaving float64         // e.g. 0.05 (5%) threshold to enable in auto
-	SampleBytes   int             // bytes to sample in auto (default 4<<20)
+	AutoMinSaving float64         // e.g. 0.05 (5%) threshold to compress a block in auto
+	SampleBytes   int             // block size in auto (default 1<<20)
+	Dictionary    []byte          // trained zstd dictionary (zstd, zstd-long, auto)
 }
 
 // CompressInfo reports what happened.
 type CompressInfo struct {
 	ModeRequested CompressionMode // what you asked for
-	ModeUsed      CompressionMode // what actually got used (none or zstd)
+	ModeUsed      CompressionMode // what actually got used (a codec name, never auto)
 
-	// Estimated savings from the auto sample (only in auto; -1 if not applicable).
+	// Rolling savings estimate at the end of the stream (only in auto; -1 if
+	// not applicable).
 	EstimatedSavings float64
 
 	// Final end-to-end savings after Close():
@@ -42,17 +40,24 @@ type CompressInfo struct {
 	BytesInUncompressed int64
 	BytesOutCompressed  int64
 
-	SampledBytes int // how many bytes were sampled (auto only)
+	SampledBytes int // bytes test-compressed in stored blocks (auto only)
 	Decided      bool
+
+	// Block counts (auto only).
+	BlocksCompressed int
+	BlocksStored     int
+	BlocksHinted     int // stored blocks that were not even probed (see Hint)
+
+	// Seek table of a CompressSeekable stream: the number of frames and the
+	// size of the table, which ends the stream.
+	SeekFrames    int
+	SeekTableSize int64
 }
 
 // NewCompressorWithInfo wraps w with the chosen compression and returns:
 // - an io.WriteCloser for the caller to write uncompressed data into,
 // - a *CompressInfo that will be filled as the stream progresses/finishes.
 func NewCompressorWithInfo(w io.Writer, cfg CompressorConfig) (io.WriteCloser, *CompressInfo, error) {
-	if cfg.SampleBytes <= 0 {
-		cfg.SampleBytes = 4 << 20 // 4MiB
-	}
 	if cfg.AutoMinSaving <= 0 {
 		cfg.AutoMinSaving = 0.05
 	}
@@ -62,7 +67,7 @@ func NewCompressorWithInfo(w io.Writer, cfg CompressorConfig) (io.WriteCloser, *
 
 	info := &CompressInfo{
 		ModeRequested:    cfg.Mode,
-		ModeUsed:         cfg.Mode, // may change to none in auto
+		ModeUsed:         cfg.Mode, // zstd-seekable in auto
 		EstimatedSavings: -1,
 		FinalSavings:     -1,
 	}
@@ -73,6 +78,9 @@ func NewCompressorWithInfo(w io.Writer, cfg CompressorConfig) (io.WriteCloser, *
 	switch cfg.Mode {
 	case CompressNone:
 		// Unified stream writer with no encoder (passthrough).
+		if len(cfg.Dictionary) > 0 {
+			return nil, nil, errNoDictionary(CompressNone)
+		}
 		info.Decided = true
 		info.ModeUsed = CompressNone
 		// Defaults so callers can log immediately; final numbers filled on Close.
@@ -80,27 +88,37 @@ func NewCompressorWithInfo(w io.Writer, cfg CompressorConfig) (io.WriteCloser, *
 		info.FinalSavings = 0
 		return &streamCompressor{enc: nil, out: cw, info: info}, info, nil
 
-	case CompressZstd:
-		enc, err := newZstdEncoder(cw, cfg.ZstdLevel)
+	case CompressAuto:
+		// Each block is compressed or stored on its own, in its own zstd
+		// frame; see CompressSeekable.
+		bw, err := newBlockWriter(w, cfg, info, true)
 		if err != nil {
 			return nil, nil, err
 		}
-		info.Decided = true
-		info.ModeUsed = CompressZstd
-		return &streamCompressor{enc: enc, out: cw, info: info}, info, nil
+		return bw, info, nil
 
-	case CompressAuto:
-		ac := &adaptiveCompressor{
-			cfg:  cfg,
-			out:  cw,
-			buf:  &bytes.Buffer{},
-			info: info,
+	default:
+		codec, err := Lookup(cfg.Mode)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unknown compression mode %q", cfg.Mode)
+		}
+		enc, err := codec.NewWriter(cw, cfg.codecOptions(codec.Name()))
+		if err != nil {
+			return nil, nil, err
 		}
-		return ac, info, nil
+		info.Decided = true
+		info.ModeUsed = codec.Name()
+		return &streamCompressor{enc: enc, out: cw, info: info}, info, nil
+	}
+}
 
-	default:
-		return nil, nil, fmt.Errorf("unknown compression mode %q", cfg.Mode)
+// codecOptions maps the config onto the options of the named codec.
+func (cfg CompressorConfig) codecOptions(name CompressionMode) CodecOptions {
+	opts := CodecOptions{Dictionary: cfg.Dictionary}
+	if name == CompressZstd || name == CompressZstdLong {
+		opts.Level = cfg.ZstdLevel
 	}
+	return opts
 }
 
 // ---------- internals ----------
@@ -116,25 +134,10 @@ func (c *countingWriter) Write(p []byte) (int, error) {
 	return n, err
 }
 
-func newZstdEncoder(w io.Writer, lvl int) (*zstd.Encoder, error) {
-	// clamp and map to zstd level
-	if lvl < 1 {
-		lvl = 1
-	}
-	if lvl > 19 {
-		lvl = 19
-	}
-	// Enable parallel compression using available CPU cores
-	return zstd.NewWriter(w,
-		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(lvl)),
-		zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0)),
-	)
-}
-
-// ---- unified writer for none|zstd ----
+// ---- unified writer for none and fixed codecs ----
 
 type streamCompressor struct {
-	enc  *zstd.Encoder   // nil => passthrough
+	enc  io.WriteCloser  // nil => passthrough
 	out  *countingWriter // counts compressed bytes written
 	info *CompressInfo
 
@@ -164,121 +167,3 @@ func (s *streamCompressor) Close() error {
 	}
 	return err
 }
-
-// ---- auto mode ----
-
-type adaptiveCompressor struct {
-	cfg  CompressorConfig
-	out  *countingWriter
-	buf  *bytes.Buffer
-	info *CompressInfo
-
-	decided bool
-	useZstd bool
-
-	zenc *zstd.Encoder
-	inN  int64
-}
-
-func (a *adaptiveCompressor) Write(p []byte) (int, error) {
-	a.inN += int64(len(p))
-	if a.decided {
-		if a.useZstd {
-			return a.zenc.Write(p)
-		}
-		return a.out.Write(p)
-	}
-
-	// Buffer until we reach SampleBytes, then decide.
-	if _, err := a.buf.Write(p); err != nil {
-		return 0, err
-	}
-	if a.buf.Len() >= a.cfg.SampleBytes {
-		if err := a.decideAndFlush(); err != nil {
-			return 0, err
-		}
-	}
-	return len(p), nil
-}
-
-func (a *adaptiveCompressor) decideAndFlush() error {
-	if a.decided {
-		return nil
-	}
-	a.decided = true
-
-	sample := a.buf.Bytes()
-	a.info.SampledBytes = len(sample)
-
-	// Estimate zstd savings on the sample.
-	var tmp bytes.Buffer
-	enc, err := newZstdEncoder(&tmp, a.cfg.ZstdLevel)
-	if err != nil {
-		return err
-	}
-	if _, err := enc.Write(sample); err != nil {
-		return err
-	}
-	if err := enc.Close(); err != nil {
-		return err
-	}
-
-	if len(sample) > 0 {
-		a.info.EstimatedSavings = 1 - float64(tmp.Len())/float64(len(sample))
-	} else {
-		a.info.EstimatedSavings = -1
-	}
-
-	a.useZstd = a.info.EstimatedSavings >= a.cfg.AutoMinSaving
-	if a.useZstd {
-		a.info.ModeUsed = CompressZstd
-		a.zenc, err = newZstdEncoder(a.out, a.cfg.ZstdLevel)
-		if err != nil {
-			return err
-		}
-		// Feed the buffered sample through the real encoder.
-		if _, err := a.zenc.Write(sample); err != nil {
-			return err
-		}
-	} else {
-		a.info.ModeUsed = CompressNone
-		// Passthrough: write buffered bytes as-is.
-		if _, err := a.out.Write(sample); err != nil {
-			return err
-		}
-	}
-	// release buffer memory
-	a.buf = &bytes.Buffer{}
-	a.info.Decided = true
-	return nil
-}
-
-func (a *adaptiveCompressor) Close() error {
-	// If caller closed before reaching SampleBytes, still decide.
-	if !a.decided {
-		if err := a.decideAndFlush(); err != nil {
-			return err
-		}
-	}
-
-	var err error
-	if a.useZstd && a.zenc != nil {
-		err = a.zenc.Close()
-	}
-
-	// Fill final counters/savings.
-	a.info.BytesInUncompressed = a.inN
-	a.info.BytesOutCompressed = a.out.n
-	if a.inN > 0 {
-		a.info.FinalSavings = 1 - float64(a.out.n)/float64(a.inN)
-	} else {
-		a.info.FinalSavings = -1
-	}
-	return err
-}
-
-// --------- optional: decoder helper (for restore) ---------
-
-func NewZstdDecoder(r io.Reader) (*zstd.Decoder, error) {
-	return zstd.NewReader(r)
-}
@@ -0,0 +1,34 @@
+package compress
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultDictionarySize is the size of dictionaries trained by
+// TrainDictionary when no size is given (the zstd CLI default).
+const DefaultDictionarySize = 110 << 10
+
+// TrainDictionary builds a zstd dictionary of at most maxSize bytes from
+// samples. Samples should be small, similar inputs such as individual files
+// of one kind; a few hundred of them are needed for a useful dictionary.
+func TrainDictionary(samples [][]byte, maxSize int) ([]byte, error) {
+	if len(samples) == 0 {
+		return nil, errors.New("no samples to train on")
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultDictionarySize
+	}
+	d, err := dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: maxSize,
+		HashBytes:   6,
+		ZstdLevel:   zstd.SpeedDefault,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("train dictionary: %w", err)
+	}
+	return d, nil
+}
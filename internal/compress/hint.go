@@ -0,0 +1,97 @@
+package compress
+
+import (
+	"bytes"
+	"math"
+	"path"
+	"strings"
+)
+
+// Hint tells a compressor what kind of data follows.
+type Hint int
+
+const (
+	// HintAuto leaves the decision to the compressor.
+	HintAuto Hint = iota
+	// HintStore marks data that is already compressed or random.
+	HintStore
+)
+
+// Hinter is implemented by compressors that accept hints about the data
+// written next (auto mode does).
+type Hinter interface {
+	Hint(h Hint) error
+}
+
+const (
+	// HeadSize is how much leading content Classify wants to see.
+	HeadSize = 16 << 10
+	// minHintSize is the smallest file worth a block of its own; smaller
+	// files stay with their neighbours and the rolling estimate.
+	minHintSize = 64 << 10
+	// entropyThreshold in bits per byte; compressed or encrypted content
+	// sits just below 8 on a HeadSize sample, text far below.
+	entropyThreshold = 7.9
+)
+
+// storedExtensions are file types that are compressed already.
+var storedExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true,
+	".mp4": true, ".m4v": true, ".mkv": true, ".mov": true, ".webm": true, ".avi": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".txz": true, ".zst": true,
+	".lz4": true, ".7z": true, ".rar": true, ".br": true,
+	".jar": true, ".apk": true, ".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".epub": true,
+	".age": true, ".gpg": true, ".enc": true,
+}
+
+// storedMagic are signatures of compressed formats.
+var storedMagic = [][]byte{
+	{0x28, 0xb5, 0x2f, 0xfd},            // zstd
+	{0x1f, 0x8b},                        // gzip
+	{0xfd, '7', 'z', 'X', 'Z', 0x00},    // xz
+	{0x04, 0x22, 0x4d, 0x18},            // lz4
+	{'P', 'K', 0x03, 0x04},              // zip
+	{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c},  // 7z
+	{'B', 'Z', 'h'},                     // bzip2
+	{0xff, 0xd8, 0xff},                  // jpeg
+	{0x89, 'P', 'N', 'G'},               // png
+	{'a', 'g', 'e', '-', 'e', 'n', 'c'}, // age
+}
+
+// Classify guesses whether a file is worth compressing from its name, size
+// and up to HeadSize leading bytes.
+func Classify(name string, size int64, head []byte) Hint {
+	if size < minHintSize {
+		return HintAuto
+	}
+	if storedExtensions[strings.ToLower(path.Ext(name))] {
+		return HintStore
+	}
+	for _, m := range storedMagic {
+		if bytes.HasPrefix(head, m) {
+			return HintStore
+		}
+	}
+	if len(head) >= HeadSize && entropy(head) >= entropyThreshold {
+		return HintStore
+	}
+	return HintAuto
+}
+
+// entropy returns the Shannon entropy of b in bits per byte.
+func entropy(b []byte) float64 {
+	var counts [256]int
+	for _, c := range b {
+		counts[c]++
+	}
+	var h float64
+	n := float64(len(b))
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
@@ -0,0 +1,71 @@
+package compress
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	noise := make([]byte, HeadSize)
+	rand.New(rand.NewSource(1)).Read(noise)
+	text := compressible(HeadSize)
+
+	tests := []struct {
+		name string
+		size int64
+		head []byte
+		want Hint
+	}{
+		{"a/photo.JPG", 1 << 20, text, HintStore},
+		{"a/movie.mp4", 1 << 20, text, HintStore},
+		{"a/small.jpg", 1 << 10, text, HintAuto},
+		{"a/data.bin", 1 << 20, append([]byte{0x28, 0xb5, 0x2f, 0xfd}, text...), HintStore},
+		{"a/data.bin", 1 << 20, noise, HintStore},
+		{"a/data.bin", 1 << 20, text, HintAuto},
+		{"a/notes.txt", 1 << 20, text, HintAuto},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.name, tt.size, tt.head); got != tt.want {
+			t.Errorf("Classify(%q, %d) = %v, want %v", tt.name, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestHintedBlocksAreStored(t *testing.T) {
+	var out bytes.Buffer
+	w, info, err := NewCompressorWithInfo(&out, CompressorConfig{Mode: CompressAuto, SampleBytes: 64 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := w.(Hinter)
+
+	text := compressible(256 << 10)
+	// Compressible content marked as stored must not be compressed.
+	for _, hint := range []Hint{HintAuto, HintStore, HintAuto} {
+		if err := h.Hint(hint); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if info.BlocksHinted != 4 || info.BlocksStored != 4 || info.BlocksCompressed != 8 {
+		t.Fatalf("hinted %d, stored %d, compressed %d blocks", info.BlocksHinted, info.BlocksStored, info.BlocksCompressed)
+	}
+
+	r, err := NewDecompressor(&out, CompressSeekable, CodecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if _, err := got.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), bytes.Repeat(text, 3)) {
+		t.Fatal("round trip mismatch")
+	}
+}
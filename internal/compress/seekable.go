@@ -0,0 +1,247 @@
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressSeekable is the format written by auto mode: the blocks of
+// CompressBlocks as independent zstd frames (stored blocks become frames of
+// raw zstd blocks), followed by a seek table in the zstd seekable format
+// (https://github.com/facebook/zstd/blob/dev/contrib/seekable_format). Any
+// zstd decoder reads it as a regular stream; NewSeekableReader uses the
+// table to decode only the frames a read touches.
+const CompressSeekable CompressionMode = "zstd-seekable"
+
+const (
+	zstdMagic          = 0xFD2FB528
+	skippableMagic     = 0x184D2A5E // first skippable frame magic; the seek table uses it
+	seekableMagic      = 0x8F92EAB1
+	seekFooterSize     = 9
+	seekEntrySize      = 8
+	seekChecksumFlag   = 1 << 7
+	maxRawBlockSize    = 128 << 10
+	skippableHeaderLen = 8
+)
+
+// seekEntry is one frame of the seek table.
+type seekEntry struct {
+	compressed uint32
+	raw        uint32
+}
+
+// appendRawFrame appends a zstd frame holding raw as uncompressed blocks.
+func appendRawFrame(dst, raw []byte) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, zstdMagic)
+	// Frame header: single segment, 4-byte content size, no checksum or dict.
+	dst = append(dst, 0xA0)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(raw)))
+	for len(raw) > 0 {
+		n := min(len(raw), maxRawBlockSize)
+		hdr := uint32(n) << 3 // block type 0: raw
+		if n == len(raw) {
+			hdr |= 1 // last block
+		}
+		dst = append(dst, byte(hdr), byte(hdr>>8), byte(hdr>>16))
+		dst = append(dst, raw[:n]...)
+		raw = raw[n:]
+	}
+	return dst
+}
+
+// appendSeekTable appends the seek table for frames as a skippable frame.
+func appendSeekTable(dst []byte, frames []seekEntry) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, skippableMagic)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(frames)*seekEntrySize+seekFooterSize))
+	for _, f := range frames {
+		dst = binary.LittleEndian.AppendUint32(dst, f.compressed)
+		dst = binary.LittleEndian.AppendUint32(dst, f.raw)
+	}
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(frames)))
+	dst = append(dst, 0) // descriptor: no checksums
+	dst = binary.LittleEndian.AppendUint32(dst, seekableMagic)
+	return dst
+}
+
+type seekableCodec struct{}
+
+func (seekableCodec) Name() CompressionMode { return CompressSeekable }
+
+func (seekableCodec) NewWriter(w io.Writer, opts CodecOptions) (io.WriteCloser, error) {
+	return newBlockWriter(w, CompressorConfig{ZstdLevel: opts.Level, Dictionary: opts.Dictionary}, &CompressInfo{}, true)
+}
+
+// NewReader decodes the stream sequentially; the seek table is a skippable
+// frame and ignored.
+func (seekableCodec) NewReader(r io.Reader, opts CodecOptions) (io.ReadCloser, error) {
+	return zstdCodec{name: CompressSeekable}.NewReader(r, opts)
+}
+
+// SeekableReader gives random access to a CompressSeekable stream. It
+// implements io.ReaderAt and io.ReadSeeker over the uncompressed data.
+type SeekableReader struct {
+	ra  io.ReaderAt
+	dec *zstd.Decoder
+
+	// starts[i] is the uncompressed offset of frame i, offsets[i] its
+	// compressed offset; both have a final entry for the end.
+	starts  []int64
+	offsets []int64
+
+	cached int // frame held in frame, -1 for none
+	frame  []byte
+	buf    []byte
+	pos    int64
+}
+
+// NewSeekableReader reads the seek table of the size-byte stream in ra. The
+// table must end the stream; size is usually recorded in the envelope.
+func NewSeekableReader(ra io.ReaderAt, size int64, opts CodecOptions) (*SeekableReader, error) {
+	if size < skippableHeaderLen+seekFooterSize {
+		return nil, errors.New("seekable: stream too short")
+	}
+	var footer [seekFooterSize]byte
+	if _, err := ra.ReadAt(footer[:], size-seekFooterSize); err != nil {
+		return nil, fmt.Errorf("seekable: read footer: %w", err)
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekableMagic {
+		return nil, errors.New("seekable: no seek table")
+	}
+	n := int64(binary.LittleEndian.Uint32(footer[:4]))
+	entrySize := int64(seekEntrySize)
+	if footer[4]&seekChecksumFlag != 0 {
+		entrySize += 4
+	}
+	tableSize := skippableHeaderLen + n*entrySize + seekFooterSize
+	if tableSize > size {
+		return nil, errors.New("seekable: seek table larger than stream")
+	}
+	table := make([]byte, n*entrySize)
+	if _, err := ra.ReadAt(table, size-seekFooterSize-int64(len(table))); err != nil {
+		return nil, fmt.Errorf("seekable: read seek table: %w", err)
+	}
+
+	r := &SeekableReader{
+		ra:      ra,
+		starts:  make([]int64, n+1),
+		offsets: make([]int64, n+1),
+		cached:  -1,
+	}
+	for i := int64(0); i < n; i++ {
+		e := table[i*entrySize:]
+		r.offsets[i+1] = r.offsets[i] + int64(binary.LittleEndian.Uint32(e[0:4]))
+		r.starts[i+1] = r.starts[i] + int64(binary.LittleEndian.Uint32(e[4:8]))
+	}
+	if r.offsets[n] != size-tableSize {
+		return nil, errors.New("seekable: seek table does not match stream size")
+	}
+
+	dopts := []zstd.DOption{zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstd.MaxWindowSize)}
+	if len(opts.Dictionary) > 0 {
+		dopts = append(dopts, zstd.WithDecoderDicts(opts.Dictionary))
+	}
+	dec, err := zstd.NewReader(nil, dopts...)
+	if err != nil {
+		return nil, err
+	}
+	r.dec = dec
+	return r, nil
+}
+
+// Size returns the uncompressed size.
+func (r *SeekableReader) Size() int64 {
+	return r.starts[len(r.starts)-1]
+}
+
+// Frames returns the number of frames.
+func (r *SeekableReader) Frames() int {
+	return len(r.starts) - 1
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *SeekableReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("seekable: negative offset")
+	}
+	n := 0
+	for n < len(p) {
+		if off >= r.Size() {
+			return n, io.EOF
+		}
+		i := sort.Search(r.Frames(), func(i int) bool { return r.starts[i+1] > off })
+		if err := r.load(i); err != nil {
+			return n, err
+		}
+		k := copy(p[n:], r.frame[off-r.starts[i]:])
+		n += k
+		off += int64(k)
+	}
+	return n, nil
+}
+
+// load decodes frame i into r.frame.
+func (r *SeekableReader) load(i int) error {
+	if r.cached == i {
+		return nil
+	}
+	clen := r.offsets[i+1] - r.offsets[i]
+	if clen > MaxBlockSize+MaxBlockSize/8 {
+		return fmt.Errorf("seekable: frame %d too large", i)
+	}
+	if int64(cap(r.buf)) < clen {
+		r.buf = make([]byte, clen)
+	}
+	r.buf = r.buf[:clen]
+	if _, err := r.ra.ReadAt(r.buf, r.offsets[i]); err != nil {
+		return fmt.Errorf("seekable: read frame %d: %w", i, noEOF(err))
+	}
+	out, err := r.dec.DecodeAll(r.buf, r.frame[:0])
+	if err != nil {
+		return fmt.Errorf("seekable: frame %d: %w", i, err)
+	}
+	if int64(len(out)) != r.starts[i+1]-r.starts[i] {
+		return fmt.Errorf("seekable: frame %d decoded to %d bytes, want %d", i, len(out), r.starts[i+1]-r.starts[i])
+	}
+	r.frame = out
+	r.cached = i
+	return nil
+}
+
+// Read implements io.Reader.
+func (r *SeekableReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (r *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.Size()
+	default:
+		return 0, errors.New("seekable: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("seekable: negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// Close releases the decoder.
+func (r *SeekableReader) Close() error {
+	r.dec.Close()
+	return nil
+}
@@ -0,0 +1,119 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func seekableStream(t *testing.T, data, dict []byte) ([]byte, *CompressInfo) {
+	t.Helper()
+	var out bytes.Buffer
+	w, info, err := NewCompressorWithInfo(&out, CompressorConfig{Mode: CompressAuto, SampleBytes: 64 << 10, Dictionary: dict})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes(), info
+}
+
+func TestSeekableReaderAt(t *testing.T) {
+	noise := make([]byte, 300<<10)
+	rand.New(rand.NewSource(2)).Read(noise)
+	data := append(compressible(500<<10), noise...)
+	data = append(data, compressible(123456)...)
+
+	stream, info := seekableStream(t, data, nil)
+	if info.SeekFrames != (len(data)+(64<<10)-1)/(64<<10) || info.BlocksStored == 0 {
+		t.Fatalf("frames %d, stored %d", info.SeekFrames, info.BlocksStored)
+	}
+
+	r, err := NewSeekableReader(bytes.NewReader(stream), int64(len(stream)), CodecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Size() != int64(len(data)) {
+		t.Fatalf("Size() = %d, want %d", r.Size(), len(data))
+	}
+
+	rng := rand.New(rand.NewSource(3))
+	for i := 0; i < 200; i++ {
+		off := rng.Int63n(int64(len(data)))
+		n := rng.Intn(200 << 10)
+		got := make([]byte, n)
+		k, err := r.ReadAt(got, off)
+		want := data[off:min(off+int64(n), int64(len(data)))]
+		if k != len(want) || !bytes.Equal(got[:k], want) {
+			t.Fatalf("ReadAt(%d, %d) = %d bytes, %v", off, n, k, err)
+		}
+		if k < n && err != io.EOF {
+			t.Fatalf("short read without EOF: %v", err)
+		}
+	}
+
+	// Seek + Read.
+	if _, err := r.Seek(-1000, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	tail, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(tail, data[len(data)-1000:]) {
+		t.Fatalf("tail mismatch: %v", err)
+	}
+
+	// The stream is plain zstd to a sequential decoder.
+	dr, err := NewDecompressor(bytes.NewReader(stream), CompressZstd, CodecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := io.ReadAll(dr)
+	if err != nil || !bytes.Equal(all, data) {
+		t.Fatalf("sequential decode mismatch: %v", err)
+	}
+}
+
+func TestSeekableEmptyAndDictionary(t *testing.T) {
+	stream, _ := seekableStream(t, nil, nil)
+	r, err := NewSeekableReader(bytes.NewReader(stream), int64(len(stream)), CodecOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != 0 {
+		t.Fatalf("Size() = %d", r.Size())
+	}
+
+	var samples [][]byte
+	for i := 0; i < 300; i++ {
+		samples = append(samples, compressible(400+i))
+	}
+	d, err := TrainDictionary(samples, 8<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := compressible(200 << 10)
+	stream, _ = seekableStream(t, data, d)
+	r, err = NewSeekableReader(bytes.NewReader(stream), int64(len(stream)), CodecOptions{Dictionary: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 100)
+	if _, err := r.ReadAt(got, 150<<10); err != nil || !bytes.Equal(got, data[150<<10:150<<10+100]) {
+		t.Fatalf("dictionary ReadAt mismatch: %v", err)
+	}
+}
+
+func TestSeekableRejectsBadTable(t *testing.T) {
+	stream, _ := seekableStream(t, compressible(100<<10), nil)
+	if _, err := NewSeekableReader(bytes.NewReader(stream[:len(stream)-1]), int64(len(stream)-1), CodecOptions{}); err == nil {
+		t.Fatal("accepted a truncated stream")
+	}
+	if _, err := NewSeekableReader(bytes.NewReader(stream[1:]), int64(len(stream)-1), CodecOptions{}); err == nil {
+		t.Fatal("accepted a stream with a missing prefix")
+	}
+}
@@ -6,9 +6,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	// Replace this import path with your module path, e.g. "github.com/yourorg/burrow/internal/enc"
 	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/hooks"
+	"github.com/thebluefowl/burrow/internal/settings"
+	"github.com/thebluefowl/burrow/internal/snapshot"
 )
 
 var ErrConfigNotFound = errors.New("config not found")
@@ -21,6 +26,137 @@ type Config struct {
 	MasterKey     []byte `json:"master_key"`
 	AgePublicKey  string `json:"age_public_key"`
 	AgePrivateKey string `json:"age_private_key"`
+
+	// RetiredAgePrivateKeys are identities replaced by key rotation. They are
+	// kept only until every envelope has been re-sealed to the current identity.
+	RetiredAgePrivateKeys []string `json:"retired_age_private_keys,omitempty"`
+
+	// Recipients are additional age public keys every new envelope is sealed to.
+	Recipients []string `json:"recipients,omitempty"`
+
+	// KeyMode selects how new envelopes hold their data key:
+	// envelope.KeyModeDerived (the default) or envelope.KeyModeWrapped.
+	// Uploads with extra Recipients are always wrapped.
+	KeyMode string `json:"key_mode,omitempty"`
+
+	// Privacy hides upload times and sizes from anyone who can list the
+	// bucket: object IDs are random instead of time-ordered KSUIDs and the
+	// encrypted stream is padded to a Padmé size bucket.
+	Privacy bool `json:"privacy,omitempty"`
+
+	// Bundle stores each object's envelope inside its data object, so a
+	// listing does not pair envelopes with data. Implies Privacy.
+	Bundle bool `json:"bundle,omitempty"`
+
+	// Compression is the codec new uploads are compressed with, or "auto"
+	// (the default) to use zstd only when it saves enough.
+	Compression string `json:"compression,omitempty"`
+
+	// CompressionDictionary is the path to a trained zstd dictionary used by
+	// the zstd codecs. It is copied into each envelope that uses it.
+	CompressionDictionary string `json:"compression_dictionary,omitempty"`
+
+	// Exclude lists glob patterns excluded from every upload, matched against
+	// tar paths (e.g. "*.tmp", "node_modules/"; see package glob).
+	// .burrowignore files in uploaded directories are honored as well.
+	Exclude []string `json:"exclude,omitempty"`
+
+	// ExcludeIgnoreCase matches Exclude and .burrowignore patterns
+	// case-insensitively.
+	ExcludeIgnoreCase bool `json:"exclude_ignore_case,omitempty"`
+
+	// ExcludeCaches skips the content of directories tagged with CACHEDIR.TAG.
+	ExcludeCaches bool `json:"exclude_caches,omitempty"`
+
+	// ExcludeLargerThan skips files larger than this size; zero means no limit.
+	ExcludeLargerThan settings.Size `json:"exclude_larger_than,omitempty"`
+
+	// ChangePolicy says what happens when a file changes while it is
+	// archived: "fail" (default), "warn" or "retry".
+	ChangePolicy string `json:"change_policy,omitempty"`
+
+	// Snapshot holds hooks that create and release a filesystem snapshot
+	// uploads are read from.
+	Snapshot snapshot.Config `json:"snapshot,omitzero"`
+
+	// Hooks are commands run before and after uploads and downloads.
+	Hooks hooks.Config `json:"hooks,omitzero"`
+
+	// SpecialFiles archives device nodes and named pipes instead of
+	// skipping them.
+	SpecialFiles bool `json:"special_files,omitempty"`
+
+	// Settings tune compression, encryption chunking and uploads; unset
+	// fields use the defaults. See `burrow config settings`.
+	Settings settings.Settings `json:"settings"`
+
+	// IdentityFiles are paths to extra age or OpenSSH identity files (e.g.
+	// ~/.ssh/id_ed25519) tried when opening envelopes.
+	IdentityFiles []string `json:"identity_files,omitempty"`
+
+	// SigningPublicKey and SigningPrivateKey are the Ed25519 key pair (base64)
+	// every envelope written by this machine is signed with.
+	SigningPublicKey  string `json:"signing_public_key,omitempty"`
+	SigningPrivateKey string `json:"signing_private_key,omitempty"`
+
+	// TrustedSigners are additional Ed25519 public keys whose envelope
+	// signatures are accepted, e.g. those of teammates sharing a bucket.
+	TrustedSigners []string `json:"trusted_signers,omitempty"`
+}
+
+// EnsureSigningKey generates a signing key pair if the config has none. It
+// reports whether a key was generated, so callers know to save the config.
+func (c *Config) EnsureSigningKey() (bool, error) {
+	if c.SigningPrivateKey != "" {
+		return false, nil
+	}
+	pub, priv, err := enc.GenerateSigningKey()
+	if err != nil {
+		return false, err
+	}
+	c.SigningPublicKey = pub
+	c.SigningPrivateKey = priv
+	return true, nil
+}
+
+// Signers returns the own signing public key followed by the trusted signers.
+func (c *Config) Signers() []string {
+	return MergeUnique([]string{c.SigningPublicKey}, c.TrustedSigners)
+}
+
+// WrapDataKeys reports whether new uploads should store a random data key in
+// the envelope instead of deriving it from the master key.
+func (c *Config) WrapDataKeys() bool {
+	return c.KeyMode == envelope.KeyModeWrapped || len(c.Recipients) > 0
+}
+
+// EnvelopeRecipients returns the own public key followed by the configured
+// extra recipients, without duplicates.
+func (c *Config) EnvelopeRecipients() []string {
+	return MergeUnique([]string{c.AgePublicKey}, c.Recipients)
+}
+
+// MergeUnique concatenates key lists, dropping empty and duplicate entries.
+func MergeUnique(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, list := range lists {
+		for _, r := range list {
+			r = strings.TrimSpace(r)
+			if r == "" || seen[r] {
+				continue
+			}
+			seen[r] = true
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Identities returns the age identities that may open envelopes, current first.
+func (c *Config) Identities() []string {
+	ids := append([]string{c.AgePrivateKey}, c.RetiredAgePrivateKeys...)
+	return append(ids, c.IdentityFiles...)
 }
 
 func configDirPath() (string, error) {
@@ -39,8 +175,23 @@ func configFilePath() (string, error) {
 	return filepath.Join(dir, "config.enc"), nil
 }
 
-// Save marshals and encrypts the config using age passphrase mode.
+// Save marshals and encrypts the config, keeping the KDF parameters of the
+// existing config file. A new or legacy config file gets DefaultKDF.
 func Save(cfg Config, password string) error {
+	kdf, err := InspectKDF()
+	if err != nil || kdf.Name == KDFAgeScrypt {
+		kdf = DefaultKDF()
+	}
+	return SaveWithKDF(cfg, password, kdf)
+}
+
+// SaveWithKDF marshals and encrypts the config with a key derived from
+// password using kdf.
+func SaveWithKDF(cfg Config, password string, kdf KDFParams) error {
+	if err := kdf.Validate(); err != nil {
+		return err
+	}
+
 	dir, err := configDirPath()
 	if err != nil {
 		return err
@@ -58,21 +209,48 @@ func Save(cfg Config, password string) error {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
-	ciphertext, err := enc.EncryptBytes(plain, enc.EncryptConfig{
-		Passphrase: password, // simple string password is fine (age handles salt/KDF)
-		Armor:      false,    // set true if you prefer ASCII armor
-	})
+	ciphertext, err := sealContainer(plain, password, kdf)
 	if err != nil {
 		return fmt.Errorf("failed to encrypt config: %w", err)
 	}
 
-	if err := os.WriteFile(path, ciphertext, 0o600); err != nil {
+	// Write to a temporary file first so a crash never leaves a config that
+	// no password can open.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, ciphertext, 0o600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 	return nil
 }
 
-// Load reads, decrypts, and unmarshals the config using age passphrase mode.
+// InspectKDF returns the KDF protecting the config file without decrypting it.
+func InspectKDF() (KDFParams, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return KDFParams{}, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return KDFParams{}, ErrConfigNotFound
+		}
+		return KDFParams{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if !isContainer(b) {
+		return KDFParams{Name: KDFAgeScrypt}, nil
+	}
+	c, err := parseContainer(b)
+	if err != nil {
+		return KDFParams{}, err
+	}
+	return c.KDF, nil
+}
+
+// Load reads, decrypts, and unmarshals the config. Legacy configs encrypted
+// with age passphrase mode are still readable and are converted on next Save.
 func Load(password string) (*Config, error) {
 	path, err := configFilePath()
 	if err != nil {
@@ -87,9 +265,15 @@ func Load(password string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	plain, err := enc.DecryptBytes(ciphertext, enc.DecryptConfig{
-		Passphrase: password,
-	})
+	var plain []byte
+	if isContainer(ciphertext) {
+		plain, err = openContainer(ciphertext, password)
+	} else {
+		// Legacy config, age-encrypted to the password with scrypt.
+		plain, err = enc.DecryptBytes(ciphertext, enc.DecryptConfig{
+			Passphrase: password,
+		})
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt config (wrong password?): %w", err)
 	}
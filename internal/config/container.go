@@ -0,0 +1,100 @@
+package config
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// containerFormat identifies the config file container. The container
+// records the KDF that protects it, so its cost can be inspected and raised.
+const containerFormat = "burrow.config.1"
+
+// container is the on-disk config file. The format and KDF parameters are
+// authenticated as associated data.
+type container struct {
+	Format     string    `json:"format"`
+	KDF        KDFParams `json:"kdf"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+// isContainer distinguishes container files from legacy age-encrypted ones.
+func isContainer(b []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(b), []byte("{"))
+}
+
+func (c *container) associatedData() ([]byte, error) {
+	return json.Marshal(struct {
+		Format string    `json:"format"`
+		KDF    KDFParams `json:"kdf"`
+	}{c.Format, c.KDF})
+}
+
+// sealContainer encrypts plain with a key derived from password. A fresh salt
+// replaces the one in kdf.
+func sealContainer(plain []byte, password string, kdf KDFParams) ([]byte, error) {
+	kdf, err := kdf.withFreshSalt()
+	if err != nil {
+		return nil, err
+	}
+	key, err := kdf.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c := container{Format: containerFormat, KDF: kdf, Nonce: make([]byte, aead.NonceSize())}
+	if _, err := rand.Read(c.Nonce); err != nil {
+		return nil, fmt.Errorf("config nonce: %w", err)
+	}
+	ad, err := c.associatedData()
+	if err != nil {
+		return nil, err
+	}
+	c.Ciphertext = aead.Seal(nil, c.Nonce, plain, ad)
+	return json.MarshalIndent(c, "", "  ")
+}
+
+// parseContainer decodes a container without decrypting it.
+func parseContainer(b []byte) (*container, error) {
+	var c container
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("decode config container: %w", err)
+	}
+	if c.Format != containerFormat {
+		return nil, fmt.Errorf("unsupported config format %q", c.Format)
+	}
+	return &c, nil
+}
+
+// openContainer decrypts a container with password.
+func openContainer(b []byte, password string) ([]byte, error) {
+	c, err := parseContainer(b)
+	if err != nil {
+		return nil, err
+	}
+	key, err := c.KDF.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Nonce) != aead.NonceSize() {
+		return nil, errors.New("config container: invalid nonce")
+	}
+	ad, err := c.associatedData()
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, c.Nonce, c.Ciphertext, ad)
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/thebluefowl/burrow/internal/enc"
+)
+
+// cheapKDF keeps tests fast; real configs use calibrated parameters.
+var cheapKDF = KDFParams{Name: KDFArgon2id, Time: 1, MemoryKiB: 64, Threads: 1}
+
+func setConfigDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	path, err := configFilePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSaveLoadContainer(t *testing.T) {
+	path := setConfigDir(t)
+	cfg := Config{BucketName: "bucket", MasterKey: []byte{1, 2, 3}}
+
+	if err := SaveWithKDF(cfg, "pw", cheapKDF); err != nil {
+		t.Fatalf("SaveWithKDF() error = %v", err)
+	}
+	got, err := Load("pw")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.BucketName != "bucket" || !bytes.Equal(got.MasterKey, cfg.MasterKey) {
+		t.Errorf("Load() = %+v", got)
+	}
+	if _, err := Load("wrong"); err == nil {
+		t.Error("Load() with wrong password should fail")
+	}
+
+	kdf, err := InspectKDF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kdf.Name != KDFArgon2id || kdf.MemoryKiB != cheapKDF.MemoryKiB || len(kdf.Salt) != kdfSaltSize {
+		t.Errorf("InspectKDF() = %+v", kdf)
+	}
+
+	// Save keeps the recorded parameters with a fresh salt.
+	if err := Save(cfg, "pw"); err != nil {
+		t.Fatal(err)
+	}
+	again, err := InspectKDF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Time != cheapKDF.Time || bytes.Equal(again.Salt, kdf.Salt) {
+		t.Errorf("Save() changed parameters or reused the salt: %+v", again)
+	}
+
+	// The KDF header is authenticated: lowering the cost breaks decryption.
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(raw, []byte(`"memory_kib": 64`), []byte(`"memory_kib": 32`), 1)
+	if bytes.Equal(tampered, raw) {
+		t.Fatal("test did not modify the header")
+	}
+	if err := os.WriteFile(path, tampered, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load("pw"); err == nil {
+		t.Error("Load() should fail when the KDF header was modified")
+	}
+}
+
+func TestLoadLegacyConfig(t *testing.T) {
+	path := setConfigDir(t)
+	legacy, err := enc.EncryptBytes([]byte(`{"bucket_name":"old"}`), enc.EncryptConfig{Passphrase: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, legacy, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load("pw")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.BucketName != "old" {
+		t.Errorf("BucketName = %q", cfg.BucketName)
+	}
+	if kdf, err := InspectKDF(); err != nil || kdf.Name != KDFAgeScrypt {
+		t.Errorf("InspectKDF() = %+v, %v; want legacy", kdf, err)
+	}
+}
+
+func TestKDFWeakerThan(t *testing.T) {
+	strong := KDFParams{Name: KDFArgon2id, Time: 3, MemoryKiB: 256 * 1024, Threads: 4}
+	tests := []struct {
+		name string
+		k    KDFParams
+		want bool
+	}{
+		{"less memory", KDFParams{Name: KDFArgon2id, Time: 10, MemoryKiB: 64 * 1024, Threads: 4}, true},
+		{"fewer passes", KDFParams{Name: KDFArgon2id, Time: 2, MemoryKiB: 256 * 1024, Threads: 4}, true},
+		{"equal", strong, false},
+		{"scrypt with less memory", KDFParams{Name: KDFScrypt, LogN: 15, R: 8, P: 1}, true},
+		{"scrypt with more memory and work", KDFParams{Name: KDFScrypt, LogN: 20, R: 8, P: 1}, false},
+		{"legacy", KDFParams{Name: KDFAgeScrypt}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.k.WeakerThan(strong); got != tt.want {
+			t.Errorf("%s: WeakerThan() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if strong.WeakerThan(KDFParams{Name: KDFAgeScrypt}) {
+		t.Error("Argon2id is weaker than the legacy age scrypt")
+	}
+}
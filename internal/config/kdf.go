@@ -0,0 +1,178 @@
+package config
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Key derivation functions supported by the config container.
+const (
+	KDFArgon2id = "argon2id"
+	KDFScrypt   = "scrypt"
+	// KDFAgeScrypt marks configs written before the container format, which
+	// were age-encrypted with age's fixed scrypt parameters.
+	KDFAgeScrypt = "age-scrypt"
+)
+
+const (
+	// DefaultKDFTarget is how long deriving the config key should take on the
+	// machine that first writes the config.
+	DefaultKDFTarget = 500 * time.Millisecond
+	// DefaultArgon2MemoryKiB is the Argon2id memory cost used by default (64 MiB).
+	DefaultArgon2MemoryKiB = 64 * 1024
+
+	minArgon2Time = 3
+	maxArgon2Time = 64
+	minScryptLogN = 15
+	maxScryptLogN = 24
+	kdfSaltSize   = 16
+	kdfKeySize    = 32
+)
+
+// KDFParams records how the config key is derived from the master password.
+type KDFParams struct {
+	Name string `json:"name"`
+	Salt []byte `json:"salt,omitempty"`
+
+	// Argon2id parameters.
+	Time      uint32 `json:"time,omitempty"`
+	MemoryKiB uint32 `json:"memory_kib,omitempty"`
+	Threads   uint8  `json:"threads,omitempty"`
+
+	// scrypt parameters.
+	LogN uint8 `json:"log_n,omitempty"`
+	R    int   `json:"r,omitempty"`
+	P    int   `json:"p,omitempty"`
+}
+
+// String describes the KDF and its cost, without the salt.
+func (k KDFParams) String() string {
+	switch k.Name {
+	case KDFArgon2id:
+		return fmt.Sprintf("argon2id (time=%d, memory=%d MiB, threads=%d)", k.Time, k.MemoryKiB/1024, k.Threads)
+	case KDFScrypt:
+		return fmt.Sprintf("scrypt (N=2^%d, r=%d, p=%d)", k.LogN, k.R, k.P)
+	case KDFAgeScrypt:
+		return "age scrypt (legacy, fixed parameters)"
+	default:
+		return k.Name
+	}
+}
+
+// Validate rejects unknown KDFs and parameters that are unsafe or would not
+// fit in memory.
+func (k KDFParams) Validate() error {
+	switch k.Name {
+	case KDFArgon2id:
+		if k.Time < 1 || k.Threads < 1 {
+			return errors.New("argon2id: time and threads must be at least 1")
+		}
+		if k.MemoryKiB < 8*uint32(k.Threads) || k.MemoryKiB > 4*1024*1024 {
+			return fmt.Errorf("argon2id: memory %d KiB out of range", k.MemoryKiB)
+		}
+	case KDFScrypt:
+		if k.LogN < 10 || k.LogN > 30 || k.R < 1 || k.P < 1 {
+			return fmt.Errorf("scrypt: invalid parameters N=2^%d r=%d p=%d", k.LogN, k.R, k.P)
+		}
+	default:
+		return fmt.Errorf("unknown kdf %q", k.Name)
+	}
+	return nil
+}
+
+// WeakerThan reports whether k costs less than other, in memory or in work
+// (memory times passes over it), so that switching between Argon2id and
+// scrypt is compared as well. Anything is stronger than the legacy age
+// scrypt configuration.
+func (k KDFParams) WeakerThan(other KDFParams) bool {
+	if other.Name == KDFAgeScrypt {
+		return false
+	}
+	if k.Name == KDFAgeScrypt {
+		return true
+	}
+	memory, work := k.cost()
+	otherMemory, otherWork := other.cost()
+	return memory < otherMemory || work < otherWork
+}
+
+// cost estimates the memory a derivation needs, in KiB, and its work as
+// memory times the passes made over it. scrypt fills its memory once and
+// reads it back once per p.
+func (k KDFParams) cost() (memoryKiB, work uint64) {
+	switch k.Name {
+	case KDFArgon2id:
+		return uint64(k.MemoryKiB), uint64(k.Time) * uint64(k.MemoryKiB)
+	case KDFScrypt:
+		memoryKiB = 128 * uint64(k.R) << k.LogN / 1024
+		return memoryKiB, 2 * uint64(k.P) * memoryKiB
+	}
+	return 0, 0
+}
+
+// deriveKey derives the config key from password.
+func (k KDFParams) deriveKey(password string) ([]byte, error) {
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	if len(k.Salt) < kdfSaltSize {
+		return nil, errors.New("kdf salt is too short")
+	}
+	switch k.Name {
+	case KDFArgon2id:
+		return argon2.IDKey([]byte(password), k.Salt, k.Time, k.MemoryKiB, k.Threads, kdfKeySize), nil
+	default:
+		return scrypt.Key([]byte(password), k.Salt, 1<<k.LogN, k.R, k.P, kdfKeySize)
+	}
+}
+
+// withFreshSalt returns a copy of k with a new random salt.
+func (k KDFParams) withFreshSalt() (KDFParams, error) {
+	k.Salt = make([]byte, kdfSaltSize)
+	if _, err := rand.Read(k.Salt); err != nil {
+		return k, fmt.Errorf("kdf salt: %w", err)
+	}
+	return k, nil
+}
+
+// DefaultKDF returns Argon2id parameters calibrated to DefaultKDFTarget.
+func DefaultKDF() KDFParams {
+	return CalibrateArgon2id(DefaultKDFTarget, DefaultArgon2MemoryKiB, defaultArgon2Threads())
+}
+
+// CalibrateArgon2id picks the Argon2id time cost so that one derivation with
+// the given memory and threads takes about target on this machine.
+func CalibrateArgon2id(target time.Duration, memoryKiB uint32, threads uint8) KDFParams {
+	start := time.Now()
+	argon2.IDKey([]byte("calibrate"), make([]byte, kdfSaltSize), 1, memoryKiB, threads, kdfKeySize)
+	perPass := max(time.Since(start), time.Millisecond)
+
+	t := uint32((target + perPass - 1) / perPass)
+	t = min(max(t, minArgon2Time), maxArgon2Time)
+	return KDFParams{Name: KDFArgon2id, Time: t, MemoryKiB: memoryKiB, Threads: threads}
+}
+
+// CalibrateScrypt picks the largest scrypt work factor whose derivation takes
+// at most about target on this machine.
+func CalibrateScrypt(target time.Duration) KDFParams {
+	logN := uint8(minScryptLogN)
+	start := time.Now()
+	_, _ = scrypt.Key([]byte("calibrate"), make([]byte, kdfSaltSize), 1<<logN, 8, 1, kdfKeySize)
+	elapsed := time.Since(start)
+
+	for logN < maxScryptLogN && elapsed*2 <= target {
+		logN++
+		elapsed *= 2
+	}
+	return KDFParams{Name: KDFScrypt, LogN: logN, R: 8, P: 1}
+}
+
+func defaultArgon2Threads() uint8 {
+	return uint8(min(runtime.NumCPU(), 4))
+}
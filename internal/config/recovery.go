@@ -0,0 +1,93 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/thebluefowl/burrow/internal/enc"
+)
+
+// RecoveryKeyObject is the storage key of the passphrase-protected recovery blob.
+const RecoveryKeyObject = "keys/repo.key"
+
+// RecoveryKey is the key material stored in the repository itself so that a
+// new machine can regain full access with only bucket credentials and the
+// repository passphrase. It never contains the bucket credentials.
+type RecoveryKey struct {
+	MasterKey     []byte `json:"master_key"`
+	AgePublicKey  string `json:"age_public_key"`
+	AgePrivateKey string `json:"age_private_key"`
+
+	RetiredAgePrivateKeys []string `json:"retired_age_private_keys,omitempty"`
+
+	SigningPublicKey  string   `json:"signing_public_key,omitempty"`
+	SigningPrivateKey string   `json:"signing_private_key,omitempty"`
+	TrustedSigners    []string `json:"trusted_signers,omitempty"`
+}
+
+// NewRecoveryKey extracts the recoverable key material from cfg.
+func NewRecoveryKey(cfg *Config) *RecoveryKey {
+	return &RecoveryKey{
+		MasterKey:     cfg.MasterKey,
+		AgePublicKey:  cfg.AgePublicKey,
+		AgePrivateKey: cfg.AgePrivateKey,
+
+		RetiredAgePrivateKeys: cfg.RetiredAgePrivateKeys,
+
+		SigningPublicKey:  cfg.SigningPublicKey,
+		SigningPrivateKey: cfg.SigningPrivateKey,
+		TrustedSigners:    cfg.TrustedSigners,
+	}
+}
+
+// Seal marshals the recovery key and age-encrypts it to the repository passphrase.
+func (r *RecoveryKey) Seal(passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("repository passphrase is required")
+	}
+
+	plain, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal recovery key: %w", err)
+	}
+
+	ciphertext, err := enc.EncryptBytes(plain, enc.EncryptConfig{
+		Passphrase: passphrase,
+		Armor:      true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt recovery key: %w", err)
+	}
+	return ciphertext, nil
+}
+
+// OpenRecoveryKey decrypts and unmarshals a recovery blob downloaded from the repository.
+func OpenRecoveryKey(ciphertext []byte, passphrase string) (*RecoveryKey, error) {
+	plain, err := enc.DecryptBytes(ciphertext, enc.DecryptConfig{
+		Passphrase: passphrase,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt recovery key (wrong passphrase?): %w", err)
+	}
+
+	var r RecoveryKey
+	if err := json.Unmarshal(plain, &r); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal recovery key: %w", err)
+	}
+	if len(r.MasterKey) == 0 || r.AgePrivateKey == "" {
+		return nil, errors.New("recovery key is incomplete")
+	}
+	return &r, nil
+}
+
+// Apply copies the recovered key material into cfg.
+func (r *RecoveryKey) Apply(cfg *Config) {
+	cfg.MasterKey = r.MasterKey
+	cfg.AgePublicKey = r.AgePublicKey
+	cfg.AgePrivateKey = r.AgePrivateKey
+	cfg.RetiredAgePrivateKeys = r.RetiredAgePrivateKeys
+	cfg.SigningPublicKey = r.SigningPublicKey
+	cfg.SigningPrivateKey = r.SigningPrivateKey
+	cfg.TrustedSigners = r.TrustedSigners
+}
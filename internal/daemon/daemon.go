@@ -0,0 +1,233 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/lockfile"
+	"github.com/thebluefowl/burrow/internal/storage"
+	"github.com/thebluefowl/burrow/internal/upload"
+)
+
+// ErrLocked is returned when a job is already running, in this or another
+// process.
+var ErrLocked = lockfile.ErrLocked
+
+// MetadataJob is the envelope metadata key naming the job of a backup.
+const MetadataJob = "job"
+
+// Daemon runs the jobs of a job file.
+type Daemon struct {
+	file     *File
+	config   *config.Config
+	keys     keyring.Keyring
+	storage  storage.Storage
+	stateDir string
+	log      *log.Logger
+
+	// sleep waits between retries; tests replace it.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// New creates a Daemon that keeps state and lock files in stateDir.
+func New(file *File, cfg *config.Config, keys keyring.Keyring, storageClient storage.Storage, stateDir string, logger *log.Logger) *Daemon {
+	return &Daemon{
+		file:     file,
+		config:   cfg,
+		keys:     keys,
+		storage:  storageClient,
+		stateDir: stateDir,
+		log:      logger,
+		sleep:    sleep,
+	}
+}
+
+// StateDir returns the directory holding state and lock files.
+func (d *Daemon) StateDir() string {
+	return d.stateDir
+}
+
+// Run runs each job at its scheduled times until ctx is cancelled, then
+// waits for the running jobs to stop. Runs missed while the daemon was
+// down are not made up.
+func (d *Daemon) Run(ctx context.Context) error {
+	if err := os.MkdirAll(d.stateDir, 0o700); err != nil {
+		return fmt.Errorf("state directory: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	next := make(map[*Job]time.Time)
+	now := time.Now()
+	for _, j := range d.file.Jobs {
+		next[j] = j.NextRun(now)
+		d.log.Printf("job %s: next run at %s", j.Name, next[j].Format(time.RFC3339))
+	}
+
+	for {
+		var wake time.Time
+		for _, t := range next {
+			if wake.IsZero() || t.Before(wake) {
+				wake = t
+			}
+		}
+		if err := d.sleep(ctx, time.Until(wake)); err != nil {
+			d.log.Printf("stopping; waiting for running jobs")
+			return nil
+		}
+
+		now := time.Now()
+		for _, j := range d.file.Jobs {
+			if next[j].After(now) {
+				continue
+			}
+			next[j] = j.NextRun(now)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// RunJob logs its outcome.
+				_ = d.RunJob(ctx, j)
+			}()
+		}
+	}
+}
+
+// RunJob runs a job now: it takes the job's lock, uploads with retries,
+// records the outcome in the job's state and applies its retention policy.
+// It returns ErrLocked if the job is already running.
+func (d *Daemon) RunJob(ctx context.Context, j *Job) error {
+	if err := os.MkdirAll(d.stateDir, 0o700); err != nil {
+		return fmt.Errorf("state directory: %w", err)
+	}
+	unlock, err := lockfile.Lock(statePath(d.stateDir, j.Name) + ".lock")
+	if err != nil {
+		if errors.Is(err, ErrLocked) {
+			d.log.Printf("job %s: skipped, the previous run is still going", j.Name)
+		}
+		return err
+	}
+	defer unlock()
+
+	state, err := LoadState(d.stateDir, j.Name)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	d.log.Printf("job %s: starting", j.Name)
+	backup, err := d.uploadWithRetries(ctx, j)
+	state.LastRun = start
+	if err != nil {
+		state.LastFailure = time.Now()
+		state.LastError = err.Error()
+		state.Failures++
+		d.log.Printf("job %s: failed: %v", j.Name, err)
+	} else {
+		state.LastSuccess = backup.Time
+		state.LastObjectID = backup.ObjectID
+		state.LastError = ""
+		state.Failures = 0
+		state.Backups = append(state.Backups, *backup)
+		d.log.Printf("job %s: uploaded %s in %s", j.Name, backup.ObjectID, time.Since(start).Round(time.Second))
+		d.prune(ctx, j, state)
+	}
+
+	if serr := state.save(d.stateDir, j.Name); serr != nil {
+		return errors.Join(err, serr)
+	}
+	return err
+}
+
+// uploadWithRetries uploads the job's sources, retrying failures with
+// exponential backoff.
+func (d *Daemon) uploadWithRetries(ctx context.Context, j *Job) (*Backup, error) {
+	cfg, err := d.file.Config(j, d.config)
+	if err != nil {
+		return nil, err
+	}
+
+	delay := j.retryDelay()
+	for attempt := 0; ; attempt++ {
+		backup, err := d.upload(ctx, j, cfg)
+		if err == nil || attempt >= j.retries() || ctx.Err() != nil {
+			return backup, err
+		}
+		d.log.Printf("job %s: attempt %d failed: %v; retrying in %s", j.Name, attempt+1, err, delay)
+		if err := d.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+		delay = min(2*delay, maxRetryDelay)
+	}
+}
+
+func (d *Daemon) upload(ctx context.Context, j *Job, cfg *config.Config) (*Backup, error) {
+	u := upload.NewUploader(cfg, d.keys, j.Sources, d.storage)
+	u.SetMetadata(MetadataJob, j.Name)
+	err := u.ExecuteContext(ctx)
+	for _, w := range u.Warnings() {
+		d.log.Printf("job %s: warning: %v", j.Name, w)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Backup{ObjectID: u.ObjectID(), Time: time.Now(), Keys: u.StorageKeys()}, nil
+}
+
+// prune deletes the backups the job's retention policy no longer keeps.
+// Backups that fail to delete stay in the state and are tried again after
+// the next run.
+func (d *Daemon) prune(ctx context.Context, j *Job, state *State) {
+	drop := j.Retention.expired(state.Backups, time.Now())
+	if len(drop) == 0 {
+		return
+	}
+	deleter, ok := d.storage.(storage.Deleter)
+	if !ok {
+		d.log.Printf("job %s: storage backend can't delete; retention skipped", j.Name)
+		return
+	}
+
+	deleted := make(map[string]bool)
+	for _, b := range drop {
+		var errs []error
+		for _, key := range b.Keys {
+			if err := deleter.Delete(ctx, key); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			d.log.Printf("job %s: delete expired backup %s: %v", j.Name, b.ObjectID, err)
+			continue
+		}
+		deleted[b.ObjectID] = true
+		d.log.Printf("job %s: deleted expired backup %s from %s", j.Name, b.ObjectID, b.Time.Format(time.RFC3339))
+	}
+
+	kept := state.Backups[:0]
+	for _, b := range state.Backups {
+		if !deleted[b.ObjectID] {
+			kept = append(kept, b)
+		}
+	}
+	state.Backups = kept
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,257 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/lockfile"
+	"github.com/thebluefowl/burrow/internal/storage"
+)
+
+// memStorage is an in-memory storage.Storage with Delete. Uploads fail
+// while failUploads is positive.
+type memStorage struct {
+	objects     map[string][]byte
+	failUploads int
+}
+
+func (m *memStorage) Upload(_ context.Context, key string, body io.Reader, _ string, _ map[string]string) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if m.failUploads > 0 {
+		m.failUploads--
+		return errors.New("connection reset")
+	}
+	m.objects[key] = b
+	return nil
+}
+
+func (m *memStorage) Download(_ context.Context, key string, w io.Writer) (string, map[string]string, error) {
+	b, ok := m.objects[key]
+	if !ok {
+		return "", nil, storage.ErrNotFound
+	}
+	_, err := w.Write(b)
+	return "", nil, err
+}
+
+func (m *memStorage) GetMetadata(_ context.Context, key string) (map[string]string, error) {
+	if _, ok := m.objects[key]; !ok {
+		return nil, storage.ErrNotFound
+	}
+	return nil, nil
+}
+
+func (m *memStorage) List(_ context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	var out []storage.ObjectInfo
+	for k, v := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, storage.ObjectInfo{Key: k, Size: int64(len(v))})
+		}
+	}
+	return out, nil
+}
+
+func (m *memStorage) Delete(_ context.Context, key string) error {
+	delete(m.objects, key)
+	return nil
+}
+
+func newTestDaemon(t *testing.T, f *File, s storage.Storage) (*Daemon, *config.Config) {
+	t.Helper()
+	if err := f.validate(); err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := enc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := make([]byte, 64)
+	rand.Read(master)
+	cfg := &config.Config{AgePublicKey: pub, AgePrivateKey: priv, MasterKey: master}
+	if _, err := cfg.EnsureSigningKey(); err != nil {
+		t.Fatal(err)
+	}
+	d := New(f, cfg, keyring.NewLocal(cfg), s, t.TempDir(), log.New(io.Discard, "", 0))
+	d.sleep = func(ctx context.Context, _ time.Duration) error { return ctx.Err() }
+	return d, cfg
+}
+
+func TestRunJob(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0o644)
+	os.WriteFile(filepath.Join(src, "b.tmp"), []byte("scratch"), 0o644)
+
+	f := &File{
+		Profiles: map[string]Profile{"p": {Exclude: []string{"*.tmp"}}},
+		Jobs: []*Job{{
+			Name:      "docs",
+			Sources:   []string{src},
+			Profile:   "p",
+			Schedule:  "@daily",
+			Retention: Retention{KeepLast: 2},
+		}},
+	}
+	s := &memStorage{objects: map[string][]byte{}}
+	d, cfg := newTestDaemon(t, f, s)
+	ctx := context.Background()
+	job := f.Jobs[0]
+
+	var ids []string
+	for range 3 {
+		if err := d.RunJob(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+		st, err := LoadState(d.StateDir(), job.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, st.LastObjectID)
+	}
+
+	st, _ := LoadState(d.StateDir(), job.Name)
+	if len(st.Backups) != 2 || st.Backups[0].ObjectID != ids[1] || st.Backups[1].ObjectID != ids[2] {
+		t.Fatalf("backups = %+v, want the last two of %v", st.Backups, ids)
+	}
+	if st.LastSuccess.IsZero() || st.LastError != "" || st.Failures != 0 {
+		t.Errorf("state = %+v", st)
+	}
+	if _, ok := s.objects[envelope.Key(ids[0])]; ok {
+		t.Error("expired backup's envelope was not deleted")
+	}
+	if _, ok := s.objects["data/"+ids[0]+".enc"]; ok {
+		t.Error("expired backup's data was not deleted")
+	}
+
+	keys := keyring.NewLocal(cfg)
+	env, err := envelope.Fetch(ctx, s, ids[2], keys.DecryptConfig(), envelope.Trust{Signers: cfg.Signers()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Metadata[MetadataJob] != "docs" {
+		t.Errorf("metadata = %v, want job=docs", env.Metadata)
+	}
+}
+
+func TestRunJobRetries(t *testing.T) {
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0o644)
+
+	job := &Job{Name: "flaky", Sources: []string{src}, Schedule: "@hourly", Retries: 2, RetryDelay: Duration(time.Second)}
+	f := &File{Jobs: []*Job{job}}
+	s := &memStorage{objects: map[string][]byte{}, failUploads: 2}
+	d, _ := newTestDaemon(t, f, s)
+	var delays []time.Duration
+	d.sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	if err := d.RunJob(context.Background(), job); err != nil {
+		t.Fatalf("RunJob() error = %v", err)
+	}
+	if len(delays) != 2 || delays[0] != time.Second || delays[1] != 2*time.Second {
+		t.Errorf("delays = %v, want [1s 2s]", delays)
+	}
+
+	s.failUploads = 3
+	if err := d.RunJob(context.Background(), job); err == nil {
+		t.Fatal("RunJob() should fail once retries are used up")
+	}
+	st, _ := LoadState(d.StateDir(), job.Name)
+	if st.Failures != 1 || st.LastError == "" || st.LastSuccess.IsZero() || len(st.Backups) != 1 {
+		t.Errorf("state = %+v", st)
+	}
+}
+
+func TestRunJobLocked(t *testing.T) {
+	job := &Job{Name: "busy", Sources: []string{t.TempDir()}, Schedule: "@hourly"}
+	d, _ := newTestDaemon(t, &File{Jobs: []*Job{job}}, &memStorage{objects: map[string][]byte{}})
+
+	unlock, err := lockfile.Lock(statePath(d.StateDir(), job.Name) + ".lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	if err := d.RunJob(context.Background(), job); !errors.Is(err, ErrLocked) {
+		t.Fatalf("RunJob() error = %v, want ErrLocked", err)
+	}
+}
+
+func TestRetentionExpired(t *testing.T) {
+	now := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+	var backups []Backup
+	for i := range 5 {
+		backups = append(backups, Backup{ObjectID: string(rune('a' + i)), Time: now.AddDate(0, 0, i-4)})
+	}
+	ids := func(bs []Backup) string {
+		var b bytes.Buffer
+		for _, x := range bs {
+			b.WriteString(x.ObjectID)
+		}
+		return b.String()
+	}
+
+	tests := []struct {
+		r    Retention
+		want string
+	}{
+		{Retention{}, ""},
+		{Retention{KeepLast: 2}, "abc"},
+		{Retention{KeepWithin: Duration(36 * time.Hour)}, "abc"},
+		{Retention{KeepLast: 3, KeepWithin: Duration(36 * time.Hour)}, "ab"},
+		{Retention{KeepWithin: Duration(time.Hour)}, "abcd"},
+	}
+	for _, tt := range tests {
+		if got := ids(tt.r.expired(backups, now)); got != tt.want {
+			t.Errorf("%+v.expired() = %q, want %q", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(s string) string {
+		p := filepath.Join(dir, "jobs.json")
+		os.WriteFile(p, []byte(s), 0o600)
+		return p
+	}
+
+	f, err := Load(write(`{"jobs": [{"name": "etc", "sources": ["/etc"], "schedule": "30 2 * * *", "retention": {"keep_within": "30d"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Job("etc").Retention.KeepWithin; time.Duration(got) != 30*24*time.Hour {
+		t.Errorf("keep_within = %v", time.Duration(got))
+	}
+
+	for _, bad := range []string{
+		`{"jobs": []}`,
+		`{"jobs": [{"name": "a", "sources": ["/"], "schedule": "daily"}]}`,
+		`{"jobs": [{"name": "a", "sources": ["/"], "schedule": "0 0 31 feb *"}]}`,
+		`{"jobs": [{"name": "a", "schedule": "@daily"}]}`,
+		`{"jobs": [{"name": "../a", "sources": ["/"], "schedule": "@daily"}]}`,
+		`{"jobs": [{"name": "a", "sources": ["/"], "schedule": "@daily", "profile": "nope"}]}`,
+		`{"jobs": [{"name": "a", "sources": ["/"], "schedule": "@daily"}, {"name": "a", "sources": ["/"], "schedule": "@daily"}]}`,
+		`{"profiles": {"p": {"compression": "lz9"}}, "jobs": [{"name": "a", "sources": ["/"], "schedule": "@daily"}]}`,
+	} {
+		if _, err := Load(write(bad)); err == nil {
+			t.Errorf("Load(%s) should fail", bad)
+		}
+	}
+}
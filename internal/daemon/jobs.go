@@ -0,0 +1,307 @@
+// Package daemon runs scheduled backup jobs. A job file lists jobs, each
+// with sources, a cron schedule (see Schedule) and a retention policy, and
+// named profiles holding upload options that jobs share. Runs of a job are
+// serialized by a lock file, retried with exponential backoff, and recorded
+// in a per-job state file next to the lock.
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thebluefowl/burrow/internal/archive"
+	"github.com/thebluefowl/burrow/internal/compress"
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/hooks"
+	"github.com/thebluefowl/burrow/internal/settings"
+	"github.com/thebluefowl/burrow/internal/snapshot"
+)
+
+const (
+	defaultRetries    = 3
+	defaultRetryDelay = time.Minute
+	maxRetryDelay     = time.Hour
+)
+
+// File is a job file, stored as JSON:
+//
+//	{
+//	  "state_dir": "/var/lib/burrow",
+//	  "profiles": {
+//	    "servers": {"compression": "zstd", "exclude": ["*.tmp"], "exclude_caches": true}
+//	  },
+//	  "jobs": [{
+//	    "name": "etc",
+//	    "sources": ["/etc"],
+//	    "profile": "servers",
+//	    "schedule": "30 2 * * *",
+//	    "retention": {"keep_last": 7, "keep_within": "30d"},
+//	    "hooks": {"post_upload": "notify-send \"backup $BURROW_STATUS\""}
+//	  }]
+//	}
+type File struct {
+	// StateDir holds the state and lock files; empty means the default
+	// directory of the daemon command, and --state-dir overrides it.
+	StateDir string             `json:"state_dir,omitempty"`
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+	Jobs     []*Job             `json:"jobs"`
+}
+
+// Profile is a named set of upload options. Unset fields keep the values of
+// the burrow config.
+type Profile struct {
+	KeyMode           string            `json:"key_mode,omitempty"`
+	Private           bool              `json:"private,omitempty"`
+	Bundle            bool              `json:"bundle,omitempty"`
+	Compression       string            `json:"compression,omitempty"`
+	Dictionary        string            `json:"dictionary,omitempty"`
+	Exclude           []string          `json:"exclude,omitempty"`
+	ExcludeIgnoreCase bool              `json:"exclude_ignore_case,omitempty"`
+	ExcludeCaches     bool              `json:"exclude_caches,omitempty"`
+	ExcludeLargerThan settings.Size     `json:"exclude_larger_than,omitempty"`
+	SpecialFiles      bool              `json:"special_files,omitempty"`
+	ChangePolicy      string            `json:"change_policy,omitempty"`
+	Snapshot          snapshot.Config   `json:"snapshot,omitzero"`
+	Hooks             hooks.Config      `json:"hooks,omitzero"`
+	Settings          settings.Settings `json:"settings,omitzero"`
+}
+
+// Job is one scheduled backup.
+type Job struct {
+	// Name identifies the job in logs, state files and the "job" metadata
+	// of its envelopes.
+	Name    string   `json:"name"`
+	Sources []string `json:"sources"`
+	// Exclude is added to the profile's patterns.
+	Exclude   []string  `json:"exclude,omitempty"`
+	Profile   string    `json:"profile,omitempty"`
+	Schedule  string    `json:"schedule"`
+	Retention Retention `json:"retention,omitzero"`
+	// Hooks replace the profile's hooks of the same kind.
+	Hooks hooks.Config `json:"hooks,omitzero"`
+	// Retries is how often a failed run is retried; zero means 3 and a
+	// negative value disables retries.
+	Retries int `json:"retries,omitempty"`
+	// RetryDelay is the wait before the first retry, doubled for each
+	// further one (up to an hour); zero means a minute.
+	RetryDelay Duration `json:"retry_delay,omitempty"`
+
+	schedule *Schedule
+}
+
+// Retention decides which backups of a job are kept after a successful run.
+// A backup is kept if it is one of the KeepLast newest or younger than
+// KeepWithin; the newest backup is always kept. With neither set, every
+// backup is kept.
+type Retention struct {
+	KeepLast   int      `json:"keep_last,omitempty"`
+	KeepWithin Duration `json:"keep_within,omitempty"`
+}
+
+// Duration is a time.Duration that reads and writes as a string such as
+// "90m" or "36h", with "d" accepted for days ("30d").
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	s := string(b)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		*d = Duration(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if v < 0 {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+var jobNameRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Load reads and validates a job file.
+func Load(path string) (*File, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read job file: %w", err)
+	}
+	var f File
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parse job file %s: %w", path, err)
+	}
+	if err := f.validate(); err != nil {
+		return nil, fmt.Errorf("job file %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+func (f *File) validate() error {
+	if len(f.Jobs) == 0 {
+		return errors.New("no jobs")
+	}
+	for name, p := range f.Profiles {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("profile %q: %w", name, err)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, j := range f.Jobs {
+		if !jobNameRE.MatchString(j.Name) {
+			return fmt.Errorf("invalid job name %q (letters, digits, '.', '_' and '-')", j.Name)
+		}
+		if seen[j.Name] {
+			return fmt.Errorf("duplicate job %q", j.Name)
+		}
+		seen[j.Name] = true
+
+		if len(j.Sources) == 0 {
+			return fmt.Errorf("job %q: no sources", j.Name)
+		}
+		if _, ok := f.Profiles[j.Profile]; j.Profile != "" && !ok {
+			return fmt.Errorf("job %q: unknown profile %q", j.Name, j.Profile)
+		}
+		if err := archive.ValidateOptions(archive.Options{Exclude: j.Exclude}); err != nil {
+			return fmt.Errorf("job %q: %w", j.Name, err)
+		}
+		if j.Retention.KeepLast < 0 {
+			return fmt.Errorf("job %q: keep_last must not be negative", j.Name)
+		}
+		s, err := ParseSchedule(j.Schedule)
+		if err != nil {
+			return fmt.Errorf("job %q: %w", j.Name, err)
+		}
+		if s.Next(time.Now()).IsZero() {
+			return fmt.Errorf("job %q: schedule %q never runs", j.Name, j.Schedule)
+		}
+		j.schedule = s
+	}
+	return nil
+}
+
+func (p Profile) validate() error {
+	switch p.KeyMode {
+	case "", envelope.KeyModeDerived, envelope.KeyModeWrapped:
+	default:
+		return fmt.Errorf("invalid key mode %q", p.KeyMode)
+	}
+	if p.Compression != "" {
+		mode, err := compress.ParseMode(p.Compression)
+		if err != nil {
+			return err
+		}
+		if p.Dictionary != "" && !compress.SupportsDictionary(mode) {
+			return fmt.Errorf("codec %q does not support dictionaries", mode)
+		}
+	}
+	if _, err := archive.ParseChangePolicy(p.ChangePolicy); err != nil {
+		return err
+	}
+	if err := archive.ValidateOptions(archive.Options{Exclude: p.Exclude, IgnoreCase: p.ExcludeIgnoreCase}); err != nil {
+		return err
+	}
+	return p.Settings.Validate()
+}
+
+// Job returns the job called name, or nil.
+func (f *File) Job(name string) *Job {
+	for _, j := range f.Jobs {
+		if j.Name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+// NextRun returns when the job runs next after t.
+func (j *Job) NextRun(t time.Time) time.Time {
+	return j.schedule.Next(t)
+}
+
+// Config returns base with the job's profile and excludes applied. base is
+// not modified.
+func (f *File) Config(j *Job, base *config.Config) (*config.Config, error) {
+	cfg := *base
+	cfg.Exclude = slices.Clone(base.Exclude)
+
+	p := f.Profiles[j.Profile]
+	if p.KeyMode != "" {
+		cfg.KeyMode = p.KeyMode
+	}
+	if p.Private {
+		cfg.Privacy = true
+	}
+	if p.Bundle {
+		cfg.Bundle = true
+	}
+	if p.Compression != "" {
+		cfg.Compression = p.Compression
+	}
+	if p.Dictionary != "" {
+		cfg.CompressionDictionary = p.Dictionary
+	}
+	cfg.Exclude = append(cfg.Exclude, p.Exclude...)
+	cfg.Exclude = append(cfg.Exclude, j.Exclude...)
+	if p.ExcludeIgnoreCase {
+		cfg.ExcludeIgnoreCase = true
+	}
+	if p.ExcludeCaches {
+		cfg.ExcludeCaches = true
+	}
+	if p.ExcludeLargerThan > 0 {
+		cfg.ExcludeLargerThan = p.ExcludeLargerThan
+	}
+	if p.SpecialFiles {
+		cfg.SpecialFiles = true
+	}
+	if p.ChangePolicy != "" {
+		cfg.ChangePolicy = p.ChangePolicy
+	}
+	if p.Snapshot.Enabled() {
+		cfg.Snapshot = p.Snapshot
+	}
+	cfg.Hooks = base.Hooks.Merge(p.Hooks).Merge(j.Hooks)
+	cfg.Settings = base.Settings.Merge(p.Settings)
+
+	if err := cfg.Settings.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid settings: %w", err)
+	}
+	if err := archive.ValidateOptions(archive.Options{Exclude: cfg.Exclude, IgnoreCase: cfg.ExcludeIgnoreCase}); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (j *Job) retries() int {
+	switch {
+	case j.Retries < 0:
+		return 0
+	case j.Retries == 0:
+		return defaultRetries
+	}
+	return j.Retries
+}
+
+func (j *Job) retryDelay() time.Duration {
+	if j.RetryDelay <= 0 {
+		return defaultRetryDelay
+	}
+	return time.Duration(j.RetryDelay)
+}
@@ -0,0 +1,50 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// 2026-03-14 is a Saturday.
+	from := time.Date(2026, 3, 14, 10, 30, 45, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"* * * * *", "2026-03-14 10:31"},
+		{"0 3 * * *", "2026-03-15 03:00"},
+		{"@daily", "2026-03-15 00:00"},
+		{"@hourly", "2026-03-14 11:00"},
+		{"*/15 * * * *", "2026-03-14 10:45"},
+		{"5,35 9-17 * * *", "2026-03-14 10:35"},
+		{"0 9-17/4 * * *", "2026-03-14 13:00"},
+		{"30 10 * * *", "2026-03-15 10:30"},
+		{"0 0 1 * *", "2026-04-01 00:00"},
+		{"0 0 * * mon-fri", "2026-03-16 00:00"},
+		{"0 0 * * 7", "2026-03-15 00:00"},
+		{"0 0 1 jan *", "2027-01-01 00:00"},
+		{"0 0 29 feb *", "2028-02-29 00:00"},
+		// Restricted day of month and weekday match either one.
+		{"0 0 20 * sun", "2026-03-15 00:00"},
+		{"0 0 13 * 2/3", "2026-03-17 00:00"},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q) error = %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(from).Format("2006-01-02 15:04"); got != tt.want {
+			t.Errorf("%q.Next() = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "x * * * *", "@often"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) should fail", spec)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State records the runs of a job. It is stored as <state-dir>/<job>.json.
+type State struct {
+	LastRun      time.Time `json:"last_run,omitzero"`
+	LastSuccess  time.Time `json:"last_success,omitzero"`
+	LastObjectID string    `json:"last_object_id,omitempty"`
+	LastFailure  time.Time `json:"last_failure,omitzero"`
+	LastError    string    `json:"last_error,omitempty"`
+	// Failures counts the failed runs since the last success.
+	Failures int `json:"failures,omitempty"`
+	// Backups are the job's stored backups, oldest first, for retention.
+	Backups []Backup `json:"backups,omitempty"`
+}
+
+// Backup is an object uploaded by a job.
+type Backup struct {
+	ObjectID string    `json:"object_id"`
+	Time     time.Time `json:"time"`
+	// Keys are the storage keys of the object's data and envelope.
+	Keys []string `json:"keys"`
+}
+
+func statePath(dir, job string) string {
+	return filepath.Join(dir, job+".json")
+}
+
+// LoadState reads the state of a job; a job that never ran has a zero
+// State.
+func LoadState(dir, job string) (*State, error) {
+	var s State
+	b, err := os.ReadFile(statePath(dir, job))
+	if errors.Is(err, os.ErrNotExist) {
+		return &s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("parse state of job %q: %w", job, err)
+	}
+	return &s, nil
+}
+
+// save writes the state through a temporary file, so a crash leaves the
+// previous state intact.
+func (s *State) save(dir, job string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := statePath(dir, job)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	return nil
+}
+
+// expired returns the backups r doesn't keep at now.
+func (r Retention) expired(backups []Backup, now time.Time) []Backup {
+	if r.KeepLast == 0 && r.KeepWithin == 0 {
+		return nil
+	}
+	keepLast := max(r.KeepLast, 1)
+	var drop []Backup
+	for i, b := range backups {
+		newest := len(backups) - i
+		if newest <= keepLast || (r.KeepWithin > 0 && now.Sub(b.Time) < time.Duration(r.KeepWithin)) {
+			continue
+		}
+		drop = append(drop, b)
+	}
+	return drop
+}
@@ -1,44 +1,125 @@
 package download
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"time"
 
+	"github.com/thebluefowl/burrow/internal/archive"
 	"github.com/thebluefowl/burrow/internal/config"
-	"github.com/thebluefowl/burrow/internal/enc"
 	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/hooks"
+	"github.com/thebluefowl/burrow/internal/keyring"
 	"github.com/thebluefowl/burrow/internal/storage"
 )
 
 // Downloader handles the complete download workflow
 type Downloader struct {
 	config   *config.Config
+	keys     keyring.Keyring
 	objectID string
 	destPath string
+	output   io.Writer
 
-	envelope  *envelope.Envelope
-	storage   storage.Storage
-	unarchive bool
+	envelope      *envelope.Envelope
+	bundleData    []byte
+	storage       storage.Storage
+	unarchive     bool
+	allowUnsigned bool
+
+	result   *DecryptionPipelineResult
+	warnings []error
 }
 
 // NewDownloader creates a new Downloader instance
-func NewDownloader(cfg *config.Config, objectID string, destPath string, unarchive bool, storageClient storage.Storage) *Downloader {
+func NewDownloader(cfg *config.Config, keys keyring.Keyring, objectID string, destPath string, unarchive bool, allowUnsigned bool, storageClient storage.Storage) *Downloader {
+	return &Downloader{
+		config:        cfg,
+		keys:          keys,
+		objectID:      objectID,
+		destPath:      destPath,
+		unarchive:     unarchive,
+		allowUnsigned: allowUnsigned,
+		storage:       storageClient,
+	}
+}
+
+// NewStreamDownloader creates a Downloader that writes the decrypted object,
+// as stored, to w instead of a file. Archives are written as tar.
+func NewStreamDownloader(cfg *config.Config, keys keyring.Keyring, objectID string, w io.Writer, allowUnsigned bool, storageClient storage.Storage) *Downloader {
 	return &Downloader{
-		config:    cfg,
-		objectID:  objectID,
-		destPath:  destPath,
-		unarchive: unarchive,
-		storage:   storageClient,
+		config:        cfg,
+		keys:          keys,
+		objectID:      objectID,
+		output:        w,
+		allowUnsigned: allowUnsigned,
+		storage:       storageClient,
 	}
 }
 
-// Execute runs the complete download process
+// Execute runs the complete download process, between the pre and post
+// download hooks
 func (d *Downloader) Execute() error {
+	event := hooks.NewEvent(hooks.Download)
+	event.ObjectID = d.objectID
+	event.Destination = d.destPath
+	if d.output != nil {
+		event.Destination = "-"
+	}
+	warn := func(err error) { d.warnings = append(d.warnings, err) }
+
+	return runHooks(context.Background(), d.config, event, warn, func() error {
+		err := d.execute()
+		if d.envelope != nil {
+			event.Compression = d.envelope.Compression.Mode
+		}
+		if r := d.result; r != nil {
+			event.BytesUncompressed = r.BytesUncompressed
+			event.BytesCompressed = r.BytesCompressed
+		}
+		return err
+	})
+}
+
+// runHooks runs fn between the pre and post download hooks of cfg. fn may
+// fill in event's statistics for the post hook. The download's outcome
+// stands even if the post hook fails, so its error goes to warn.
+func runHooks(ctx context.Context, cfg *config.Config, event *hooks.Event, warn func(error), fn func() error) (err error) {
+	if err := cfg.Hooks.RunPre(ctx, event); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	defer func() {
+		event.Err = err
+		event.Duration = time.Since(start)
+		if herr := cfg.Hooks.RunPost(ctx, event); herr != nil {
+			warn(herr)
+		}
+	}()
+
+	return fn()
+}
+
+// Warnings returns the problems that didn't stop the download, such as a
+// failed post-download hook.
+func (d *Downloader) Warnings() []error {
+	return d.warnings
+}
+
+func (d *Downloader) execute() error {
 	if err := d.fetchEnvelope(); err != nil {
 		return err
 	}
 
+	if inc := d.envelope.Incremental; inc != nil {
+		if d.unarchive && d.output == nil {
+			return d.restoreChain()
+		}
+		d.warnings = append(d.warnings, fmt.Errorf("%s holds only the changes since %s; download it with --extract to restore the whole directory", d.objectID, inc.Parent))
+	}
+
 	if err := d.downloadAndDecrypt(); err != nil {
 		return err
 	}
@@ -46,45 +127,107 @@ func (d *Downloader) Execute() error {
 	return nil
 }
 
-// fetchEnvelope downloads and decrypts the envelope
-func (d *Downloader) fetchEnvelope() error {
-	ctx := context.Background()
-	envelopeKey := "keys/" + d.objectID + ".envelope"
+// chainLink is one object of an incremental chain.
+type chainLink struct {
+	objectID string
+	envelope *envelope.Envelope
+	data     []byte
+}
 
-	// Download envelope from storage
-	var buf bytes.Buffer
-	_, _, err := d.storage.Download(ctx, envelopeKey, &buf)
-	if err != nil {
-		return fmt.Errorf("download envelope %s: %w", envelopeKey, err)
+// restoreChain extracts an incremental object by walking its parents back
+// to the full upload and extracting them oldest first, removing the paths
+// each increment deleted before extracting it.
+func (d *Downloader) restoreChain() error {
+	chain := []chainLink{{d.objectID, d.envelope, d.bundleData}}
+	seen := map[string]bool{d.objectID: true}
+	for inc := d.envelope.Incremental; inc != nil; {
+		if seen[inc.Parent] {
+			return fmt.Errorf("incremental chain of %s loops at %s", d.objectID, inc.Parent)
+		}
+		seen[inc.Parent] = true
+		env, data, err := d.fetch(inc.Parent)
+		if err != nil {
+			return fmt.Errorf("parent %s: %w", inc.Parent, err)
+		}
+		chain = append(chain, chainLink{inc.Parent, env, data})
+		inc = env.Incremental
 	}
 
-	// Decrypt and unmarshal envelope using age private key
-	decCfg := enc.DecryptConfig{
-		Identities: []string{d.config.AgePrivateKey},
+	result := &DecryptionPipelineResult{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		link := chain[i]
+		if inc := link.envelope.Incremental; inc != nil {
+			if err := archive.RemoveEntries(d.destPath, inc.Deleted); err != nil {
+				return fmt.Errorf("apply deletions of %s: %w", link.objectID, err)
+			}
+		}
+		r, err := DecryptionPipeline(&DecryptionPipelineOpts{
+			ObjectID:  link.objectID,
+			Envelope:  link.envelope,
+			Config:    d.config,
+			Keys:      d.keys,
+			Storage:   d.storage,
+			DestPath:  d.destPath,
+			Unarchive: true,
+			Data:      link.data,
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", link.objectID, err)
+		}
+		result.BytesCompressed += r.BytesCompressed
+		result.BytesUncompressed += r.BytesUncompressed
 	}
+	d.result = result
+	return nil
+}
 
-	var env envelope.Envelope
-	decryptedEnv, err := env.Open(buf.Bytes(), decCfg)
+// fetchEnvelope downloads and decrypts the envelope and checks that it was
+// signed by a trusted key
+func (d *Downloader) fetchEnvelope() error {
+	env, bundleData, err := d.fetch(d.objectID)
 	if err != nil {
-		return fmt.Errorf("open envelope: %w", err)
+		return err
 	}
 
-	d.envelope = decryptedEnv
+	d.envelope = env
+	d.bundleData = bundleData
 	return nil
 }
 
+// fetch downloads and opens the envelope of objectID, with the data of a
+// bundled object
+func (d *Downloader) fetch(objectID string) (*envelope.Envelope, []byte, error) {
+	// Decrypt and unmarshal envelope using age private key
+	decCfg := d.keys.DecryptConfig()
+
+	trust := envelope.Trust{
+		Signers:       d.config.Signers(),
+		AllowUnsigned: d.allowUnsigned,
+	}
+
+	return envelope.FetchObject(context.Background(), d.storage, objectID, decCfg, trust)
+}
+
 // downloadAndDecrypt performs the decryption pipeline and downloads from storage
 func (d *Downloader) downloadAndDecrypt() error {
 	opts := &DecryptionPipelineOpts{
 		ObjectID:  d.objectID,
 		Envelope:  d.envelope,
 		Config:    d.config,
+		Keys:      d.keys,
 		Storage:   d.storage,
 		DestPath:  d.destPath,
+		Output:    d.output,
 		Unarchive: d.unarchive,
+		Data:      d.bundleData,
 	}
 
-	return DecryptionPipeline(opts)
+	result, err := DecryptionPipeline(opts)
+	if err != nil {
+		return err
+	}
+	d.result = result
+	return nil
 }
 
 // DecryptionPipelineOpts contains options for the decryption pipeline
@@ -92,18 +235,41 @@ type DecryptionPipelineOpts struct {
 	ObjectID  string
 	Envelope  *envelope.Envelope
 	Config    *config.Config
+	Keys      keyring.Keyring
 	Storage   storage.Storage
 	DestPath  string
 	Unarchive bool
+
+	// Output, if set, receives the decrypted object instead of a file under
+	// DestPath.
+	Output io.Writer
+
+	// Data is the encrypted data of a bundled object, already downloaded
+	// together with its envelope.
+	Data []byte
+}
+
+// DecryptionPipelineResult contains the results of the decryption pipeline
+type DecryptionPipelineResult struct {
+	// BytesCompressed and BytesUncompressed count the data before and after
+	// decompression.
+	BytesCompressed   int64
+	BytesUncompressed int64
 }
 
 // DecryptionPipeline executes the complete decryption pipeline
-func DecryptionPipeline(opts *DecryptionPipelineOpts) error {
+func DecryptionPipeline(opts *DecryptionPipelineOpts) (*DecryptionPipelineResult, error) {
 	ctx := context.Background()
 
 	dp := &decryptionPipeline{
 		opts: opts,
 	}
 
-	return dp.execute(ctx)
+	if err := dp.execute(ctx); err != nil {
+		return nil, err
+	}
+	return &DecryptionPipelineResult{
+		BytesCompressed:   dp.bytesCompressed,
+		BytesUncompressed: dp.bytesUncompressed,
+	}, nil
 }
@@ -0,0 +1,275 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/glob"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/upload"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 100000)
+
+	for _, bundle := range []bool{false, true} {
+		cfg := newTestConfig(t)
+		cfg.Bundle = bundle
+		s := &memStorage{objects: map[string][]byte{}}
+		keys := keyring.NewLocal(cfg)
+
+		u := upload.NewStreamUploader(cfg, keys, bytes.NewReader(data), "db.sql", s)
+		if err := u.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		id := u.ObjectID()
+
+		env, _, err := envelope.FetchObject(context.Background(), s, id, keys.DecryptConfig(), envelope.Trust{Signers: cfg.Signers()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.Kind != envelope.KindStream || env.OriginalFileName != "db.sql" {
+			t.Errorf("envelope kind = %q, name = %q", env.Kind, env.OriginalFileName)
+		}
+
+		var out bytes.Buffer
+		if err := NewStreamDownloader(cfg, keys, id, &out, false, s).Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("stream download returned %d bytes, want %d", out.Len(), len(data))
+		}
+
+		// Downloaded to a directory, the stream is restored under its name.
+		dir := t.TempDir()
+		if err := NewDownloader(cfg, keys, id, dir, false, false, s).Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(filepath.Join(dir, "db.sql")); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("file download mismatch: %v", err)
+		}
+
+		err = NewDownloader(cfg, keys, id, t.TempDir(), true, false, s).Execute()
+		if !errors.Is(err, ErrNotArchive) {
+			t.Errorf("extract error = %v, want ErrNotArchive", err)
+		}
+		err = NewBrowser(cfg, keys, id, false, s).List(context.Background(), nil)
+		if !errors.Is(err, ErrNotArchive) {
+			t.Errorf("List error = %v, want ErrNotArchive", err)
+		}
+	}
+}
+
+func TestDownloadRestoresKind(t *testing.T) {
+	cfg := newTestConfig(t)
+	s := &memStorage{objects: map[string][]byte{}}
+	keys := keyring.NewLocal(cfg)
+
+	src := t.TempDir()
+	pdf := bytes.Repeat([]byte("%PDF-1.7 "), 5000)
+	file := filepath.Join(src, "report.pdf")
+	if err := os.WriteFile(file, pdf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(src, "docs")
+	os.MkdirAll(dir, 0o755)
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(path string) string {
+		u := upload.NewUploader(cfg, keys, []string{path}, s)
+		if err := u.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		return u.ObjectID()
+	}
+	fileID, dirID := put(file), put(dir)
+
+	dest := t.TempDir()
+	exact := filepath.Join(dest, "restored.pdf")
+	for _, d := range []string{dest, exact} {
+		if err := NewDownloader(cfg, keys, fileID, d, false, false, s).Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{filepath.Join(dest, "report.pdf"), exact} {
+		if got, err := os.ReadFile(p); err != nil || !bytes.Equal(got, pdf) {
+			t.Errorf("%s: single file not restored: %v", p, err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := NewStreamDownloader(cfg, keys, fileID, &out, false, s).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), pdf) {
+		t.Error("stream download of a single file should write its content")
+	}
+
+	exactTar := filepath.Join(dest, "backup")
+	for _, d := range []string{dest, exactTar} {
+		if err := NewDownloader(cfg, keys, dirID, d, false, false, s).Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{filepath.Join(dest, "docs.tar"), exactTar} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("directory archive not written: %v", err)
+		}
+	}
+
+	extracted := t.TempDir()
+	if err := NewDownloader(cfg, keys, fileID, extracted, true, false, s).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(extracted, "report.pdf")); err != nil || !bytes.Equal(got, pdf) {
+		t.Errorf("extracted single file mismatch: %v", err)
+	}
+}
+
+func TestUploadFromSnapshot(t *testing.T) {
+	cfg := newTestConfig(t)
+	s := &memStorage{objects: map[string][]byte{}}
+	keys := keyring.NewLocal(cfg)
+
+	dir := t.TempDir()
+	live := filepath.Join(dir, "live")
+	frozen := filepath.Join(dir, "frozen")
+	os.MkdirAll(filepath.Join(live, "docs"), 0o755)
+	if err := os.WriteFile(filepath.Join(live, "docs", "a.txt"), []byte("live"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The "snapshot" is a copy whose content differs from the live file.
+	cfg.Snapshot.Pre = `cp -r "$BURROW_SNAPSHOT_OF" ` + frozen + ` && echo frozen > ` + frozen + `/docs/a.txt && echo ` + frozen
+	cfg.Snapshot.Post = `rm -r "$BURROW_SNAPSHOT"`
+	cfg.Snapshot.Of = live
+
+	src := filepath.Join(live, "docs")
+	u := upload.NewUploader(cfg, keys, []string{src}, s)
+	if err := u.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(frozen); !os.IsNotExist(err) {
+		t.Error("snapshot was not released")
+	}
+
+	env, _, err := envelope.FetchObject(context.Background(), s, u.ObjectID(), keys.DecryptConfig(), envelope.Trust{Signers: cfg.Signers()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap := env.Snapshot; snap == nil || snap.Of != live || snap.Mount != frozen || len(snap.Sources) != 1 || snap.Sources[0] != src {
+		t.Errorf("envelope snapshot = %+v", env.Snapshot)
+	}
+
+	dest := t.TempDir()
+	if err := NewDownloader(cfg, keys, u.ObjectID(), dest, true, false, s).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dest, "docs", "a.txt")); string(got) != "frozen\n" {
+		t.Errorf("archived %q, want the snapshot's content", got)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	cfg := newTestConfig(t)
+	s := &memStorage{objects: map[string][]byte{}}
+	keys := keyring.NewLocal(cfg)
+	src := t.TempDir()
+	os.WriteFile(filepath.Join(src, "a.txt"), bytes.Repeat([]byte("a"), 4096), 0o644)
+	envFile := filepath.Join(t.TempDir(), "env")
+
+	// A failing pre hook aborts the upload before anything is stored.
+	cfg.Hooks.PreUpload = "exit 3"
+	cfg.Hooks.PostUpload = "touch " + envFile
+	err := upload.NewUploader(cfg, keys, []string{src}, s).Execute()
+	if err == nil || !strings.Contains(err.Error(), "pre-upload hook") {
+		t.Fatalf("Execute() error = %v, want a pre-upload hook error", err)
+	}
+	if len(s.objects) != 0 {
+		t.Errorf("%d objects stored after the pre hook failed", len(s.objects))
+	}
+	if _, err := os.Stat(envFile); !os.IsNotExist(err) {
+		t.Error("post hook ran after the pre hook failed")
+	}
+
+	cfg.Hooks.PreUpload = `test "$BURROW_HOOK" = pre`
+	cfg.Hooks.PostUpload = "env | grep ^BURROW_ > " + envFile
+	u := upload.NewUploader(cfg, keys, []string{src}, s)
+	u.SetMetadata("job", "docs")
+	if err := u.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	env := readHookEnv(t, envFile)
+	for k, want := range map[string]string{
+		"BURROW_OPERATION":          "upload",
+		"BURROW_OBJECT_ID":          u.ObjectID(),
+		"BURROW_STATUS":             "success",
+		"BURROW_META_JOB":           "docs",
+		"BURROW_SOURCES":            src,
+		"BURROW_BYTES_UNCOMPRESSED": "",
+		"BURROW_COMPRESSION":        "",
+	} {
+		if got, ok := env[k]; !ok || want != "" && got != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+	}
+
+	// A failing post hook only warns.
+	cfg.Hooks.PreDownload = ""
+	cfg.Hooks.PostDownload = "env | grep ^BURROW_ > " + envFile + "; exit 1"
+	d := NewDownloader(cfg, keys, u.ObjectID(), t.TempDir(), true, false, s)
+	if err := d.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Warnings()) != 1 {
+		t.Errorf("warnings = %v, want the post-download hook's", d.Warnings())
+	}
+	env = readHookEnv(t, envFile)
+	if env["BURROW_OPERATION"] != "download" || env["BURROW_STATUS"] != "success" || env["BURROW_BYTES_COMPRESSED"] == "" {
+		t.Errorf("post-download environment = %v", env)
+	}
+
+	// Extracting selected paths runs the same hooks.
+	cfg.Hooks.PreDownload = "exit 3"
+	dest := t.TempDir()
+	b := NewBrowser(cfg, keys, u.ObjectID(), false, s)
+	err = b.Extract(context.Background(), dest, []string{"**/a.txt"}, glob.Options{})
+	if err == nil || !strings.Contains(err.Error(), "pre-download hook") {
+		t.Fatalf("Extract() error = %v, want a pre-download hook error", err)
+	}
+	if entries, _ := os.ReadDir(dest); len(entries) != 0 {
+		t.Errorf("%d entries extracted after the pre hook failed", len(entries))
+	}
+	cfg.Hooks.PreDownload = ""
+	cfg.Hooks.PostDownload = "env | grep ^BURROW_ > " + envFile
+	if err := b.Extract(context.Background(), dest, []string{"**/a.txt"}, glob.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	env = readHookEnv(t, envFile)
+	if env["BURROW_OPERATION"] != "download" || env["BURROW_STATUS"] != "success" || env["BURROW_DESTINATION"] != dest {
+		t.Errorf("post-download environment of Extract = %v", env)
+	}
+}
+
+// readHookEnv parses the "env" output a hook wrote to path.
+func readHookEnv(t *testing.T, path string) map[string]string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := make(map[string]string)
+	for line := range strings.Lines(string(b)) {
+		if k, v, ok := strings.Cut(strings.TrimSuffix(line, "\n"), "="); ok {
+			env[k] = v
+		}
+	}
+	return env
+}
@@ -1,22 +1,31 @@
 package download
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/thebluefowl/burrow/internal/archive"
 	"github.com/thebluefowl/burrow/internal/compress"
 	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/padding"
 	"github.com/thebluefowl/burrow/internal/pipeline"
 	"github.com/thebluefowl/burrow/internal/progress"
+	"github.com/thebluefowl/burrow/internal/storage"
 )
 
 // decryptionPipeline manages the decryption pipeline execution
 type decryptionPipeline struct {
 	opts *DecryptionPipelineOpts
+
+	// Set by decompressStage.
+	bytesCompressed   int64
+	bytesUncompressed int64
 }
 
 // execute runs the complete pipeline
@@ -33,8 +42,8 @@ func (dp *decryptionPipeline) execute(ctx context.Context) error {
 		return fmt.Errorf("config is required")
 	}
 
-	if dp.opts.Config.MasterKey == nil {
-		return fmt.Errorf("masterKey is required")
+	if dp.opts.Keys == nil {
+		return fmt.Errorf("keyring is required")
 	}
 
 	stages := []pipeline.Stage{
@@ -42,10 +51,20 @@ func (dp *decryptionPipeline) execute(ctx context.Context) error {
 		dp.decryptStage,
 		dp.decompressStage,
 	}
+	if dp.opts.Envelope.Kind == envelope.KindFile && !dp.opts.Unarchive {
+		stages = append(stages, dp.singleFileStage)
+	}
 
-	if dp.opts.Unarchive {
+	switch {
+	case dp.opts.Output != nil && dp.opts.Unarchive:
+		return fmt.Errorf("cannot extract to an output stream")
+	case dp.opts.Unarchive && dp.opts.Envelope.Kind == envelope.KindStream:
+		return ErrNotArchive
+	case dp.opts.Output != nil:
+		stages = append(stages, dp.streamOutputStage)
+	case dp.opts.Unarchive:
 		stages = append(stages, dp.unarchiveStage)
-	} else {
+	default:
 		stages = append(stages, dp.fileOutputStage)
 	}
 
@@ -65,13 +84,28 @@ func (dp *decryptionPipeline) downloadStage(ctx context.Context, r io.Reader, w
 	bar := progress.CreateProgressBar("☁️  DOWNLOAD")
 	defer func() { _ = bar.Finish() }()
 
-	key := "data/" + dp.opts.ObjectID + ".enc"
+	if dp.opts.Data != nil {
+		if _, err := io.Copy(io.MultiWriter(w, bar), bytes.NewReader(dp.opts.Data)); err != nil {
+			return fmt.Errorf("download stage copy: %w", err)
+		}
+		return nil
+	}
+
+	key, size := dp.opts.Envelope.DataObject()
 
 	var buf bytes.Buffer
 	progressWriter := io.MultiWriter(&buf, bar)
 
-	_, _, err := dp.opts.Storage.Download(ctx, key, progressWriter)
-	if err != nil {
+	if size >= 0 {
+		// The data of a bundle, without the envelope at its end.
+		rd, ok := dp.opts.Storage.(storage.RangeDownloader)
+		if !ok {
+			return fmt.Errorf("download stage: storage backend does not support ranged downloads")
+		}
+		if err := rd.DownloadRange(ctx, key, 0, size, progressWriter); err != nil {
+			return fmt.Errorf("download stage: %w", err)
+		}
+	} else if _, _, err := dp.opts.Storage.Download(ctx, key, progressWriter); err != nil {
 		return fmt.Errorf("download stage: %w", err)
 	}
 
@@ -87,9 +121,15 @@ func (dp *decryptionPipeline) decryptStage(ctx context.Context, r io.Reader, w i
 	bar := progress.CreateProgressBar("🔓 DECRYPT ")
 	defer func() { _ = bar.Finish() }()
 
-	dataKey, err := enc.DeriveDataKey(dp.opts.Config.MasterKey, dp.opts.ObjectID)
+	dataKey, err := dp.opts.Envelope.ResolveDataKey(dp.opts.Keys)
 	if err != nil {
-		return fmt.Errorf("derive data key: %w", err)
+		return fmt.Errorf("resolve data key: %w", err)
+	}
+
+	// Padding is covered by the AEAD and the SHA-256 and stripped only from
+	// the output.
+	if p := dp.opts.Envelope.Padding; p != nil {
+		w = padding.NewLimitWriter(w, p.Length)
 	}
 
 	progressReader := io.TeeReader(r, bar)
@@ -109,6 +149,9 @@ func (dp *decryptionPipeline) decryptStage(ctx context.Context, r io.Reader, w i
 // decompressStage decompresses the data based on envelope compression mode
 func (dp *decryptionPipeline) decompressStage(ctx context.Context, r io.Reader, w io.Writer) error {
 	mode := dp.opts.Envelope.Compression.Mode
+	in := &countingReader{r: r}
+	r = in
+	defer func() { dp.bytesCompressed = in.n }()
 
 	switch mode {
 	case string(compress.CompressNone), "":
@@ -117,29 +160,34 @@ func (dp *decryptionPipeline) decompressStage(ctx context.Context, r io.Reader,
 		defer func() { _ = bar.Finish() }()
 
 		progressReader := io.TeeReader(r, bar)
-		if _, err := io.Copy(w, progressReader); err != nil {
+		n, err := io.Copy(w, progressReader)
+		dp.bytesUncompressed = n
+		if err != nil {
 			return fmt.Errorf("passthrough copy: %w", err)
 		}
 		return nil
 
-	case string(compress.CompressZstd):
-		// Decompress zstd
+	default:
+		codec, err := compress.Lookup(compress.CompressionMode(mode))
+		if err != nil {
+			return err
+		}
 		bar := progress.CreateProgressBar("🗜️  UNZIP   ")
 		defer func() { _ = bar.Finish() }()
 
-		decoder, err := compress.NewZstdDecoder(r)
+		decoder, err := codec.NewReader(r, compress.CodecOptions{Dictionary: dp.opts.Envelope.Compression.Dictionary})
 		if err != nil {
-			return fmt.Errorf("create zstd decoder: %w", err)
+			return fmt.Errorf("create %s decoder: %w", mode, err)
 		}
+		defer func() { _ = decoder.Close() }()
 
-		progressReader := io.TeeReader(decoder.IOReadCloser(), bar)
-		if _, err := io.Copy(w, progressReader); err != nil {
+		progressReader := io.TeeReader(decoder, bar)
+		n, err := io.Copy(w, progressReader)
+		dp.bytesUncompressed = n
+		if err != nil {
 			return fmt.Errorf("decompress stage copy: %w", err)
 		}
 		return nil
-
-	default:
-		return fmt.Errorf("unsupported compression mode: %s", mode)
 	}
 }
 
@@ -154,7 +202,50 @@ func (dp *decryptionPipeline) unarchiveStage(ctx context.Context, r io.Reader, w
 	return nil
 }
 
-// outputStage writes to file
+// singleFileStage unpacks the one file of a single-file archive
+func (dp *decryptionPipeline) singleFileStage(ctx context.Context, r io.Reader, w io.Writer) error {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("read archive: %w", err)
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return fmt.Errorf("single-file archive holds %q of type %c", hdr.Name, hdr.Typeflag)
+	}
+	if _, err := io.Copy(w, tr); err != nil {
+		return fmt.Errorf("unpack %q: %w", hdr.Name, err)
+	}
+	switch next, err := tr.Next(); {
+	case err == io.EOF:
+	case err == nil && next.Name == hdr.Name:
+		// The upload archived the file again after it changed mid-read.
+		return fmt.Errorf("archive holds %q more than once because it changed during the upload; use --extract to restore the last copy", hdr.Name)
+	default:
+		return fmt.Errorf("single-file archive holds more than one entry")
+	}
+	// Drain the tar padding so the decryption stages see the whole stream.
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
+// outputPath returns where fileOutputStage writes. A destination that is an
+// existing directory gets the original name, with .tar for archives;
+// anything else is used as is.
+func (dp *decryptionPipeline) outputPath() string {
+	stat, err := os.Stat(dp.opts.DestPath)
+	if err != nil || !stat.IsDir() {
+		return dp.opts.DestPath
+	}
+	name := filepath.Base(dp.opts.Envelope.OriginalFileName)
+	switch dp.opts.Envelope.Kind {
+	case envelope.KindFile, envelope.KindStream:
+	default:
+		name += ".tar"
+	}
+	return filepath.Join(dp.opts.DestPath, name)
+}
+
+// fileOutputStage writes to file
 func (dp *decryptionPipeline) fileOutputStage(ctx context.Context, r io.Reader, _ io.Writer) error {
 	// Write to file
 	bar := progress.CreateProgressBar("💾 WRITE   ")
@@ -163,18 +254,7 @@ func (dp *decryptionPipeline) fileOutputStage(ctx context.Context, r io.Reader,
 		return fmt.Errorf("destPath is required")
 	}
 
-	// Determine output path - restore original filename + .tar extension
-	var outputPath string
-	if stat, err := os.Stat(dp.opts.DestPath); err == nil && stat.IsDir() {
-		// DestPath is a directory, construct filename from envelope
-		filename := dp.opts.Envelope.OriginalFileName + ".tar"
-		outputPath = dp.opts.DestPath + string(os.PathSeparator) + filename
-	} else {
-		// DestPath is a file path, ensure it has .tar extension
-		outputPath = dp.opts.DestPath + ".tar"
-	}
-
-	w, err := os.Create(outputPath)
+	w, err := os.Create(dp.outputPath())
 	if err != nil {
 		return fmt.Errorf("create file: %w", err)
 	}
@@ -185,5 +265,30 @@ func (dp *decryptionPipeline) fileOutputStage(ctx context.Context, r io.Reader,
 		return fmt.Errorf("write stage: %w", err)
 	}
 
+	return w.Close()
+}
+
+// streamOutputStage writes to the output stream
+func (dp *decryptionPipeline) streamOutputStage(ctx context.Context, r io.Reader, _ io.Writer) error {
+	bar := progress.CreateProgressBar("📤 OUTPUT  ")
+	defer func() { _ = bar.Finish() }()
+
+	progressReader := io.TeeReader(r, bar)
+	if _, err := io.Copy(dp.opts.Output, progressReader); err != nil {
+		return fmt.Errorf("output stage: %w", err)
+	}
+
 	return nil
 }
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
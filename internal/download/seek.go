@@ -0,0 +1,175 @@
+package download
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/thebluefowl/burrow/internal/archive"
+	"github.com/thebluefowl/burrow/internal/compress"
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/glob"
+	"github.com/thebluefowl/burrow/internal/hooks"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/padding"
+	"github.com/thebluefowl/burrow/internal/storage"
+)
+
+// ErrNotArchive is returned when extracting an object that holds a raw
+// stream instead of a tar archive.
+var ErrNotArchive = errors.New("object is a raw stream, not an archive")
+
+// ErrNotSeekable is returned for objects written without a seek table.
+var ErrNotSeekable = errors.New("object has no seek table; it was not compressed in auto or zstd-seekable mode")
+
+// OpenSeekable returns random access to the uncompressed content of an
+// object. Only the encrypted chunks and compressed frames a read touches are
+// downloaded, decrypted and decompressed. data is the encrypted data of a
+// bundled object if it was downloaded with the envelope; nil reads the data
+// object (see Envelope.DataObject) with ranged downloads.
+func OpenSeekable(ctx context.Context, s storage.Storage, env *envelope.Envelope, keys keyring.Keyring, data []byte) (*compress.SeekableReader, error) {
+	sk := env.Compression.Seekable
+	if sk == nil {
+		return nil, ErrNotSeekable
+	}
+
+	dataKey, err := env.ResolveDataKey(keys)
+	if err != nil {
+		return nil, fmt.Errorf("resolve data key: %w", err)
+	}
+
+	var ct io.ReaderAt
+	switch rd, ok := s.(storage.RangeDownloader); {
+	case data != nil:
+		ct = bytes.NewReader(data)
+	case ok:
+		// A bundle starts with the data, so offsets are the same.
+		key, _ := env.DataObject()
+		ct = storage.NewReaderAt(ctx, rd, key)
+	default:
+		return nil, errors.New("storage backend does not support ranged downloads")
+	}
+
+	// The AEAD plaintext is the compressed stream, padded in privacy mode.
+	plainSize := sk.StreamSize()
+	if env.Padding != nil {
+		plainSize = padding.Padme(env.Padding.Length)
+	}
+	plain, err := enc.NewRangeReader(ct, plainSize, dataKey, env.Encryption.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := compress.NewSeekableReader(plain, sk.StreamSize(), compress.CodecOptions{Dictionary: env.Compression.Dictionary})
+	if err != nil {
+		return nil, err
+	}
+	if r.Frames() != sk.Frames {
+		r.Close()
+		return nil, fmt.Errorf("seek table has %d frames, envelope records %d", r.Frames(), sk.Frames)
+	}
+	return r, nil
+}
+
+// Browser reads entries of an archived object without downloading it whole.
+type Browser struct {
+	config        *config.Config
+	keys          keyring.Keyring
+	objectID      string
+	allowUnsigned bool
+	storage       storage.Storage
+
+	warnings []error
+}
+
+// NewBrowser creates a new Browser instance
+func NewBrowser(cfg *config.Config, keys keyring.Keyring, objectID string, allowUnsigned bool, storageClient storage.Storage) *Browser {
+	return &Browser{
+		config:        cfg,
+		keys:          keys,
+		objectID:      objectID,
+		allowUnsigned: allowUnsigned,
+		storage:       storageClient,
+	}
+}
+
+// List calls fn for every entry of the archive.
+func (b *Browser) List(ctx context.Context, fn func(*tar.Header) error) error {
+	r, _, err := b.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read archive: %w", err)
+		}
+		if err := fn(hdr); err != nil {
+			return err
+		}
+	}
+}
+
+// Extract extracts the entries matching one of the glob patterns (see
+// package glob), and everything below matching directories, to destDir,
+// between the pre and post download hooks.
+func (b *Browser) Extract(ctx context.Context, destDir string, patterns []string, opts glob.Options) error {
+	match, err := glob.CompileList(patterns, opts)
+	if err != nil {
+		return err
+	}
+
+	event := hooks.NewEvent(hooks.Download)
+	event.ObjectID = b.objectID
+	event.Destination = destDir
+	warn := func(err error) { b.warnings = append(b.warnings, err) }
+
+	return runHooks(ctx, b.config, event, warn, func() error {
+		r, env, err := b.open(ctx)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		event.Compression = env.Compression.Mode
+		if inc := env.Incremental; inc != nil {
+			b.warnings = append(b.warnings, fmt.Errorf("%s holds only the changes since %s; paths unchanged since then are not extracted", b.objectID, inc.Parent))
+		}
+
+		return archive.ExtractTarFiltered(r, destDir, func(hdr *tar.Header) bool {
+			return match.MatchTree(hdr.Name, hdr.Typeflag == tar.TypeDir)
+		})
+	})
+}
+
+// Warnings returns the problems that didn't stop an extraction, such as
+// the object being an increment or a failed post-download hook.
+func (b *Browser) Warnings() []error {
+	return b.warnings
+}
+
+func (b *Browser) open(ctx context.Context) (*compress.SeekableReader, *envelope.Envelope, error) {
+	trust := envelope.Trust{
+		Signers:       b.config.Signers(),
+		AllowUnsigned: b.allowUnsigned,
+	}
+	env, data, err := envelope.FetchObject(ctx, b.storage, b.objectID, b.keys.DecryptConfig(), trust)
+	if err != nil {
+		return nil, nil, err
+	}
+	if env.Kind == envelope.KindStream {
+		return nil, nil, ErrNotArchive
+	}
+	r, err := OpenSeekable(ctx, b.storage, env, b.keys, data)
+	return r, env, err
+}
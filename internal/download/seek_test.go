@@ -0,0 +1,178 @@
+package download
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/glob"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/storage"
+	"github.com/thebluefowl/burrow/internal/upload"
+)
+
+// memStorage is an in-memory storage.Storage that counts downloaded bytes.
+type memStorage struct {
+	objects    map[string][]byte
+	downloaded int64
+}
+
+func (m *memStorage) Upload(_ context.Context, key string, body io.Reader, _ string, _ map[string]string) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	m.objects[key] = b
+	return nil
+}
+
+func (m *memStorage) Download(_ context.Context, key string, w io.Writer) (string, map[string]string, error) {
+	b, ok := m.objects[key]
+	if !ok {
+		return "", nil, storage.ErrNotFound
+	}
+	m.downloaded += int64(len(b))
+	_, err := w.Write(b)
+	return "", nil, err
+}
+
+func (m *memStorage) DownloadRange(_ context.Context, key string, offset, length int64, w io.Writer) error {
+	b, ok := m.objects[key]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if offset+length > int64(len(b)) {
+		return io.ErrUnexpectedEOF
+	}
+	m.downloaded += length
+	_, err := w.Write(b[offset : offset+length])
+	return err
+}
+
+func (m *memStorage) GetMetadata(_ context.Context, key string) (map[string]string, error) {
+	if _, ok := m.objects[key]; !ok {
+		return nil, storage.ErrNotFound
+	}
+	return nil, nil
+}
+
+func (m *memStorage) List(_ context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	var out []storage.ObjectInfo
+	for k, v := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, storage.ObjectInfo{Key: k, Size: int64(len(v))})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+func newTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	pub, priv, err := enc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := make([]byte, 64)
+	rand.Read(master)
+	cfg := &config.Config{AgePublicKey: pub, AgePrivateKey: priv, MasterKey: master}
+	if _, err := cfg.EnsureSigningKey(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestBrowserSeeksInsteadOfDownloading(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	big := make([]byte, 24<<20)
+	rand.Read(big)
+	files := map[string][]byte{
+		"a.txt":       []byte("first file\n"),
+		"big.bin":     big,
+		"sub/z.txt":   bytes.Repeat([]byte("zzz\n"), 1000),
+		"sub/empty":   nil,
+		"sub/y/w.log": bytes.Repeat([]byte("log line\n"), 50000),
+	}
+	for name, data := range files {
+		p := filepath.Join(src, name)
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A hardlink selected without the file archived first, which holds the
+	// content.
+	if err := os.Link(filepath.Join(src, "big.bin"), filepath.Join(src, "sub", "y", "big.lnk")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []string{"plain", "private", "bundle"} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			cfg.Privacy = mode == "private"
+			cfg.Bundle = mode == "bundle"
+			s := &memStorage{objects: map[string][]byte{}}
+			keys := keyring.NewLocal(cfg)
+			ctx := context.Background()
+
+			u := upload.NewUploader(cfg, keys, []string{src}, s)
+			if err := u.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			b := NewBrowser(cfg, keys, u.ObjectID(), false, s)
+
+			var names []string
+			s.downloaded = 0
+			err := b.List(ctx, func(h *tar.Header) error {
+				names = append(names, h.Name)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"src/", "src/a.txt", "src/big.bin", "src/sub/", "src/sub/empty", "src/sub/y/", "src/sub/y/big.lnk", "src/sub/y/w.log", "src/sub/z.txt"}
+			if strings.Join(names, ",") != strings.Join(want, ",") {
+				t.Fatalf("List() = %v", names)
+			}
+			if s.downloaded > 12<<20 {
+				t.Errorf("listing downloaded %d bytes", s.downloaded)
+			}
+
+			// A full download reads the same stream sequentially.
+			full := t.TempDir()
+			if err := NewDownloader(cfg, keys, u.ObjectID(), full, true, false, s).Execute(); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := os.ReadFile(filepath.Join(full, "src", "big.bin")); err != nil || !bytes.Equal(got, big) {
+				t.Fatalf("full download mismatch: %v", err)
+			}
+
+			dest := t.TempDir()
+			if err := b.Extract(ctx, dest, []string{"src/sub/y", "/SRC/A.txt"}, glob.Options{CaseInsensitive: true}); err != nil {
+				t.Fatal(err)
+			}
+			for name, data := range files {
+				got, err := os.ReadFile(filepath.Join(dest, "src", name))
+				wanted := name == "a.txt" || strings.HasPrefix(name, "sub/y/")
+				if wanted && (err != nil || !bytes.Equal(got, data)) {
+					t.Errorf("%s not extracted: %v", name, err)
+				}
+				if !wanted && err == nil {
+					t.Errorf("%s extracted", name)
+				}
+			}
+			if got, err := os.ReadFile(filepath.Join(dest, "src", "sub", "y", "big.lnk")); err != nil || !bytes.Equal(got, big) {
+				t.Errorf("hardlink to an unselected file not extracted with its content: %v", err)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -17,16 +18,34 @@ import (
 
 const aeadVersionTag = "burrow.v1"
 
+const keyCommitmentTag = "burrow/key-commitment"
+
 const (
 	AEADDefaultChunkSize = 4 << 20
-	aeadMaxChunkSize     = 64 << 20
+	AEADMinChunkSize     = 32 << 10
+	AEADMaxChunkSize     = 64 << 20
 	aeadTagSize          = 16
 )
 
 type AEADParams struct {
-	ObjectID  string
-	ChunkSize int
-	NBase     [24]byte
+	ObjectID  string    `json:"object_id"`
+	ChunkSize int       `json:"chunk_size"`
+	NBase     NonceBase `json:"nonce_base"`
+}
+
+// NonceBase is the per-object XChaCha20 nonce prefix. It serializes as hex.
+type NonceBase [24]byte
+
+func (n NonceBase) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(n[:])), nil
+}
+
+func (n *NonceBase) UnmarshalText(b []byte) error {
+	if hex.DecodedLen(len(b)) != len(n) {
+		return fmt.Errorf("aead: nonce base must be %d bytes", len(n))
+	}
+	_, err := hex.Decode(n[:], b)
+	return err
 }
 
 type AEADResult struct {
@@ -43,8 +62,8 @@ func NewAEADParams(objectID string, chunkSize int) (AEADParams, error) {
 	if chunkSize <= 0 {
 		chunkSize = AEADDefaultChunkSize
 	}
-	if chunkSize < 32<<10 || chunkSize > aeadMaxChunkSize {
-		return AEADParams{}, fmt.Errorf("aead: invalid chunkSize %d", chunkSize)
+	if err := ValidateChunkSize(chunkSize); err != nil {
+		return AEADParams{}, err
 	}
 	var n [24]byte
 	if _, err := rand.Read(n[:]); err != nil {
@@ -53,6 +72,14 @@ func NewAEADParams(objectID string, chunkSize int) (AEADParams, error) {
 	return AEADParams{ObjectID: objectID, ChunkSize: chunkSize, NBase: n}, nil
 }
 
+// ValidateChunkSize checks that chunkSize is a usable AEAD chunk size.
+func ValidateChunkSize(chunkSize int) error {
+	if chunkSize < AEADMinChunkSize || chunkSize > AEADMaxChunkSize {
+		return fmt.Errorf("aead: invalid chunkSize %d (want %d to %d)", chunkSize, AEADMinChunkSize, AEADMaxChunkSize)
+	}
+	return nil
+}
+
 func DeriveDataKey(masterKey []byte, objectID string) ([]byte, error) {
 	if len(masterKey) == 0 {
 		return nil, errors.New("aead: masterKey empty")
@@ -65,6 +92,30 @@ func DeriveDataKey(masterKey []byte, objectID string) ([]byte, error) {
 	return k, nil
 }
 
+// NewDataKey returns a random data key for objects whose key is wrapped in
+// the envelope rather than derived from the master key.
+func NewDataKey() ([]byte, error) {
+	k := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(k); err != nil {
+		return nil, fmt.Errorf("aead: data key gen: %w", err)
+	}
+	return k, nil
+}
+
+// KeyCommitment binds dataKey to objectID without revealing the key, so a
+// derived key can be checked before any data is decrypted.
+func KeyCommitment(dataKey []byte, objectID string) []byte {
+	m := hmac.New(sha256.New, dataKey)
+	m.Write([]byte(keyCommitmentTag))
+	m.Write([]byte(objectID))
+	return m.Sum(nil)
+}
+
+// VerifyKeyCommitment reports whether commitment matches dataKey and objectID.
+func VerifyKeyCommitment(dataKey []byte, objectID string, commitment []byte) bool {
+	return hmac.Equal(KeyCommitment(dataKey, objectID), commitment)
+}
+
 // EncryptAEAD encrypts the data from src to dst using ChaCha20-Poly1305 with the provided dataKey and AEADParams.
 // WARNING: AEADParams must be freshly initialized via NewAEADParams for each encryption session, even for the same object (same KSUID).
 // Reusing AEADParams with the same NBase and dataKey across multiple encryption sessions for the same object will cause nonce reuse,
@@ -0,0 +1,124 @@
+package enc
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// AEADCiphertextSize returns the size of the EncryptAEAD output for
+// plainSize bytes of input.
+func AEADCiphertextSize(plainSize int64, chunkSize int) int64 {
+	if plainSize <= 0 {
+		return 0
+	}
+	chunks := (plainSize + int64(chunkSize) - 1) / int64(chunkSize)
+	return plainSize + chunks*(4+aeadTagSize)
+}
+
+// RangeReader decrypts arbitrary ranges of an EncryptAEAD stream, reading
+// only the chunks a range covers. Every chunk is authenticated on its own and
+// bound to its index, so chunks cannot be reordered; plainSize must come from
+// a trusted source (the envelope) to detect truncation.
+type RangeReader struct {
+	ct        io.ReaderAt
+	plainSize int64
+	chunkSize int64
+	objectID  string
+	nbase     NonceBase
+	aead      cipher.AEAD
+
+	cached int64 // chunk held in plain, -1 for none
+	plain  []byte
+	buf    []byte
+}
+
+// NewRangeReader returns a RangeReader over the plainSize-byte plaintext of
+// the ciphertext in ct.
+func NewRangeReader(ct io.ReaderAt, plainSize int64, dataKey []byte, p AEADParams) (*RangeReader, error) {
+	if len(dataKey) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("aead: dataKey must be 32 bytes")
+	}
+	if err := ValidateChunkSize(p.ChunkSize); err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &RangeReader{
+		ct:        ct,
+		plainSize: plainSize,
+		chunkSize: int64(p.ChunkSize),
+		objectID:  p.ObjectID,
+		nbase:     p.NBase,
+		aead:      aead,
+		cached:    -1,
+	}, nil
+}
+
+// Size returns the plaintext size.
+func (r *RangeReader) Size() int64 {
+	return r.plainSize
+}
+
+// ReadAt implements io.ReaderAt over the plaintext.
+func (r *RangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("aead: negative offset")
+	}
+	n := 0
+	for n < len(p) {
+		if off >= r.plainSize {
+			return n, io.EOF
+		}
+		idx := off / r.chunkSize
+		if err := r.load(idx); err != nil {
+			return n, err
+		}
+		k := copy(p[n:], r.plain[off-idx*r.chunkSize:])
+		n += k
+		off += int64(k)
+	}
+	return n, nil
+}
+
+// load decrypts chunk idx into r.plain.
+func (r *RangeReader) load(idx int64) error {
+	if r.cached == idx {
+		return nil
+	}
+	ptLen := min(r.chunkSize, r.plainSize-idx*r.chunkSize)
+	ctLen := ptLen + aeadTagSize
+	if int64(cap(r.buf)) < 4+ctLen {
+		r.buf = make([]byte, 4+ctLen)
+	}
+	r.buf = r.buf[:4+ctLen]
+	if _, err := r.ct.ReadAt(r.buf, idx*(r.chunkSize+4+aeadTagSize)); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("aead chunk %d: %w", idx, err)
+	}
+	if binary.LittleEndian.Uint32(r.buf[:4]) != uint32(ctLen) {
+		return fmt.Errorf("aead chunk %d: unexpected length", idx)
+	}
+
+	var nonce [24]byte
+	copy(nonce[:16], r.nbase[:16])
+	binary.LittleEndian.PutUint64(nonce[16:], uint64(idx))
+	aad := buildAAD(r.objectID, uint64(idx), uint64(ptLen))
+
+	pt, err := r.aead.Open(r.plain[:0], nonce[:], r.buf[4:], aad)
+	if err != nil {
+		r.cached = -1
+		return fmt.Errorf("aead chunk %d: %w", idx, err)
+	}
+	r.plain = pt
+	r.cached = idx
+	return nil
+}
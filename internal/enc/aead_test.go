@@ -21,10 +21,10 @@ func TestNewAEADParams(t *testing.T) {
 		{"valid default chunk", "obj123", 0, false},
 		{"valid custom chunk", "obj456", 1 << 20, false},
 		{"valid min chunk", "obj789", 32 << 10, false},
-		{"valid max chunk", "objmax", aeadMaxChunkSize, false},
+		{"valid max chunk", "objmax", AEADMaxChunkSize, false},
 		{"empty objectID", "", 0, true},
 		{"chunk too small", "obj", 1024, true},
-		{"chunk too large", "obj", aeadMaxChunkSize + 1, true},
+		{"chunk too large", "obj", AEADMaxChunkSize + 1, true},
 	}
 
 	for _, tt := range tests {
@@ -484,3 +484,53 @@ func BenchmarkDecryptAEAD(b *testing.B) {
 		_, _ = DecryptAEAD(&dst, bytes.NewReader(encData), dataKey, params)
 	}
 }
+
+func TestRangeReader(t *testing.T) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	rand.Read(key)
+	params, err := NewAEADParams("range", 32<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := make([]byte, 100<<10+123)
+	rand.Read(plain)
+
+	var ct bytes.Buffer
+	if _, err := EncryptAEAD(&ct, bytes.NewReader(plain), key, params); err != nil {
+		t.Fatal(err)
+	}
+	if got := AEADCiphertextSize(int64(len(plain)), params.ChunkSize); got != int64(ct.Len()) {
+		t.Fatalf("AEADCiphertextSize = %d, want %d", got, ct.Len())
+	}
+
+	r, err := NewRangeReader(bytes.NewReader(ct.Bytes()), int64(len(plain)), key, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rng := range [][2]int{{0, 10}, {32<<10 - 5, 10}, {50 << 10, 40 << 10}, {len(plain) - 7, 7}} {
+		got := make([]byte, rng[1])
+		if _, err := r.ReadAt(got, int64(rng[0])); err != nil {
+			t.Fatalf("ReadAt(%d): %v", rng[0], err)
+		}
+		if !bytes.Equal(got, plain[rng[0]:rng[0]+rng[1]]) {
+			t.Fatalf("ReadAt(%d) mismatch", rng[0])
+		}
+	}
+
+	// A flipped bit in one chunk only fails reads of that chunk.
+	tampered := bytes.Clone(ct.Bytes())
+	tampered[40<<10] ^= 1
+	r, _ = NewRangeReader(bytes.NewReader(tampered), int64(len(plain)), key, params)
+	if _, err := r.ReadAt(make([]byte, 10), 0); err != nil {
+		t.Fatalf("untouched chunk: %v", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 10), 33<<10); err == nil {
+		t.Fatal("read from a tampered chunk")
+	}
+
+	// A truncated object fails instead of returning short data.
+	r, _ = NewRangeReader(bytes.NewReader(ct.Bytes()[:ct.Len()-100]), int64(len(plain)), key, params)
+	if _, err := r.ReadAt(make([]byte, 10), int64(len(plain)-10)); err == nil {
+		t.Fatal("read from a truncated object")
+	}
+}
@@ -10,7 +10,9 @@ import (
 	"strings"
 
 	"filippo.io/age"
+	"filippo.io/age/agessh"
 	"filippo.io/age/armor"
+	"golang.org/x/crypto/ssh"
 )
 
 // GenerateKey generates a new age X25519 key pair
@@ -27,7 +29,7 @@ func GenerateKey() (publicKey, privateKey string, err error) {
 // Exactly one of Passphrase or Recipients must be provided.
 type EncryptConfig struct {
 	Passphrase string   // password for scrypt recipient
-	Recipients []string // age public keys: "age1..." (X25519)
+	Recipients []string // "age1..." (X25519), "ssh-ed25519 ..." or "ssh-rsa ..."
 	Armor      bool     // optional ASCII armor (default: false)
 }
 
@@ -35,9 +37,21 @@ type EncryptConfig struct {
 // Exactly one of Passphrase or Identities must be provided.
 type DecryptConfig struct {
 	Passphrase string   // password for scrypt identity
-	Identities []string // age secret keys: "AGE-SECRET-KEY-1..."
+	Identities []string // "AGE-SECRET-KEY-1...", or paths to age/OpenSSH identity files
+
+	// SSHPassphrase is called with the key path when a passphrase-protected
+	// OpenSSH key matches the ciphertext. Defaults to DefaultSSHPassphrase.
+	SSHPassphrase func(path string) ([]byte, error)
+
+	// AgeIdentities are already parsed identities tried after Identities,
+	// e.g. ones that forward unwrapping to the burrow agent.
+	AgeIdentities []age.Identity
 }
 
+// DefaultSSHPassphrase is used for encrypted SSH keys when
+// DecryptConfig.SSHPassphrase is nil. Interactive callers set it to a prompt.
+var DefaultSSHPassphrase func(path string) ([]byte, error)
+
 // NewEncryptWriter returns a WriteCloser that encrypts plaintext written to it
 // and emits age ciphertext to dst. Call Close() when done to finalize.
 func NewEncryptWriter(dst io.Writer, cfg EncryptConfig) (io.WriteCloser, error) {
@@ -150,7 +164,7 @@ func NewDecryptReader(src io.Reader, cfg DecryptConfig) (io.Reader, error) {
 		}
 		return age.Decrypt(src, identity)
 	default:
-		ids, err := parseIdentities(cfg.Identities)
+		ids, err := ParseIdentities(cfg)
 		if err != nil {
 			return nil, err
 		}
@@ -229,13 +243,39 @@ func validateEncryptConfig(cfg EncryptConfig) error {
 
 func validateDecryptConfig(cfg DecryptConfig) error {
 	pass := cfg.Passphrase != ""
-	keys := len(cfg.Identities) > 0
+	keys := len(cfg.Identities) > 0 || len(cfg.AgeIdentities) > 0
 	if pass == keys {
 		return errors.New("decryption config: exactly one of Passphrase or Identities must be set")
 	}
 	return nil
 }
 
+// ParseIdentities parses the identities configured in cfg, including
+// AgeIdentities. Passphrase mode is not supported.
+func ParseIdentities(cfg DecryptConfig) ([]age.Identity, error) {
+	if len(cfg.Identities) == 0 && len(cfg.AgeIdentities) > 0 {
+		return cfg.AgeIdentities, nil
+	}
+	ids, err := parseIdentities(cfg.Identities, cfg.SSHPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	return append(ids, cfg.AgeIdentities...), nil
+}
+
+// ValidateRecipient reports whether key is a recipient this package can encrypt to.
+func ValidateRecipient(key string) error {
+	_, err := parseRecipients([]string{key})
+	return err
+}
+
+// ValidateIdentityFile reports whether path holds an identity this package can
+// decrypt with. Passphrase-protected SSH keys are accepted without prompting.
+func ValidateIdentityFile(path string) error {
+	_, err := parseIdentities([]string{path}, nil)
+	return err
+}
+
 func parseRecipients(keys []string) ([]age.Recipient, error) {
 	var out []age.Recipient
 	for _, k := range keys {
@@ -243,7 +283,13 @@ func parseRecipients(keys []string) ([]age.Recipient, error) {
 		if k == "" {
 			continue
 		}
-		rcpt, err := age.ParseX25519Recipient(k)
+		var rcpt age.Recipient
+		var err error
+		if strings.HasPrefix(k, "ssh-") {
+			rcpt, err = agessh.ParseRecipient(k)
+		} else {
+			rcpt, err = age.ParseX25519Recipient(k)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("parse recipient %q: %w", k, err)
 		}
@@ -255,7 +301,7 @@ func parseRecipients(keys []string) ([]age.Recipient, error) {
 	return out, nil
 }
 
-func parseIdentities(keys []string) ([]age.Identity, error) {
+func parseIdentities(keys []string, sshPassphrase func(path string) ([]byte, error)) ([]age.Identity, error) {
 	var out []age.Identity
 	for _, k := range keys {
 		k = strings.TrimSpace(k)
@@ -276,6 +322,14 @@ func parseIdentities(keys []string) ([]age.Identity, error) {
 		if err != nil {
 			return nil, fmt.Errorf("read identity file %q: %w", k, err)
 		}
+		if bytes.Contains(data, []byte("-----BEGIN")) {
+			id, err := parseSSHIdentity(k, data, sshPassphrase)
+			if err != nil {
+				return nil, fmt.Errorf("parse identity from %q: %w", k, err)
+			}
+			out = append(out, id)
+			continue
+		}
 		lines := strings.Split(string(data), "\n")
 		var parsed bool
 		for _, ln := range lines {
@@ -299,6 +353,46 @@ func parseIdentities(keys []string) ([]age.Identity, error) {
 	return out, nil
 }
 
+// parseSSHIdentity parses an ssh-ed25519 or ssh-rsa private key. For
+// passphrase-protected keys the passphrase is only requested if the key
+// actually matches a recipient stanza.
+func parseSSHIdentity(path string, pemBytes []byte, passphrase func(path string) ([]byte, error)) (age.Identity, error) {
+	id, err := agessh.ParseIdentity(pemBytes)
+	if err == nil {
+		return id, nil
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, err
+	}
+
+	pubKey := missing.PublicKey
+	if pubKey == nil {
+		// Older PEM formats don't embed the public key; fall back to the .pub file.
+		pubData, err := os.ReadFile(path + ".pub")
+		if err != nil {
+			return nil, fmt.Errorf("encrypted SSH key without public key: %w", err)
+		}
+		pubKey, _, _, _, err = ssh.ParseAuthorizedKey(pubData)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s.pub: %w", path, err)
+		}
+	}
+
+	if passphrase == nil {
+		passphrase = DefaultSSHPassphrase
+	}
+	prompt := func() ([]byte, error) {
+		if passphrase == nil {
+			return nil, fmt.Errorf("SSH key %q is passphrase-protected and no passphrase prompt is available", path)
+		}
+		return passphrase(path)
+	}
+
+	return agessh.NewEncryptedSSHIdentity(pubKey, pemBytes, prompt)
+}
+
 type multiCloseWriter struct {
 	io.Writer
 	finals []io.Closer
@@ -0,0 +1,126 @@
+package enc
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func writeSSHKey(t *testing.T, passphrase string) (path string, recipient string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path = filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(sshPub)))
+}
+
+func TestSSHRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase string
+	}{
+		{"unencrypted key", ""},
+		{"passphrase-protected key", "correct horse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, recipient := writeSSHKey(t, tt.passphrase)
+			plain := []byte("shared with an ssh key")
+
+			ct, err := EncryptBytes(plain, EncryptConfig{Recipients: []string{recipient}})
+			if err != nil {
+				t.Fatalf("EncryptBytes() error = %v", err)
+			}
+
+			prompted := 0
+			got, err := DecryptBytes(ct, DecryptConfig{
+				Identities: []string{path},
+				SSHPassphrase: func(string) ([]byte, error) {
+					prompted++
+					return []byte(tt.passphrase), nil
+				},
+			})
+			if err != nil {
+				t.Fatalf("DecryptBytes() error = %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Error("decrypted data mismatch")
+			}
+			if wantPrompt := tt.passphrase != ""; (prompted > 0) != wantPrompt {
+				t.Errorf("prompted %d times, want prompt = %v", prompted, wantPrompt)
+			}
+		})
+	}
+}
+
+func TestSSHWrongPassphrase(t *testing.T) {
+	path, recipient := writeSSHKey(t, "right")
+
+	ct, err := EncryptBytes([]byte("data"), EncryptConfig{Recipients: []string{recipient}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = DecryptBytes(ct, DecryptConfig{
+		Identities:    []string{path},
+		SSHPassphrase: func(string) ([]byte, error) { return []byte("wrong"), nil },
+	})
+	if err == nil {
+		t.Error("expected error for wrong passphrase")
+	}
+}
+
+func TestValidateRecipient(t *testing.T) {
+	pub, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sshRecipient := writeSSHKey(t, "")
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"x25519", pub, false},
+		{"ssh-ed25519", sshRecipient, false},
+		{"garbage", "not-a-key", true},
+		{"bad ssh", "ssh-ed25519 AAAA", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateRecipient(tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRecipient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
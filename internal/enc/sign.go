@@ -0,0 +1,83 @@
+package enc
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// GenerateSigningKey creates an Ed25519 key pair. Both keys are returned
+// base64 encoded; the private key is the 32-byte seed.
+func GenerateSigningKey() (publicKey, privateKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("sign: key gen: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv.Seed()), nil
+}
+
+// SigningPublicKey returns the public key belonging to privateKey.
+func SigningPublicKey(privateKey string) (string, error) {
+	priv, err := parseSigningKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey)), nil
+}
+
+// Sign signs msg with privateKey.
+func Sign(privateKey string, msg []byte) ([]byte, error) {
+	priv, err := parseSigningKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.Sign(priv, msg), nil
+}
+
+// VerifySignature reports whether sig is a valid signature of msg by publicKey.
+func VerifySignature(publicKey string, msg, sig []byte) bool {
+	pub, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(pub), msg, sig)
+}
+
+// Signer signs envelopes. It returns the signer's public key with the
+// signature, so the private key can live elsewhere (e.g. in the agent).
+type Signer interface {
+	Sign(msg []byte) (publicKey string, sig []byte, err error)
+}
+
+// KeySigner is a Signer backed by a base64 Ed25519 private key.
+type KeySigner string
+
+func (k KeySigner) Sign(msg []byte) (string, []byte, error) {
+	pub, err := SigningPublicKey(string(k))
+	if err != nil {
+		return "", nil, err
+	}
+	sig, err := Sign(string(k), msg)
+	if err != nil {
+		return "", nil, err
+	}
+	return pub, sig, nil
+}
+
+// ValidateSigningPublicKey checks that key is a base64 Ed25519 public key.
+func ValidateSigningPublicKey(key string) error {
+	pub, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid signing key %q: want a base64 Ed25519 public key", key)
+	}
+	return nil
+}
+
+func parseSigningKey(privateKey string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("sign: invalid private key")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
@@ -0,0 +1,74 @@
+package envelope
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/thebluefowl/burrow/internal/enc"
+)
+
+// A bundle stores the encrypted data and the sealed envelope of an object in
+// a single storage object, so a bucket listing does not pair envelopes with
+// data objects. Layout:
+//
+//	data || sealed envelope || uint32 big-endian len(sealed envelope) || bundleMagic
+const bundleMagic = "BRWBNDL1"
+
+const bundleFooterSize = 4 + len(bundleMagic)
+
+// BundleKey returns the storage key of the bundle for objectID.
+func BundleKey(objectID string) string {
+	return "bundles/" + objectID
+}
+
+// ObjectIDFromBundleKey is the inverse of BundleKey.
+func ObjectIDFromBundleKey(key string) (string, bool) {
+	id, ok := strings.CutPrefix(key, "bundles/")
+	return id, ok && id != ""
+}
+
+// BundleTrailer signs and seals the envelope and returns the bytes to append
+// to its data object to form a bundle.
+func (e *Envelope) BundleTrailer(recipients []string, signer enc.Signer) ([]byte, error) {
+	if err := e.Sign(signer); err != nil {
+		return nil, fmt.Errorf("failed to sign envelope: %w", err)
+	}
+	sealed, err := e.Seal(recipients, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal envelope: %w", err)
+	}
+
+	trailer := make([]byte, 0, len(sealed)+bundleFooterSize)
+	trailer = append(trailer, sealed...)
+	trailer = binary.BigEndian.AppendUint32(trailer, uint32(len(sealed)))
+	trailer = append(trailer, bundleMagic...)
+	e.bundled = true
+	return trailer, nil
+}
+
+// SplitBundle separates a bundle into its encrypted data and sealed envelope.
+func SplitBundle(b []byte) (data, sealed []byte, err error) {
+	if len(b) < bundleFooterSize {
+		return nil, nil, errors.New("not a burrow bundle")
+	}
+	dataSize, err := parseBundleFooter(b[len(b)-bundleFooterSize:], int64(len(b)))
+	if err != nil {
+		return nil, nil, err
+	}
+	return b[:dataSize], b[dataSize : len(b)-bundleFooterSize], nil
+}
+
+// parseBundleFooter returns the size of the data in a bundle of size bytes
+// ending with footer.
+func parseBundleFooter(footer []byte, size int64) (int64, error) {
+	if len(footer) != bundleFooterSize || string(footer[4:]) != bundleMagic {
+		return 0, errors.New("not a burrow bundle")
+	}
+	n := int64(binary.BigEndian.Uint32(footer))
+	if n > size-int64(bundleFooterSize) {
+		return 0, errors.New("bundle envelope length out of range")
+	}
+	return size - int64(bundleFooterSize) - n, nil
+}
@@ -0,0 +1,194 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/storage"
+)
+
+type memStorage struct {
+	objects map[string][]byte
+}
+
+func (m *memStorage) Upload(_ context.Context, key string, body io.Reader, _ string, _ map[string]string) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	m.objects[key] = b
+	return nil
+}
+
+func (m *memStorage) Download(_ context.Context, key string, w io.Writer) (string, map[string]string, error) {
+	b, ok := m.objects[key]
+	if !ok {
+		return "", nil, storage.ErrNotFound
+	}
+	_, err := w.Write(b)
+	return "", nil, err
+}
+
+func (m *memStorage) GetMetadata(_ context.Context, key string) (map[string]string, error) {
+	if _, ok := m.objects[key]; !ok {
+		return nil, storage.ErrNotFound
+	}
+	return nil, nil
+}
+
+func (m *memStorage) List(_ context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	var out []storage.ObjectInfo
+	for k, v := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, storage.ObjectInfo{Key: k, Size: int64(len(v))})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+// rangeStorage adds ranged downloads to memStorage and counts the bytes it
+// serves.
+type rangeStorage struct {
+	*memStorage
+	served int
+}
+
+func (r *rangeStorage) Download(ctx context.Context, key string, w io.Writer) (string, map[string]string, error) {
+	r.served += len(r.objects[key])
+	return r.memStorage.Download(ctx, key, w)
+}
+
+func (r *rangeStorage) DownloadRange(_ context.Context, key string, offset, length int64, w io.Writer) error {
+	b, ok := r.objects[key]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if offset+length > int64(len(b)) {
+		return io.ErrUnexpectedEOF
+	}
+	r.served += int(length)
+	_, err := w.Write(b[offset : offset+length])
+	return err
+}
+
+func TestBundleFetchAndStore(t *testing.T) {
+	t.Run("download", func(t *testing.T) { testBundleFetchAndStore(t, false) })
+	t.Run("ranged", func(t *testing.T) { testBundleFetchAndStore(t, true) })
+}
+
+func testBundleFetchAndStore(t *testing.T, ranged bool) {
+	ctx := context.Background()
+	pub, priv, err := enc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signPub, signPriv, err := enc.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := enc.DecryptConfig{Identities: []string{priv}}
+	trust := Trust{Signers: []string{signPub}}
+
+	data := bytes.Repeat([]byte("encrypted data"), 1<<16)
+	env := goldenEnvelope(KeyModeWrapped)
+	env.Padding = &Padding{Scheme: PaddingPadme, Length: 10}
+	trailer, err := env.BundleTrailer([]string{pub}, enc.KeySigner(signPriv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := &memStorage{objects: map[string][]byte{
+		BundleKey(goldenObjectID): append(bytes.Clone(data), trailer...),
+	}}
+	rs := &rangeStorage{memStorage: mem}
+	var s storage.Storage = mem
+	if ranged {
+		s = rs
+	}
+
+	// dataOf returns the data of a fetched bundle, as the download does.
+	dataOf := func(env *Envelope, fetched []byte) []byte {
+		if !ranged {
+			return fetched
+		}
+		if fetched != nil {
+			t.Fatal("ranged fetch downloaded the bundle's data")
+		}
+		key, size := env.DataObject()
+		return mem.objects[key][:size]
+	}
+
+	got, gotData, err := FetchObject(ctx, s, goldenObjectID, dec, trust)
+	if err != nil {
+		t.Fatalf("FetchObject() error = %v", err)
+	}
+	if !got.Bundled() || !bytes.Equal(dataOf(got, gotData), data) {
+		t.Fatalf("Bundled() = %v, data of %d bytes", got.Bundled(), len(dataOf(got, gotData)))
+	}
+	if ranged && rs.served > 2*len(trailer) {
+		t.Errorf("fetching the envelope read %d bytes of a %d byte bundle", rs.served, len(mem.objects[BundleKey(goldenObjectID)]))
+	}
+
+	got.Recipients = []string{"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p", pub}
+	if err := got.Store(ctx, s, []string{pub}, enc.KeySigner(signPriv)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if _, ok := mem.objects[Key(goldenObjectID)]; ok {
+		t.Error("Store() of a bundled envelope should not write a separate envelope")
+	}
+
+	again, againData, err := FetchObject(ctx, s, goldenObjectID, dec, trust)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Recipients) != 2 || !bytes.Equal(dataOf(again, againData), data) {
+		t.Error("rewritten bundle lost its envelope changes or data")
+	}
+
+	ids, err := List(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != goldenObjectID {
+		t.Errorf("List() = %v", ids)
+	}
+}
+
+func TestSplitBundleRejects(t *testing.T) {
+	for _, b := range [][]byte{
+		nil,
+		[]byte("data without footer"),
+		append([]byte{0xff, 0xff, 0xff, 0xff}, bundleMagic...),
+	} {
+		if _, _, err := SplitBundle(b); err == nil {
+			t.Errorf("SplitBundle(%q) should fail", b)
+		}
+	}
+}
+
+func TestSealPadsEnvelope(t *testing.T) {
+	pub, _, err := enc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := map[int]bool{}
+	for _, name := range []string{"a", "a-much-longer-file-name.tar"} {
+		env := goldenEnvelope(KeyModeDerived)
+		env.OriginalFileName = name
+		env.Padding = &Padding{Scheme: PaddingPadme, Length: 1}
+		sealed, err := env.Seal([]string{pub}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[len(sealed)] = true
+	}
+	if len(sizes) != 1 {
+		t.Errorf("padded envelopes have different sizes: %v", sizes)
+	}
+}
@@ -0,0 +1,84 @@
+// Package envelope defines the per-object metadata record burrow stores next
+// to every encrypted data object.
+//
+// An envelope lives at keys/<object-id>.envelope and is a JSON document
+// age-encrypted (ASCII armored) to the owner's public key plus any extra
+// recipients. The current schema, version "burrow.2", is:
+//
+//	{
+//	  "version":            "burrow.2",          // required, selects the decoder
+//	  "object_id":          string,              // required, matches the storage key
+//	  "encryption": {
+//	    "mode":             "derived"|"wrapped", // where the data key comes from
+//	    "params": {
+//	      "object_id":      string,              // must equal object_id
+//	      "chunk_size":     int,                 // AEAD plaintext chunk size
+//	      "nonce_base":     hex (24 bytes)       // XChaCha20 nonce prefix
+//	    },
+//	    "data_key":         base64,              // wrapped mode only
+//	    "key_commitment":   base64               // HMAC-SHA256 over the data key
+//	  },
+//	  "compression": {
+//	    "mode":             string               // codec applied before encryption
+//	    "dictionary":       base64               // optional zstd dictionary
+//	    "seekable": {                            // optional, zstd-seekable only
+//	      "frames":         int,
+//	      "table_offset":   int,                 // seek table offset in the compressed stream
+//	      "table_size":     int                  // the table ends the compressed stream
+//	    }
+//	  },
+//	  "plain_sha":          hex (32 bytes),      // SHA-256 of the AEAD plaintext
+//	  "original_file_name": string,
+//	  "kind":               string,              // optional: "file", "directory" or "stream"
+//	  "snapshot": {                              // optional, read from a filesystem snapshot
+//	    "of":               string,              // directory the snapshot was taken of
+//	    "mount":            string,              // where it was mounted while archiving
+//	    "sources":          [string]             // absolute source paths
+//	  },
+//	  "incremental": {                           // optional, changes since another object
+//	    "parent":           string,              // object ID of the previous object
+//	    "deleted":          [string]             // tar paths removed since the parent
+//	  },
+//	  "recipients":         [string],            // optional extra recipients
+//	  "metadata":           {string: string}|null,
+//	  "created_at":         RFC 3339 timestamp,
+//	  "padding": {                               // optional, privacy mode
+//	    "scheme":           "padme",
+//	    "length":           int                  // plaintext length before padding
+//	  },
+//	  "critical":           [string]             // the fields above that change decoding
+//	}
+//
+// "critical" names each optional field set that a reader must understand
+// to decode the object correctly: "kind", "compression.dictionary",
+// "compression.seekable", "snapshot", "incremental" and "padding". Readers
+// reject an envelope whose "critical" lists a field they don't know rather
+// than ignore it, so such fields can be added without a new version.
+//
+// What is age-encrypted is the envelope JSON next to its signature, which
+// covers the envelope's bytes exactly as they are stored there:
+//
+//	{
+//	  "envelope":           {...},               // the envelope above
+//	  "signature": {                             // optional, see Sign
+//	    "key":              base64,              // Ed25519 public key of the signer
+//	    "sig":              base64               // signature over the "envelope" bytes
+//	  }
+//	}
+//
+// A missing "kind" means the object is a tar archive of unknown origin, as
+// written before kinds were recorded.
+//
+// Envelopes normally live next to their data object. In bundle mode the
+// sealed envelope is instead appended to the data object itself, see
+// BundleKey.
+//
+// The older "burrow.1.1" is still readable: it was stored bare, without the
+// signature wrapper, had no key mode and always stored the data key, used Go
+// field names inside "encryption" and "compression" and an untagged
+// CreatedAt, and encoded nonce_base and plain_sha as arrays of byte values.
+// Open dispatches on "version", upgrades the result to the current schema in
+// memory and rejects unknown versions with ErrUnsupportedVersion. Seal always
+// writes the current version; `burrow migrate envelopes` rewrites stored
+// envelopes in place.
+package envelope
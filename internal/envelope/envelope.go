@@ -2,25 +2,51 @@ package envelope
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/thebluefowl/burrow/internal/enc"
 )
 
+// Key modes describe where the data key of an object comes from.
 const (
-	Version1 = "burrow.1.1"
+	// KeyModeDerived derives the data key from the master key and object ID.
+	KeyModeDerived = "derived"
+	// KeyModeWrapped stores the data key in the (age-encrypted) envelope, so
+	// the master key can be rotated without re-encrypting data objects.
+	KeyModeWrapped = "wrapped"
 )
 
 type Encryption struct {
-	Params  enc.AEADParams
-	DataKey []byte
+	Mode          string         `json:"mode"`
+	Params        enc.AEADParams `json:"params"`
+	DataKey       []byte         `json:"data_key,omitempty"`
+	KeyCommitment []byte         `json:"key_commitment"`
 }
 
 type Compression struct {
-	Mode string
+	Mode       string    `json:"mode"`
+	Dictionary []byte    `json:"dictionary,omitempty"`
+	Seekable   *Seekable `json:"seekable,omitempty"`
+}
+
+// Seekable locates the seek table of a zstd-seekable stream, so parts of
+// the object can be read without decompressing what precedes them. The
+// table is the last TableSize bytes of the compressed stream (before any
+// padding), which is TableOffset+TableSize bytes long.
+type Seekable struct {
+	Frames      int   `json:"frames"`
+	TableOffset int64 `json:"table_offset"`
+	TableSize   int64 `json:"table_size"`
+}
+
+// StreamSize returns the length of the compressed stream.
+func (s *Seekable) StreamSize() int64 {
+	return s.TableOffset + s.TableSize
 }
 
 type Envelope struct {
@@ -28,22 +54,212 @@ type Envelope struct {
 	ObjectID         string            `json:"object_id"`
 	Encryption       Encryption        `json:"encryption"`
 	Compression      Compression       `json:"compression"`
-	PlainSHA         [32]byte          `json:"plain_sha"`
+	PlainSHA         Digest            `json:"plain_sha"`
 	OriginalFileName string            `json:"original_file_name"`
+	Kind             string            `json:"kind,omitempty"`
+	Snapshot         *Snapshot         `json:"snapshot,omitempty"`
+	Incremental      *Incremental      `json:"incremental,omitempty"`
+	Recipients       []string          `json:"recipients,omitempty"`
 	Metadata         map[string]string `json:"metadata"`
-	CreatedAt        time.Time
+	CreatedAt        time.Time         `json:"created_at"`
+	Padding          *Padding          `json:"padding,omitempty"`
+	// Critical lists the fields set that change how the object is decoded,
+	// see criticalFields. Sign and Seal fill it in.
+	Critical []string `json:"critical,omitempty"`
+
+	// Signature is stored next to the envelope JSON it signs, see Sign.
+	Signature *Signature `json:"-"`
+
+	// raw is the envelope JSON the signature covers: as read from storage,
+	// or as last signed.
+	raw []byte
+	// readVersion is the version the envelope had in storage, before upgrade.
+	readVersion string
+	// bundled is set for envelopes stored in a bundle with their data.
+	bundled bool
+	// dataSize is the size of the data at the start of a fetched bundle.
+	dataSize int64
+}
+
+// criticalFields are the optional fields that change how an object is
+// decoded: a reader that ignored them would return padding as data, fail to
+// decompress, or restore an increment as a full backup. Each one set is
+// listed in "critical", and readers reject envelopes listing a field they
+// don't know. A field added later that changes decoding goes here, so the
+// version only has to change with the layout itself.
+var criticalFields = []criticalField{
+	{"kind", func(e *Envelope) bool { return e.Kind != "" }},
+	{"compression.dictionary", func(e *Envelope) bool { return len(e.Compression.Dictionary) > 0 }},
+	{"compression.seekable", func(e *Envelope) bool { return e.Compression.Seekable != nil }},
+	{"snapshot", func(e *Envelope) bool { return e.Snapshot != nil }},
+	{"incremental", func(e *Envelope) bool { return e.Incremental != nil }},
+	{"padding", func(e *Envelope) bool { return e.Padding != nil }},
+}
+
+type criticalField struct {
+	name string
+	set  func(e *Envelope) bool
+}
+
+// setCritical lists the critical fields that are set.
+func (e *Envelope) setCritical() {
+	e.Critical = nil
+	for _, f := range criticalFields {
+		if f.set(e) {
+			e.Critical = append(e.Critical, f.name)
+		}
+	}
+}
+
+// Kinds describe what an object was uploaded from. Envelopes written before
+// kinds were recorded have none and hold a tar archive.
+const (
+	// KindFile objects hold a tar archive of a single regular file.
+	KindFile = "file"
+	// KindDir objects hold a tar archive of a directory or of several paths.
+	KindDir = "directory"
+	// KindStream objects hold a raw byte stream (e.g. read from stdin)
+	// instead of a tar archive.
+	KindStream = "stream"
+)
+
+// Snapshot records that an archive was read from a filesystem snapshot
+// rather than from the live sources.
+type Snapshot struct {
+	// Of is the directory the snapshot was taken of.
+	Of string `json:"of"`
+	// Mount is where the snapshot was mounted while it was archived.
+	Mount string `json:"mount"`
+	// Sources are the absolute paths that were uploaded.
+	Sources []string `json:"sources"`
+}
+
+// Incremental records that an archive holds only the entries that changed
+// since another object. Restoring it means extracting the chain from its
+// first object on, removing each increment's deleted paths before
+// extracting it.
+type Incremental struct {
+	// Parent is the object ID of the previous object of the chain.
+	Parent string `json:"parent"`
+	// Deleted are the tar paths removed since the parent.
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+// PaddingPadme pads the AEAD plaintext to the next Padmé size.
+const PaddingPadme = "padme"
+
+// sealPadBlock is the block size sealed envelopes of padded objects are
+// rounded up to, so their size does not reveal the file name length.
+const sealPadBlock = 1024
+
+// Padding records how the AEAD plaintext was padded. Length is the size of
+// the compressed stream before padding.
+type Padding struct {
+	Scheme string `json:"scheme"`
+	Length int64  `json:"length"`
+}
+
+// Digest is a SHA-256 sum. It serializes as hex.
+type Digest [32]byte
+
+func (d Digest) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(d[:])), nil
+}
+
+func (d *Digest) UnmarshalText(b []byte) error {
+	if hex.DecodedLen(len(b)) != len(d) {
+		return fmt.Errorf("digest must be %d bytes", len(d))
+	}
+	_, err := hex.Decode(d[:], b)
+	return err
 }
 
 func NewEnvelope(objectID string, original string) *Envelope {
 	return &Envelope{
-		Version:          Version1,
+		Version:          CurrentVersion,
 		ObjectID:         objectID,
 		OriginalFileName: original,
 	}
 }
 
+// Key returns the storage key of the envelope for objectID.
+func Key(objectID string) string {
+	return "keys/" + objectID + ".envelope"
+}
+
+// DataObject returns the storage key of the object's encrypted data. For a
+// bundle fetched from storage it also returns the size of the data at the
+// start of the bundle; otherwise size is -1 and the data is the whole object.
+func (e *Envelope) DataObject() (key string, size int64) {
+	if e.bundled && e.dataSize > 0 {
+		return BundleKey(e.ObjectID), e.dataSize
+	}
+	return "data/" + e.ObjectID + ".enc", -1
+}
+
+// ObjectIDFromKey is the inverse of Key. It reports false for keys that are
+// not envelopes (e.g. the repository key).
+func ObjectIDFromKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, "keys/") || !strings.HasSuffix(key, ".envelope") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(key, "keys/"), ".envelope"), true
+}
+
+// SetDerivedKey puts the envelope in derived mode: only a commitment to
+// dataKey is stored, and the key is re-derived from the master key on download.
+func (e *Envelope) SetDerivedKey(dataKey []byte) {
+	e.Encryption.Mode = KeyModeDerived
+	e.Encryption.DataKey = nil
+	e.Encryption.KeyCommitment = enc.KeyCommitment(dataKey, e.ObjectID)
+}
+
+// Wrap stores dataKey in the envelope and switches it to wrapped mode.
+func (e *Envelope) Wrap(dataKey []byte) {
+	e.Encryption.Mode = KeyModeWrapped
+	e.Encryption.DataKey = dataKey
+	e.Encryption.KeyCommitment = enc.KeyCommitment(dataKey, e.ObjectID)
+}
+
+// KeyDeriver derives data keys from the master key, which may be held
+// locally or by the agent.
+type KeyDeriver interface {
+	DeriveDataKey(objectID string) ([]byte, error)
+}
+
+// ResolveDataKey returns the key that encrypts the data object: the stored
+// key in wrapped mode, or the key derived by keys in derived mode. The key is
+// checked against the stored commitment before it is returned.
+func (e *Envelope) ResolveDataKey(keys KeyDeriver) ([]byte, error) {
+	var dataKey []byte
+	switch e.Encryption.Mode {
+	case KeyModeWrapped:
+		if len(e.Encryption.DataKey) == 0 {
+			return nil, fmt.Errorf("wrapped envelope %s has no data key", e.ObjectID)
+		}
+		dataKey = e.Encryption.DataKey
+	case KeyModeDerived:
+		k, err := keys.DeriveDataKey(e.ObjectID)
+		if err != nil {
+			return nil, err
+		}
+		dataKey = k
+	default:
+		return nil, fmt.Errorf("unknown key mode %q", e.Encryption.Mode)
+	}
+
+	if !enc.VerifyKeyCommitment(dataKey, e.ObjectID, e.Encryption.KeyCommitment) {
+		if e.Encryption.Mode == KeyModeDerived {
+			return nil, fmt.Errorf("derived key for %s does not match its commitment (wrong master key?)", e.ObjectID)
+		}
+		return nil, fmt.Errorf("data key for %s does not match its commitment", e.ObjectID)
+	}
+	return dataKey, nil
+}
+
+// Seal marshals the envelope in CurrentVersion and age-encrypts it to recipients.
 func (e *Envelope) Seal(recipients []string, armor bool) ([]byte, error) {
-	raw, err := json.Marshal(e)
+	raw, err := e.plaintext()
 	if err != nil {
 		return nil, err
 	}
@@ -55,6 +271,42 @@ func (e *Envelope) Seal(recipients []string, armor bool) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// plaintext returns what Seal encrypts: the envelope JSON and its signature,
+// side by side so that the signature covers the exact bytes stored:
+//
+//	{"envelope":{...},"signature":{...}}
+func (e *Envelope) plaintext() ([]byte, error) {
+	e.setCritical()
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	if e.Signature != nil && !bytes.Equal(body, e.raw) {
+		return nil, fmt.Errorf("envelope %s was modified after it was signed", e.ObjectID)
+	}
+
+	raw := append([]byte(`{"envelope":`), body...)
+	if e.Signature != nil {
+		sig, err := json.Marshal(e.Signature)
+		if err != nil {
+			return nil, err
+		}
+		raw = append(append(raw, `,"signature":`...), sig...)
+	}
+	raw = append(raw, '}')
+	if e.Padding != nil {
+		// Trailing whitespace is valid JSON and is not covered by the signature.
+		pad := (sealPadBlock - len(raw)%sealPadBlock) % sealPadBlock
+		raw = append(raw, bytes.Repeat([]byte{' '}, pad)...)
+	}
+	return raw, nil
+}
+
+// Open decrypts an envelope, dispatches on its version and upgrades it to
+// CurrentVersion in memory.
 func (e *Envelope) Open(cipher []byte, dec enc.DecryptConfig) (*Envelope, error) {
 	r, err := enc.NewDecryptReader(bytes.NewReader(cipher), dec)
 	if err != nil {
@@ -64,9 +316,21 @@ func (e *Envelope) Open(cipher []byte, dec enc.DecryptConfig) (*Envelope, error)
 	if err != nil {
 		return nil, err
 	}
-	var env Envelope
-	if err := json.Unmarshal(b, &env); err != nil {
-		return nil, err
-	}
-	return &env, nil
+	return decode(b)
+}
+
+// NeedsMigration reports whether the envelope was stored in an older
+// version and should be re-sealed in CurrentVersion.
+func (e *Envelope) NeedsMigration() bool {
+	return e.readVersion != "" && e.readVersion != CurrentVersion
+}
+
+// Bundled reports whether the envelope is stored in a bundle with its data.
+func (e *Envelope) Bundled() bool {
+	return e.bundled
+}
+
+// ReadVersion returns the version the envelope had in storage.
+func (e *Envelope) ReadVersion() string {
+	return e.readVersion
 }
@@ -0,0 +1,232 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/thebluefowl/burrow/internal/enc"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+const goldenObjectID = "2ZqJ4bXvQ0TtEa4cY8m1qN3s7Kd"
+
+// goldenEnvelope returns a fully populated envelope with fixed values.
+func goldenEnvelope(mode string) *Envelope {
+	env := NewEnvelope(goldenObjectID, "report.pdf")
+	env.Encryption.Params = enc.AEADParams{
+		ObjectID:  goldenObjectID,
+		ChunkSize: enc.AEADDefaultChunkSize,
+		NBase:     [24]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24},
+	}
+	dataKey := bytes.Repeat([]byte{0x42}, 32)
+	if mode == KeyModeWrapped {
+		env.Wrap(dataKey)
+		env.Recipients = []string{"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"}
+	} else {
+		env.SetDerivedKey(dataKey)
+	}
+	env.Compression.Mode = "zstd"
+	env.PlainSHA = [32]byte{0xde, 0xad, 0xbe, 0xef}
+	env.CreatedAt = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	return env
+}
+
+// fullEnvelope returns a golden envelope with every optional field set.
+func fullEnvelope() *Envelope {
+	env := goldenEnvelope(KeyModeWrapped)
+	env.Kind = KindDir
+	env.Compression = Compression{
+		Mode:       "zstd-seekable",
+		Dictionary: []byte("dict"),
+		Seekable:   &Seekable{Frames: 2, TableOffset: 1000, TableSize: 33},
+	}
+	env.Snapshot = &Snapshot{Of: "/home", Mount: "/home/.snap", Sources: []string{"/home/alice"}}
+	env.Incremental = &Incremental{Parent: "1ZqJ4bXvQ0TtEa4cY8m1qN3s7Kd", Deleted: []string{"alice/old.txt"}}
+	env.Metadata = map[string]string{"job": "home"}
+	env.Padding = &Padding{Scheme: PaddingPadme, Length: 1033}
+	env.setCritical()
+	return env
+}
+
+func TestGoldenCurrentVersion(t *testing.T) {
+	for name, newEnv := range map[string]func() *Envelope{
+		KeyModeDerived: func() *Envelope { return goldenEnvelope(KeyModeDerived) },
+		KeyModeWrapped: func() *Envelope { return goldenEnvelope(KeyModeWrapped) },
+		"full":         fullEnvelope,
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := json.MarshalIndent(newEnv(), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join("testdata", CurrentVersion+"-"+name+".json")
+			if *update {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read golden (run with -update to create): %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("marshaled envelope does not match %s:\n%s", path, got)
+			}
+
+			decoded, err := decode(want)
+			if err != nil {
+				t.Fatalf("decode() error = %v", err)
+			}
+			if decoded.NeedsMigration() {
+				t.Error("current version should not need migration")
+			}
+			decoded.readVersion = ""
+			if !equalJSON(t, decoded, newEnv()) {
+				t.Error("decoded envelope differs from original")
+			}
+		})
+	}
+}
+
+func TestDecodeLegacyVersions(t *testing.T) {
+	tests := []struct {
+		file     string
+		wantMode string
+		wantKey  bool
+	}{
+		{"burrow.1.1.json", KeyModeWrapped, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			raw, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			env, err := decode(raw)
+			if err != nil {
+				t.Fatalf("decode() error = %v", err)
+			}
+			if env.Version != CurrentVersion {
+				t.Errorf("Version = %q, want %q", env.Version, CurrentVersion)
+			}
+			if !env.NeedsMigration() {
+				t.Error("legacy envelope should need migration")
+			}
+			if env.Encryption.Mode != tt.wantMode {
+				t.Errorf("Mode = %q, want %q", env.Encryption.Mode, tt.wantMode)
+			}
+			if (len(env.Encryption.DataKey) > 0) != tt.wantKey {
+				t.Errorf("DataKey present = %v, want %v", len(env.Encryption.DataKey) > 0, tt.wantKey)
+			}
+			if env.Encryption.Params.ChunkSize != enc.AEADDefaultChunkSize || env.Encryption.Params.NBase[0] != 1 {
+				t.Errorf("params not decoded: %+v", env.Encryption.Params)
+			}
+			if env.Compression.Mode != "zstd" {
+				t.Errorf("Compression.Mode = %q, want zstd", env.Compression.Mode)
+			}
+			if env.CreatedAt.IsZero() {
+				t.Error("CreatedAt not decoded")
+			}
+			if tt.wantKey {
+				if _, err := env.ResolveDataKey(nil); err != nil {
+					t.Errorf("ResolveDataKey() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	tests := []struct {
+		name        string
+		json        string
+		wantVersion bool
+	}{
+		{"unknown version", `{"version":"burrow.99","object_id":"x"}`, true},
+		{"development version", `{"version":"burrow.3","object_id":"x"}`, true},
+		{"missing version", `{"object_id":"x"}`, true},
+		{"not json", `nope`, false},
+		{"v1 without key", `{"version":"burrow.1.1","object_id":"x","encryption":{"Params":{"ObjectID":"x","ChunkSize":4194304}}}`, false},
+		{"derived with key", `{"version":"burrow.2","object_id":"x","encryption":{"mode":"derived","params":{"object_id":"x","chunk_size":4194304},"data_key":"AAAA","key_commitment":"AAAA"}}`, false},
+		{"unknown critical field", `{"version":"burrow.2","object_id":"x","encryption":{"mode":"derived","params":{"object_id":"x","chunk_size":4194304},"key_commitment":"AAAA"},"critical":["hologram"]}`, true},
+		{"params mismatch", `{"version":"burrow.2","object_id":"x","encryption":{"mode":"derived","params":{"object_id":"y","chunk_size":4194304},"key_commitment":"AAAA"}}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decode([]byte(tt.json))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if got := errors.Is(err, ErrUnsupportedVersion); got != tt.wantVersion {
+				t.Errorf("errors.Is(ErrUnsupportedVersion) = %v, want %v (err = %v)", got, tt.wantVersion, err)
+			}
+		})
+	}
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	pub, priv, err := enc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orig := goldenEnvelope(KeyModeWrapped)
+	sealed, err := orig.Seal([]string{pub}, true)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	var env Envelope
+	opened, err := env.Open(sealed, enc.DecryptConfig{Identities: []string{priv}})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if opened.ReadVersion() != CurrentVersion {
+		t.Errorf("ReadVersion() = %q, want %q", opened.ReadVersion(), CurrentVersion)
+	}
+	opened.readVersion = ""
+	if !equalJSON(t, opened, orig) {
+		t.Error("opened envelope differs from sealed one")
+	}
+}
+
+func equalJSON(t *testing.T, a, b *Envelope) bool {
+	t.Helper()
+	ja, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Equal(ja, jb)
+}
+
+func TestSealListsCriticalFields(t *testing.T) {
+	env := goldenEnvelope(KeyModeDerived)
+	env.Kind = KindStream
+	plain, err := env.plaintext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decode(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Critical) != 1 || decoded.Critical[0] != "kind" {
+		t.Errorf("Critical = %q, want [kind]", decoded.Critical)
+	}
+}
@@ -0,0 +1,77 @@
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/thebluefowl/burrow/internal/enc"
+)
+
+var (
+	// ErrUnsigned is returned when an envelope carries no signature.
+	ErrUnsigned = errors.New("envelope is not signed")
+	// ErrUntrustedSigner is returned when an envelope is signed by a key that
+	// is not in the trust list.
+	ErrUntrustedSigner = errors.New("envelope signer is not trusted")
+)
+
+// Signature authenticates an envelope. age only provides confidentiality:
+// anyone who knows the public key can seal a valid envelope, so downloads
+// require a signature from a trusted Ed25519 key.
+type Signature struct {
+	Key string `json:"key"`
+	Sig []byte `json:"sig"`
+}
+
+// Trust selects which envelopes Fetch accepts.
+type Trust struct {
+	// Signers are the base64 Ed25519 public keys whose signatures are accepted.
+	Signers []string
+	// AllowUnsigned accepts envelopes without a signature, e.g. ones written
+	// before signing was introduced. Signed envelopes are always verified.
+	AllowUnsigned bool
+}
+
+// Sign signs the envelope with signer, replacing any previous signature.
+// The signature covers the JSON encoding of the envelope, which Seal stores
+// byte for byte next to it, so it keeps verifying when later versions add
+// fields or are upgraded in memory.
+func (e *Envelope) Sign(signer enc.Signer) error {
+	e.setCritical()
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	pub, sig, err := signer.Sign(msg)
+	if err != nil {
+		return err
+	}
+	e.Signature = &Signature{Key: pub, Sig: sig}
+	e.raw = msg
+	return nil
+}
+
+// Verify checks the envelope signature against trust. The signature is
+// checked against the envelope JSON as stored, not as upgraded in memory.
+func (e *Envelope) Verify(trust Trust) error {
+	if e.Signature == nil {
+		if trust.AllowUnsigned {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrUnsigned, e.ObjectID)
+	}
+	if !slices.Contains(trust.Signers, e.Signature.Key) {
+		return fmt.Errorf("%w: %s signed by %s", ErrUntrustedSigner, e.ObjectID, e.Signature.Key)
+	}
+	if !enc.VerifySignature(e.Signature.Key, e.raw, e.Signature.Sig) {
+		return fmt.Errorf("envelope %s has an invalid signature", e.ObjectID)
+	}
+	return nil
+}
+
+// Signed reports whether the envelope carries a signature.
+func (e *Envelope) Signed() bool {
+	return e.Signature != nil
+}
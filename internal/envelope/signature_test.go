@@ -0,0 +1,155 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/thebluefowl/burrow/internal/enc"
+)
+
+func TestSignVerify(t *testing.T) {
+	pub, priv, err := enc.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, otherPriv, err := enc.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := func(key string) *Envelope {
+		env := goldenEnvelope(KeyModeDerived)
+		if err := env.Sign(enc.KeySigner(key)); err != nil {
+			t.Fatal(err)
+		}
+		return env
+	}
+
+	// The stored bytes are changed, not the envelope in memory.
+	plain, err := signed(priv).plaintext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered, err := decode(bytes.Replace(plain, []byte("deadbeef"), []byte("deadbeee"), 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     *Envelope
+		trust   Trust
+		wantErr error
+		wantOK  bool
+	}{
+		{"trusted", signed(priv), Trust{Signers: []string{pub}}, nil, true},
+		{"second trusted signer", signed(otherPriv), Trust{Signers: []string{pub, otherPub}}, nil, true},
+		{"untrusted", signed(otherPriv), Trust{Signers: []string{pub}}, ErrUntrustedSigner, false},
+		{"untrusted despite allow unsigned", signed(otherPriv), Trust{Signers: []string{pub}, AllowUnsigned: true}, ErrUntrustedSigner, false},
+		{"tampered", tampered, Trust{Signers: []string{pub}}, nil, false},
+		{"unsigned", goldenEnvelope(KeyModeDerived), Trust{Signers: []string{pub}}, ErrUnsigned, false},
+		{"unsigned allowed", goldenEnvelope(KeyModeDerived), Trust{Signers: []string{pub}, AllowUnsigned: true}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.env.Verify(tt.trust)
+			if tt.wantOK {
+				if err != nil {
+					t.Fatalf("Verify() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignatureSurvivesSealOpen(t *testing.T) {
+	pub, priv, err := enc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signPub, signPriv, err := enc.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := goldenEnvelope(KeyModeWrapped)
+	if err := env.Sign(enc.KeySigner(signPriv)); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := env.Seal([]string{pub}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e Envelope
+	opened, err := e.Open(sealed, enc.DecryptConfig{Identities: []string{priv}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opened.Verify(Trust{Signers: []string{signPub}}); err != nil {
+		t.Errorf("Verify() after Open error = %v", err)
+	}
+}
+
+func TestSignatureCoversStoredBytes(t *testing.T) {
+	pub, priv, err := enc.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An envelope written by a later build, with a field this one drops.
+	var fields map[string]any
+	golden, err := json.Marshal(goldenEnvelope(KeyModeDerived))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(golden, &fields); err != nil {
+		t.Fatal(err)
+	}
+	fields["future"] = "ignored"
+	body, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, sig, err := enc.KeySigner(priv).Sign(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := json.Marshal(map[string]any{"envelope": json.RawMessage(body), "signature": Signature{Key: key, Sig: sig}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := decode(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Verify(Trust{Signers: []string{pub}}); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+}
+
+func TestSealRejectsChangesAfterSigning(t *testing.T) {
+	_, priv, err := enc.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := goldenEnvelope(KeyModeDerived)
+	if err := env.Sign(enc.KeySigner(priv)); err != nil {
+		t.Fatal(err)
+	}
+	env.OriginalFileName = "other.pdf"
+	if _, err := env.plaintext(); err == nil {
+		t.Error("plaintext() of an envelope changed after signing succeeded")
+	}
+}
@@ -0,0 +1,207 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/storage"
+)
+
+// Fetch downloads and opens the envelope of objectID and verifies its
+// signature against trust. Envelopes stored in a bundle are found as well.
+func Fetch(ctx context.Context, s storage.Storage, objectID string, dec enc.DecryptConfig, trust Trust) (*Envelope, error) {
+	env, _, err := FetchObject(ctx, s, objectID, dec, trust)
+	return env, err
+}
+
+// FetchObject is like Fetch, but for bundled objects it also returns the
+// encrypted data when it had to be downloaded with the envelope. That is
+// only the case for backends without ranged downloads; otherwise just the
+// envelope is read from the end of the bundle, the returned data is nil and
+// the data is found with DataObject.
+func FetchObject(ctx context.Context, s storage.Storage, objectID string, dec enc.DecryptConfig, trust Trust) (*Envelope, []byte, error) {
+	key := Key(objectID)
+
+	var buf bytes.Buffer
+	_, _, err := s.Download(ctx, key, &buf)
+	if errors.Is(err, storage.ErrNotFound) {
+		return fetchBundle(ctx, s, objectID, dec, trust)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("download envelope %s: %w", key, err)
+	}
+
+	env, err := open(key, objectID, buf.Bytes(), dec, trust)
+	return env, nil, err
+}
+
+func fetchBundle(ctx context.Context, s storage.Storage, objectID string, dec enc.DecryptConfig, trust Trust) (*Envelope, []byte, error) {
+	key := BundleKey(objectID)
+
+	var data, sealed []byte
+	var dataSize int64
+	if rd, ok := s.(storage.RangeDownloader); ok {
+		var err error
+		if sealed, dataSize, err = readBundleTrailer(ctx, s, rd, key); err != nil {
+			return nil, nil, fmt.Errorf("download envelope %s: %w", Key(objectID), err)
+		}
+	} else {
+		var buf bytes.Buffer
+		if _, _, err := s.Download(ctx, key, &buf); err != nil {
+			return nil, nil, fmt.Errorf("download envelope %s: %w", Key(objectID), err)
+		}
+		var err error
+		if data, sealed, err = SplitBundle(buf.Bytes()); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", key, err)
+		}
+		dataSize = int64(len(data))
+	}
+
+	env, err := open(key, objectID, sealed, dec, trust)
+	if err != nil {
+		return nil, nil, err
+	}
+	env.bundled = true
+	env.dataSize = dataSize
+	return env, data, nil
+}
+
+// readBundleTrailer reads the sealed envelope from the end of a bundle
+// without downloading its data, and returns it with the size of the data.
+func readBundleTrailer(ctx context.Context, s storage.Storage, rd storage.RangeDownloader, key string) (sealed []byte, dataSize int64, err error) {
+	objects, err := s.List(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	i := slices.IndexFunc(objects, func(o storage.ObjectInfo) bool { return o.Key == key })
+	if i < 0 {
+		return nil, 0, fmt.Errorf("%s: %w", key, storage.ErrNotFound)
+	}
+	size := objects[i].Size
+	if size < int64(bundleFooterSize) {
+		return nil, 0, fmt.Errorf("%s: not a burrow bundle", key)
+	}
+
+	var footer bytes.Buffer
+	if err := rd.DownloadRange(ctx, key, size-int64(bundleFooterSize), int64(bundleFooterSize), &footer); err != nil {
+		return nil, 0, err
+	}
+	if dataSize, err = parseBundleFooter(footer.Bytes(), size); err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", key, err)
+	}
+	n := size - int64(bundleFooterSize) - dataSize
+	var buf bytes.Buffer
+	if n > 0 {
+		if err := rd.DownloadRange(ctx, key, dataSize, n, &buf); err != nil {
+			return nil, 0, err
+		}
+	}
+	return buf.Bytes(), dataSize, nil
+}
+
+// open decrypts a sealed envelope read from key and checks that it belongs
+// to objectID and is trusted.
+func open(key, objectID string, sealed []byte, dec enc.DecryptConfig, trust Trust) (*Envelope, error) {
+	var env Envelope
+	opened, err := env.Open(sealed, dec)
+	if err != nil {
+		return nil, fmt.Errorf("open envelope %s: %w", key, err)
+	}
+	if opened.ObjectID != objectID {
+		return nil, fmt.Errorf("envelope %s describes object %s", key, opened.ObjectID)
+	}
+	if err := opened.Verify(trust); err != nil {
+		return nil, err
+	}
+	return opened, nil
+}
+
+// Store signs the envelope with signer, seals it to recipients and
+// uploads it, replacing any previous envelope for the same object. A bundled
+// envelope is replaced by rewriting its bundle.
+func (e *Envelope) Store(ctx context.Context, s storage.Storage, recipients []string, signer enc.Signer) error {
+	if e.bundled {
+		return e.storeBundle(ctx, s, recipients, signer)
+	}
+
+	if err := e.Sign(signer); err != nil {
+		return fmt.Errorf("failed to sign envelope: %w", err)
+	}
+	sealed, err := e.Seal(recipients, true)
+	if err != nil {
+		return fmt.Errorf("failed to seal envelope: %w", err)
+	}
+
+	key := Key(e.ObjectID)
+	if err := s.Upload(ctx, key, bytes.NewReader(sealed), "application/octet-stream", nil); err != nil {
+		return fmt.Errorf("failed to upload envelope: %w", err)
+	}
+	return nil
+}
+
+// storeBundle replaces the trailer of a bundle. Storage objects can't be
+// changed in place, so the whole bundle is rewritten, its data included;
+// with ranged downloads the data is streamed from the old bundle into the
+// new one rather than held in memory.
+func (e *Envelope) storeBundle(ctx context.Context, s storage.Storage, recipients []string, signer enc.Signer) error {
+	key := BundleKey(e.ObjectID)
+
+	trailer, err := e.BundleTrailer(recipients, signer)
+	if err != nil {
+		return err
+	}
+
+	var data io.Reader
+	rd, ok := s.(storage.RangeDownloader)
+	if ok && e.dataSize > 0 {
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		go func() {
+			pw.CloseWithError(rd.DownloadRange(ctx, key, 0, e.dataSize, pw))
+		}()
+		data = pr
+	} else {
+		var buf bytes.Buffer
+		if _, _, err := s.Download(ctx, key, &buf); err != nil {
+			return fmt.Errorf("download bundle %s: %w", key, err)
+		}
+		b, _, err := SplitBundle(buf.Bytes())
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		data = bytes.NewReader(b)
+	}
+
+	body := io.MultiReader(data, bytes.NewReader(trailer))
+	if err := s.Upload(ctx, key, body, "application/octet-stream", nil); err != nil {
+		return fmt.Errorf("failed to upload bundle: %w", err)
+	}
+	return nil
+}
+
+// List returns the IDs of all objects in the repository that have an
+// envelope, whether stored separately or in a bundle.
+func List(ctx context.Context, s storage.Storage) ([]string, error) {
+	var ids []string
+	for _, prefix := range []string{"keys/", "bundles/"} {
+		objects, err := s.List(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", prefix, err)
+		}
+		for _, obj := range objects {
+			id, ok := ObjectIDFromKey(obj.Key)
+			if !ok {
+				id, ok = ObjectIDFromBundleKey(obj.Key)
+			}
+			if ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,192 @@
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/thebluefowl/burrow/internal/enc"
+)
+
+const (
+	// Version1 envelopes always carry the data key, even though it is derived
+	// from the master key. They are upgraded to wrapped mode when opened.
+	Version1 = "burrow.1.1"
+	// Version2 is the documented schema (see doc.go). Envelopes declare their
+	// key mode: derived envelopes store only a key commitment, wrapped
+	// envelopes store a random per-object data key.
+	Version2 = "burrow.2"
+
+	CurrentVersion = Version2
+)
+
+// ErrUnsupportedVersion is returned when an envelope declares a version this
+// build does not know how to read.
+var ErrUnsupportedVersion = errors.New("unsupported envelope version")
+
+// decode parses a plaintext envelope, dispatching on its version, and
+// returns a validated envelope upgraded to CurrentVersion. b is either the
+// envelope JSON and its signature as written by Seal, or the bare envelope
+// JSON of an unsigned Version1 envelope.
+func decode(b []byte) (*Envelope, error) {
+	var sealed struct {
+		Envelope  json.RawMessage `json:"envelope"`
+		Signature *Signature      `json:"signature"`
+	}
+	if err := json.Unmarshal(b, &sealed); err != nil {
+		return nil, fmt.Errorf("decode envelope: %w", err)
+	}
+	if sealed.Envelope != nil {
+		b = sealed.Envelope
+	}
+
+	var header struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(b, &header); err != nil {
+		return nil, fmt.Errorf("decode envelope header: %w", err)
+	}
+
+	var env *Envelope
+	switch header.Version {
+	case Version2:
+		env = &Envelope{}
+		if err := json.Unmarshal(b, env); err != nil {
+			return nil, fmt.Errorf("decode %s envelope: %w", header.Version, err)
+		}
+	case Version1:
+		var legacy legacyEnvelope
+		if err := json.Unmarshal(b, &legacy); err != nil {
+			return nil, fmt.Errorf("decode %s envelope: %w", header.Version, err)
+		}
+		var err error
+		if env, err = legacy.upgrade(); err != nil {
+			return nil, err
+		}
+	case "":
+		return nil, fmt.Errorf("%w: missing version", ErrUnsupportedVersion)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedVersion, header.Version)
+	}
+
+	env.Signature = sealed.Signature
+	env.raw = b
+	env.readVersion = header.Version
+	env.Version = CurrentVersion
+	if err := env.Validate(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// Validate checks the envelope against the CurrentVersion schema.
+func (e *Envelope) Validate() error {
+	if e.Version != CurrentVersion {
+		return fmt.Errorf("%w %q", ErrUnsupportedVersion, e.Version)
+	}
+	if e.ObjectID == "" {
+		return errors.New("envelope: object_id is required")
+	}
+	for _, name := range e.Critical {
+		if !slices.ContainsFunc(criticalFields, func(f criticalField) bool { return f.name == name }) {
+			return fmt.Errorf("%w: envelope %s needs %q", ErrUnsupportedVersion, e.ObjectID, name)
+		}
+	}
+	if e.Encryption.Params.ObjectID != e.ObjectID {
+		return fmt.Errorf("envelope %s: params are for object %q", e.ObjectID, e.Encryption.Params.ObjectID)
+	}
+	if e.Encryption.Params.ChunkSize <= 0 {
+		return fmt.Errorf("envelope %s: invalid chunk_size %d", e.ObjectID, e.Encryption.Params.ChunkSize)
+	}
+
+	switch e.Encryption.Mode {
+	case KeyModeDerived:
+		if len(e.Encryption.DataKey) > 0 {
+			return fmt.Errorf("derived envelope %s must not store a data key", e.ObjectID)
+		}
+	case KeyModeWrapped:
+		if len(e.Encryption.DataKey) == 0 {
+			return fmt.Errorf("wrapped envelope %s has no data key", e.ObjectID)
+		}
+	default:
+		return fmt.Errorf("envelope %s: unknown key mode %q", e.ObjectID, e.Encryption.Mode)
+	}
+	if len(e.Encryption.KeyCommitment) == 0 {
+		return fmt.Errorf("envelope %s has no key commitment", e.ObjectID)
+	}
+	switch e.Kind {
+	case "", KindFile, KindDir, KindStream:
+	default:
+		return fmt.Errorf("envelope %s: unknown kind %q", e.ObjectID, e.Kind)
+	}
+	if e.Padding != nil {
+		if e.Padding.Scheme != PaddingPadme {
+			return fmt.Errorf("envelope %s: unknown padding scheme %q", e.ObjectID, e.Padding.Scheme)
+		}
+		if e.Padding.Length < 0 {
+			return fmt.Errorf("envelope %s: invalid padding length %d", e.ObjectID, e.Padding.Length)
+		}
+	}
+	if sk := e.Compression.Seekable; sk != nil {
+		if sk.Frames < 0 || sk.TableOffset < 0 || sk.TableSize <= 0 {
+			return fmt.Errorf("envelope %s: invalid seek table location", e.ObjectID)
+		}
+		if e.Padding != nil && sk.StreamSize() != e.Padding.Length {
+			return fmt.Errorf("envelope %s: seek table does not end the stream", e.ObjectID)
+		}
+	}
+	return nil
+}
+
+// legacyEnvelope is the Version1 layout: nested structs were serialized
+// with Go field names and CreatedAt had no tag.
+type legacyEnvelope struct {
+	Version    string `json:"version"`
+	ObjectID   string `json:"object_id"`
+	Encryption struct {
+		Params struct {
+			ObjectID  string
+			ChunkSize int
+			NBase     [24]byte
+		}
+		DataKey []byte
+	} `json:"encryption"`
+	Compression struct {
+		Mode string
+	} `json:"compression"`
+	PlainSHA         [32]byte          `json:"plain_sha"`
+	OriginalFileName string            `json:"original_file_name"`
+	Recipients       []string          `json:"recipients"`
+	Metadata         map[string]string `json:"metadata"`
+	CreatedAt        time.Time
+}
+
+// upgrade converts a legacy envelope to the CurrentVersion model. Version1
+// envelopes stored the data key alongside a derived-key scheme; the stored
+// key is kept, so they become wrapped envelopes.
+func (l *legacyEnvelope) upgrade() (*Envelope, error) {
+	if len(l.Encryption.DataKey) == 0 {
+		return nil, fmt.Errorf("%s envelope %s has no data key", Version1, l.ObjectID)
+	}
+	env := &Envelope{
+		Version:  CurrentVersion,
+		ObjectID: l.ObjectID,
+		Encryption: Encryption{
+			Params: enc.AEADParams{
+				ObjectID:  l.Encryption.Params.ObjectID,
+				ChunkSize: l.Encryption.Params.ChunkSize,
+				NBase:     l.Encryption.Params.NBase,
+			},
+		},
+		Compression:      Compression{Mode: l.Compression.Mode},
+		PlainSHA:         l.PlainSHA,
+		OriginalFileName: l.OriginalFileName,
+		Recipients:       l.Recipients,
+		Metadata:         l.Metadata,
+		CreatedAt:        l.CreatedAt,
+	}
+	env.Wrap(l.Encryption.DataKey)
+	return env, nil
+}
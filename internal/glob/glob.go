@@ -0,0 +1,202 @@
+// Package glob matches slash-separated paths against gitignore-style
+// patterns. It is shared by upload exclusion (--exclude, .burrowignore) and
+// selective extraction on download.
+//
+// A pattern is a sequence of segments separated by "/". Within a segment,
+// "*" matches any run of characters, "?" one character and "[...]" a
+// character class, as in path.Match; none of them match "/". A segment of
+// "**" matches zero or more whole segments, so "src/**/test/*.go" matches
+// "src/test/a.go" and "src/x/y/test/a.go", while a trailing "/**" matches
+// everything below a directory but not the directory itself.
+//
+// A pattern containing "/" before its last character is anchored: it is
+// matched against the whole path, and a leading "/" is only a marker. Any
+// other pattern floats: it is matched against the last segment at any depth.
+// A trailing "/" restricts a pattern to directories, and a leading "!"
+// negates it, re-including what earlier patterns of a List matched. A
+// backslash escapes the following character, so "\!x" matches a literal
+// "!x".
+package glob
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Options tune how patterns are compiled.
+type Options struct {
+	// CaseInsensitive matches without regard to letter case.
+	CaseInsensitive bool
+}
+
+// Pattern is a compiled pattern.
+type Pattern struct {
+	raw      string
+	segs     []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+	fold     bool
+}
+
+// Compile parses a pattern. It fails on malformed character classes and on
+// patterns that match nothing, such as "" or "!".
+func Compile(pattern string, opts Options) (*Pattern, error) {
+	p := &Pattern{raw: pattern, fold: opts.CaseInsensitive}
+
+	s := pattern
+	if strings.HasPrefix(s, "!") {
+		p.negate = true
+		s = s[1:]
+	}
+	if strings.HasSuffix(s, "/") {
+		p.dirOnly = true
+		s = strings.TrimRight(s, "/")
+	}
+	if strings.Contains(s, "/") {
+		p.anchored = true
+		s = strings.TrimLeft(s, "/")
+	}
+	if s == "" {
+		return nil, fmt.Errorf("glob %q: empty pattern", pattern)
+	}
+	if p.fold {
+		s = strings.ToLower(s)
+	}
+
+	for _, seg := range strings.Split(s, "/") {
+		switch {
+		case seg == "" || seg == ".":
+			continue
+		case seg == "**":
+			// Consecutive "**" segments are one.
+			if n := len(p.segs); n > 0 && p.segs[n-1] == "**" {
+				continue
+			}
+		case strings.Contains(seg, "**"):
+			// "**" inside a segment can't cross "/", so it is just "*".
+			for strings.Contains(seg, "**") {
+				seg = strings.ReplaceAll(seg, "**", "*")
+			}
+		}
+		seg = negateClasses(seg)
+		if _, err := path.Match(seg, ""); err != nil {
+			return nil, fmt.Errorf("glob %q: %w", pattern, err)
+		}
+		p.segs = append(p.segs, seg)
+	}
+	if len(p.segs) == 0 {
+		return nil, fmt.Errorf("glob %q: empty pattern", pattern)
+	}
+	if !p.anchored && p.segs[0] != "**" {
+		p.segs = append([]string{"**"}, p.segs...)
+	}
+	return p, nil
+}
+
+// negateClasses rewrites the fnmatch class negation "[!...]" used by
+// gitignore to the "[^...]" of path.Match.
+func negateClasses(seg string) string {
+	b := []byte(seg)
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == '\\':
+			i++
+		case b[i] == '[' && i+1 < len(b) && b[i+1] == '!':
+			b[i+1] = '^'
+		}
+	}
+	return string(b)
+}
+
+// String returns the source pattern.
+func (p *Pattern) String() string { return p.raw }
+
+// Negated reports whether the pattern starts with "!".
+func (p *Pattern) Negated() bool { return p.negate }
+
+// Match reports whether the slash-separated path name matches the pattern,
+// ignoring negation. isDir tells whether name is a directory; a trailing
+// "/" on name is ignored.
+func (p *Pattern) Match(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	name = strings.Trim(name, "/")
+	if p.fold {
+		name = strings.ToLower(name)
+	}
+	var segs []string
+	if name != "" {
+		segs = strings.Split(name, "/")
+	}
+	return matchSegs(p.segs, segs)
+}
+
+func matchSegs(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			rest := pat[1:]
+			if len(rest) == 0 {
+				// A trailing "**" needs something below the directory.
+				return len(name) > 0
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegs(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// List is an ordered set of patterns, like the lines of a .gitignore file.
+type List []*Pattern
+
+// CompileList compiles each pattern.
+func CompileList(patterns []string, opts Options) (List, error) {
+	l := make(List, 0, len(patterns))
+	for _, s := range patterns {
+		p, err := Compile(s, opts)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, p)
+	}
+	return l, nil
+}
+
+// Match reports whether the last pattern matching name is not negated. It
+// returns false when no pattern matches.
+func (l List) Match(name string, isDir bool) bool {
+	matched := false
+	for _, p := range l {
+		if p.Match(name, isDir) {
+			matched = !p.negate
+		}
+	}
+	return matched
+}
+
+// MatchTree reports whether name or one of its parent directories matches
+// l. A path inside a matched directory is matched too, even if a later
+// negated pattern matches the path itself.
+func (l List) MatchTree(name string, isDir bool) bool {
+	name = strings.Trim(name, "/")
+	for i := 0; i < len(name); i++ {
+		if name[i] == '/' && l.Match(name[:i], true) {
+			return true
+		}
+	}
+	return l.Match(name, isDir)
+}
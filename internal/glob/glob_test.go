@@ -0,0 +1,135 @@
+package glob
+
+import "testing"
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		isDir   bool
+		want    bool
+	}{
+		// Floating patterns match the last segment at any depth.
+		{"*.go", "main.go", false, true},
+		{"*.go", "src/pkg/main.go", false, true},
+		{"*.go", "src/main.go/x", false, false},
+		{"main.go", "src/main.go", false, true},
+		{"?.txt", "a/b.txt", false, true},
+		{"?.txt", "a/bc.txt", false, false},
+		{"[abc].txt", "x/b.txt", false, true},
+		{"[!abc].txt", "x/b.txt", false, false},
+		{`\*.txt`, "*.txt", false, true},
+		{`\*.txt`, "a.txt", false, false},
+		{`\!x`, "!x", false, true},
+
+		// Anchored patterns match the whole path.
+		{"src/*.go", "src/main.go", false, true},
+		{"src/*.go", "src/pkg/main.go", false, false},
+		{"src/*.go", "x/src/main.go", false, false},
+		{"/top.txt", "top.txt", false, true},
+		{"/top.txt", "sub/top.txt", false, false},
+		{"./a/b", "a/b", false, true},
+
+		// Doublestar.
+		{"src/**/test/*.go", "src/test/a.go", false, true},
+		{"src/**/test/*.go", "src/x/y/test/a.go", false, true},
+		{"src/**/test/*.go", "src/x/test/sub/a.go", false, false},
+		{"src/**/test/*.go", "lib/src/test/a.go", false, false},
+		{"build/**", "build/out", false, true},
+		{"build/**", "build/a/b/c", false, true},
+		{"build/**", "build", true, false},
+		{"build/**", "buildfoo", false, false},
+		{"build/**", "buildfoo/x", false, false},
+		{"**/node_modules", "node_modules", true, true},
+		{"**/node_modules", "a/b/node_modules", true, true},
+		{"**/node_modules", "a/node_modules/x", false, false},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/**/b", "a/x/y/b", false, true},
+		{"**", "anything/at/all", false, true},
+		{"a**b", "axxb", false, true},
+		{"a**b", "ax/xb", false, false},
+
+		// Directory-only patterns.
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"build/", "src/build", true, true},
+		{"build/", "build/", true, true},
+		{"/build/", "src/build", true, false},
+		{"docs/api/", "docs/api", true, true},
+
+		// Negation is reported separately and does not change Match.
+		{"!*.log", "a.log", false, true},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.pattern, Options{})
+		if err != nil {
+			t.Errorf("Compile(%q) error = %v", tt.pattern, err)
+			continue
+		}
+		if got := p.Match(tt.name, tt.isDir); got != tt.want {
+			t.Errorf("%q.Match(%q, dir=%v) = %v, want %v", tt.pattern, tt.name, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		fold    bool
+		want    bool
+	}{
+		{"*.JPG", "photos/a.jpg", false, false},
+		{"*.JPG", "photos/a.jpg", true, true},
+		{"Photos/**", "photos/a.jpg", true, true},
+		{"[A-C].txt", "b.TXT", true, true},
+		{"readme", "README", false, false},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.pattern, Options{CaseInsensitive: tt.fold})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Match(tt.name, false); got != tt.want {
+			t.Errorf("%q.Match(%q, fold=%v) = %v, want %v", tt.pattern, tt.name, tt.fold, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, pattern := range []string{"", "!", "/", "//", "[a-", "src/[", "./"} {
+		if _, err := Compile(pattern, Options{}); err == nil {
+			t.Errorf("Compile(%q) should fail", pattern)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	l, err := CompileList([]string{"*.log", "!keep.log", "tmp/", "!tmp/important"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		isDir bool
+		match bool
+		tree  bool
+	}{
+		{"a.log", false, true, true},
+		{"logs/keep.log", false, false, false},
+		{"main.go", false, false, false},
+		{"tmp", true, true, true},
+		{"tmp/x", false, false, true},
+		// A negated pattern can't re-include a path below a matched directory.
+		{"tmp/important", false, false, true},
+		{"src/tmp/a.go", false, false, true},
+	}
+	for _, tt := range tests {
+		if got := l.Match(tt.name, tt.isDir); got != tt.match {
+			t.Errorf("Match(%q) = %v, want %v", tt.name, got, tt.match)
+		}
+		if got := l.MatchTree(tt.name, tt.isDir); got != tt.tree {
+			t.Errorf("MatchTree(%q) = %v, want %v", tt.name, got, tt.tree)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package hooks runs user commands before and after uploads and downloads,
+// for example to quiesce a database before its directory is archived or to
+// send a notification when a backup finishes.
+//
+// Hooks run with "sh -c"; their output goes to standard error, so it never
+// mixes with a download written to standard output. A pre hook that fails
+// aborts the operation. The post hook runs after the operation whether it
+// succeeded or not, but not when the pre hook failed. Hooks get these
+// variables:
+//
+//	BURROW_HOOK                 "pre" or "post"
+//	BURROW_OPERATION            "upload" or "download"
+//	BURROW_OBJECT_ID            the object ID (for uploads, post hook only)
+//	BURROW_SOURCES              the uploaded paths, one per line
+//	BURROW_DESTINATION          where a download is written, "-" for stdout
+//	BURROW_META_<KEY>           each metadata entry of an upload, such as
+//	                            BURROW_META_JOB for daemon jobs
+//
+// and, in the post hook only:
+//
+//	BURROW_STATUS               "success" or "failure"
+//	BURROW_ERROR                the error of a failed operation
+//	BURROW_DURATION             the operation's duration in seconds
+//	BURROW_COMPRESSION          the codec the object is compressed with
+//	BURROW_BYTES_UNCOMPRESSED   the bytes before compression
+//	BURROW_BYTES_COMPRESSED     the bytes after compression
+//	BURROW_COMPRESSION_SAVINGS  the percentage compression saved
+//
+// The byte counts and savings are left out when the operation failed
+// before they were known.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operations hooks run around.
+const (
+	Upload   = "upload"
+	Download = "download"
+)
+
+// Config holds the hook commands; empty ones are skipped.
+type Config struct {
+	PreUpload    string `json:"pre_upload,omitempty"`
+	PostUpload   string `json:"post_upload,omitempty"`
+	PreDownload  string `json:"pre_download,omitempty"`
+	PostDownload string `json:"post_download,omitempty"`
+}
+
+// Merge returns c with the commands o sets replacing c's.
+func (c Config) Merge(o Config) Config {
+	if o.PreUpload != "" {
+		c.PreUpload = o.PreUpload
+	}
+	if o.PostUpload != "" {
+		c.PostUpload = o.PostUpload
+	}
+	if o.PreDownload != "" {
+		c.PreDownload = o.PreDownload
+	}
+	if o.PostDownload != "" {
+		c.PostDownload = o.PostDownload
+	}
+	return c
+}
+
+// Event describes the operation a hook runs for. The fields after
+// Metadata are filled in for the post hook.
+type Event struct {
+	Operation   string
+	ObjectID    string
+	Sources     []string
+	Destination string
+	Metadata    map[string]string
+
+	Err      error
+	Duration time.Duration
+	// Compression is the codec used; empty when unknown.
+	Compression string
+	// BytesUncompressed and BytesCompressed are negative when unknown.
+	BytesUncompressed int64
+	BytesCompressed   int64
+}
+
+// NewEvent returns an Event for op with unknown byte counts.
+func NewEvent(op string) *Event {
+	return &Event{Operation: op, BytesUncompressed: -1, BytesCompressed: -1}
+}
+
+// RunPre runs the pre hook of e's operation, if any.
+func (c Config) RunPre(ctx context.Context, e *Event) error {
+	command := c.PreUpload
+	if e.Operation == Download {
+		command = c.PreDownload
+	}
+	if command == "" {
+		return nil
+	}
+	if err := run(ctx, command, e.environ("pre")); err != nil {
+		return fmt.Errorf("pre-%s hook: %w", e.Operation, err)
+	}
+	return nil
+}
+
+// RunPost runs the post hook of e's operation, if any.
+func (c Config) RunPost(ctx context.Context, e *Event) error {
+	command := c.PostUpload
+	if e.Operation == Download {
+		command = c.PostDownload
+	}
+	if command == "" {
+		return nil
+	}
+	if err := run(ctx, command, e.environ("post")); err != nil {
+		return fmt.Errorf("post-%s hook: %w", e.Operation, err)
+	}
+	return nil
+}
+
+// environ returns the variables of a hook run at stage ("pre" or "post").
+func (e *Event) environ(stage string) []string {
+	env := []string{
+		"BURROW_HOOK=" + stage,
+		"BURROW_OPERATION=" + e.Operation,
+	}
+	add := func(name, value string) {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	add("BURROW_OBJECT_ID", e.ObjectID)
+	add("BURROW_SOURCES", strings.Join(e.Sources, "\n"))
+	add("BURROW_DESTINATION", e.Destination)
+	keys := make([]string, 0, len(e.Metadata))
+	for k := range e.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add("BURROW_META_"+envName(k), e.Metadata[k])
+	}
+	if stage == "pre" {
+		return env
+	}
+
+	if e.Err != nil {
+		add("BURROW_STATUS", "failure")
+		add("BURROW_ERROR", e.Err.Error())
+	} else {
+		add("BURROW_STATUS", "success")
+	}
+	add("BURROW_DURATION", strconv.FormatFloat(e.Duration.Seconds(), 'f', 3, 64))
+	add("BURROW_COMPRESSION", e.Compression)
+	if e.BytesUncompressed >= 0 && e.BytesCompressed >= 0 {
+		add("BURROW_BYTES_UNCOMPRESSED", strconv.FormatInt(e.BytesUncompressed, 10))
+		add("BURROW_BYTES_COMPRESSED", strconv.FormatInt(e.BytesCompressed, 10))
+		if e.BytesUncompressed > 0 {
+			savings := 100 * (1 - float64(e.BytesCompressed)/float64(e.BytesUncompressed))
+			add("BURROW_COMPRESSION_SAVINGS", strconv.FormatFloat(savings, 'f', 1, 64))
+		}
+	}
+	return env
+}
+
+// envName turns a metadata key into the tail of a variable name.
+func envName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// run runs a hook, its output going to our standard error.
+func run(ctx context.Context, command string, env []string) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return fmt.Errorf("%q exited with status %d", command, exit.ExitCode())
+		}
+		return err
+	}
+	return nil
+}
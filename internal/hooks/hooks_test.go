@@ -0,0 +1,42 @@
+package hooks
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnviron(t *testing.T) {
+	e := NewEvent(Upload)
+	e.Sources = []string{"/etc", "/home"}
+	e.Metadata = map[string]string{"job": "etc", "watch-dir": "/srv"}
+	e.Err = errors.New("boom")
+	e.Duration = 1500 * time.Millisecond
+
+	pre := e.environ("pre")
+	want := []string{"BURROW_HOOK=pre", "BURROW_OPERATION=upload", "BURROW_SOURCES=/etc\n/home", "BURROW_META_JOB=etc", "BURROW_META_WATCH_DIR=/srv"}
+	if !slices.Equal(pre, want) {
+		t.Errorf("pre environ = %q, want %q", pre, want)
+	}
+
+	post := e.environ("post")
+	for _, v := range []string{"BURROW_STATUS=failure", "BURROW_ERROR=boom", "BURROW_DURATION=1.500"} {
+		if !slices.Contains(post, v) {
+			t.Errorf("post environ %q lacks %s", post, v)
+		}
+	}
+	if slices.ContainsFunc(post, func(v string) bool { return strings.HasPrefix(v, "BURROW_BYTES") }) {
+		t.Errorf("post environ %q has unknown byte counts", post)
+	}
+
+	e.Err = nil
+	e.BytesUncompressed, e.BytesCompressed = 1000, 250
+	post = e.environ("post")
+	for _, v := range []string{"BURROW_STATUS=success", "BURROW_BYTES_UNCOMPRESSED=1000", "BURROW_BYTES_COMPRESSED=250", "BURROW_COMPRESSION_SAVINGS=75.0"} {
+		if !slices.Contains(post, v) {
+			t.Errorf("post environ %q lacks %s", post, v)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Package keyring abstracts the operations that need secret key material,
+// so they can run either in-process or inside the burrow agent.
+package keyring
+
+import (
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
+)
+
+// Keyring performs operations with the master key, the age identities and
+// the signing key without exposing them.
+type Keyring interface {
+	// DeriveDataKey derives the data key of objectID from the master key.
+	DeriveDataKey(objectID string) ([]byte, error)
+	// DecryptConfig returns the configuration used to open envelopes.
+	DecryptConfig() enc.DecryptConfig
+	// Sign signs msg with the envelope signing key.
+	Sign(msg []byte) (publicKey string, sig []byte, err error)
+}
+
+// Local is a Keyring backed by an unlocked config in this process. It reads
+// the config on every call, so it follows key changes such as rotation.
+type Local struct {
+	config *config.Config
+}
+
+// NewLocal creates a Keyring for cfg.
+func NewLocal(cfg *config.Config) *Local {
+	return &Local{config: cfg}
+}
+
+func (l *Local) DeriveDataKey(objectID string) ([]byte, error) {
+	return enc.DeriveDataKey(l.config.MasterKey, objectID)
+}
+
+func (l *Local) DecryptConfig() enc.DecryptConfig {
+	return enc.DecryptConfig{Identities: l.config.Identities()}
+}
+
+func (l *Local) Sign(msg []byte) (string, []byte, error) {
+	return enc.KeySigner(l.config.SigningPrivateKey).Sign(msg)
+}
@@ -0,0 +1,8 @@
+// Package lockfile keeps two processes from working on the same thing at
+// once, such as two runs of a daemon job or two watchers of a directory.
+package lockfile
+
+import "errors"
+
+// ErrLocked is returned by Lock when another holder has the lock.
+var ErrLocked = errors.New("already running")
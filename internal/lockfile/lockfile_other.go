@@ -0,0 +1,22 @@
+//go:build !unix
+
+package lockfile
+
+import (
+	"errors"
+	"os"
+)
+
+// Lock creates path exclusively. Unlike the Unix lock, a lock file left by
+// a crashed process must be removed by hand.
+func Lock(path string) (unlock func(), err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return func() { os.Remove(path) }, nil
+}
@@ -0,0 +1,88 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/storage"
+)
+
+// Options controls a migration run.
+type Options struct {
+	// DryRun only reports which envelopes would be rewritten.
+	DryRun bool
+}
+
+// Result summarizes a migration run.
+type Result struct {
+	Checked  int
+	Migrated int
+	// Versions counts the stored version of every migrated envelope.
+	Versions map[string]int
+}
+
+// Migrator rewrites envelopes stored in an older format in the current
+// envelope version, and signs envelopes written before signing existed.
+type Migrator struct {
+	config  *config.Config
+	keys    keyring.Keyring
+	storage storage.Storage
+	opts    Options
+}
+
+// NewMigrator creates a new Migrator instance
+func NewMigrator(cfg *config.Config, keys keyring.Keyring, storageClient storage.Storage, opts Options) *Migrator {
+	return &Migrator{
+		config:  cfg,
+		keys:    keys,
+		storage: storageClient,
+		opts:    opts,
+	}
+}
+
+// Execute checks every envelope in the repository and re-seals those that
+// need migration. Envelopes keep their recipients and key mode. Unsigned
+// envelopes are accepted and signed with the own key, so a migration should
+// only be run against a bucket that is trusted not to have been tampered with.
+// It is safe to re-run after a failure.
+func (m *Migrator) Execute(ctx context.Context) (*Result, error) {
+	objectIDs, err := envelope.List(ctx, m.storage)
+	if err != nil {
+		return nil, fmt.Errorf("list envelopes: %w", err)
+	}
+
+	result := &Result{Versions: map[string]int{}}
+	dec := m.keys.DecryptConfig()
+	trust := envelope.Trust{Signers: m.config.Signers(), AllowUnsigned: true}
+	for _, objectID := range objectIDs {
+		env, err := envelope.Fetch(ctx, m.storage, objectID, dec, trust)
+		if err != nil {
+			return result, err
+		}
+		result.Checked++
+		if !env.NeedsMigration() && env.Signed() {
+			continue
+		}
+
+		if !m.opts.DryRun {
+			recipients := config.MergeUnique([]string{m.config.AgePublicKey}, env.Recipients)
+			if err := env.Store(ctx, m.storage, recipients, m.keys); err != nil {
+				return result, fmt.Errorf("migrate %s: %w", objectID, err)
+			}
+		}
+		result.Migrated++
+		result.Versions[versionLabel(env)]++
+	}
+	return result, nil
+}
+
+// versionLabel describes the stored format of an envelope that is migrated.
+func versionLabel(env *envelope.Envelope) string {
+	if env.Signed() {
+		return env.ReadVersion()
+	}
+	return env.ReadVersion() + " (unsigned)"
+}
@@ -0,0 +1,90 @@
+// Package padding hides the exact length of a stream by padding it to a
+// size bucket before encryption.
+package padding
+
+import (
+	"io"
+	"math/bits"
+)
+
+// Padme returns the padded size of an n-byte message using the Padmé scheme
+// (Nikitin et al., "Reducing Metadata Leakage from Encrypted Files and
+// Communication with PURBs"). The overhead is at most 12% and the padded size
+// reveals only O(log log n) bits about n.
+func Padme(n int64) int64 {
+	if n < 2 {
+		return n
+	}
+	e := bits.Len64(uint64(n)) - 1
+	s := bits.Len64(uint64(e))
+	lastBits := e - s
+	if lastBits <= 0 {
+		return n
+	}
+	mask := int64(1)<<lastBits - 1
+	return (n + mask) &^ mask
+}
+
+// Writer counts the bytes written through it and appends zero bytes up to
+// the Padmé size on Close.
+type Writer struct {
+	w io.Writer
+	n int64
+}
+
+// NewWriter returns a Writer that pads the stream written to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (p *Writer) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.n += int64(n)
+	return n, err
+}
+
+// Close writes the padding. It does not close the underlying writer.
+func (p *Writer) Close() error {
+	_, err := io.CopyN(p.w, zeroReader{}, Padme(p.n)-p.n)
+	return err
+}
+
+// Length returns the number of bytes written before padding.
+func (p *Writer) Length() int64 {
+	return p.n
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	clear(b)
+	return len(b), nil
+}
+
+// LimitWriter forwards the first n bytes to w and silently drops the rest,
+// stripping padding from a decrypted stream.
+type LimitWriter struct {
+	w io.Writer
+	n int64
+}
+
+// NewLimitWriter returns a LimitWriter that keeps n bytes.
+func NewLimitWriter(w io.Writer, n int64) *LimitWriter {
+	return &LimitWriter{w: w, n: n}
+}
+
+func (l *LimitWriter) Write(b []byte) (int, error) {
+	if l.n <= 0 {
+		return len(b), nil
+	}
+	keep := b
+	if int64(len(keep)) > l.n {
+		keep = keep[:l.n]
+	}
+	n, err := l.w.Write(keep)
+	l.n -= int64(n)
+	if err != nil {
+		return n, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,63 @@
+package padding
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPadme(t *testing.T) {
+	tests := []struct {
+		n, want int64
+	}{
+		{0, 0},
+		{1, 1},
+		{7, 7},
+		{9, 10},
+		{100, 104},
+		{1000, 1024},
+		{1 << 20, 1 << 20},
+		{1<<20 + 1, 1<<20 + 1<<15},
+	}
+	for _, tt := range tests {
+		if got := Padme(tt.n); got != tt.want {
+			t.Errorf("Padme(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+		if got := Padme(tt.n); got < tt.n || float64(got-tt.n) > 0.12*float64(tt.n)+1 {
+			t.Errorf("Padme(%d) = %d exceeds the overhead bound", tt.n, got)
+		}
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	msg := bytes.Repeat([]byte("burrow"), 1000)
+
+	var padded bytes.Buffer
+	w := NewWriter(&padded)
+	if _, err := w.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Length() != int64(len(msg)) {
+		t.Errorf("Length() = %d, want %d", w.Length(), len(msg))
+	}
+	if int64(padded.Len()) != Padme(int64(len(msg))) {
+		t.Errorf("padded length = %d, want %d", padded.Len(), Padme(int64(len(msg))))
+	}
+
+	var out bytes.Buffer
+	lw := NewLimitWriter(&out, w.Length())
+	// Write in uneven pieces to cross the boundary mid-write.
+	b := padded.Bytes()
+	for len(b) > 0 {
+		n := min(len(b), 777)
+		if written, err := lw.Write(b[:n]); err != nil || written != n {
+			t.Fatalf("Write() = %d, %v", written, err)
+		}
+		b = b[n:]
+	}
+	if !bytes.Equal(out.Bytes(), msg) {
+		t.Error("unpadded stream differs from original")
+	}
+}
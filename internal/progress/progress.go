@@ -14,8 +14,20 @@ const (
 	progressBarSpinnerType = 14
 )
 
+// enabled controls whether progress bars are rendered. Bars always go to
+// stderr, so stdout stays free for piped data.
+var enabled = true
+
+// SetEnabled turns rendering of progress bars on or off.
+func SetEnabled(on bool) {
+	enabled = on
+}
+
 // createProgressBar creates a standardized progress bar
 func CreateProgressBar(description string) *progressbar.ProgressBar {
+	if !enabled {
+		return progressbar.DefaultSilent(-1, description)
+	}
 	return progressbar.NewOptions64(
 		-1, // Unknown size
 		progressbar.OptionSetDescription(description),
@@ -0,0 +1,178 @@
+package rotate
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/storage"
+)
+
+// masterKeySize matches the size of master keys generated during setup.
+const masterKeySize = 64
+
+// Options controls what gets rotated.
+type Options struct {
+	// RotateMasterKey also replaces the master key. Envelopes still in derived
+	// mode are switched to wrapped mode first, so no data object is re-encrypted.
+	RotateMasterKey bool
+	// DropRecipients are removed from every envelope and from the configured
+	// recipients, e.g. when a team member leaves.
+	DropRecipients []string
+}
+
+// PersistFunc durably stores cfg (locally and in the repository key). It is
+// called before any envelope is touched and again once rotation completes.
+type PersistFunc func(cfg *config.Config) error
+
+// Result summarizes a rotation run.
+type Result struct {
+	Resealed int
+	Wrapped  int
+}
+
+// Rotator replaces the age identity (and optionally the master key) and
+// re-seals every envelope in the repository.
+type Rotator struct {
+	config  *config.Config
+	storage storage.Storage
+	opts    Options
+	persist PersistFunc
+
+	// keys uses the config in place, so it always holds the current keys.
+	keys *keyring.Local
+}
+
+// NewRotator creates a new Rotator instance
+func NewRotator(cfg *config.Config, storageClient storage.Storage, opts Options, persist PersistFunc) *Rotator {
+	return &Rotator{
+		config:  cfg,
+		storage: storageClient,
+		opts:    opts,
+		persist: persist,
+		keys:    keyring.NewLocal(cfg),
+	}
+}
+
+// Execute runs the complete rotation. It is safe to re-run after a failure:
+// the previous identity stays in Config.RetiredAgePrivateKeys until every
+// envelope has been re-sealed. Like a migration, it accepts unsigned
+// envelopes and signs them with the own key, so it should only be run
+// against a bucket that is trusted not to have been tampered with.
+func (r *Rotator) Execute(ctx context.Context) (*Result, error) {
+	if err := r.rotateIdentity(); err != nil {
+		return nil, err
+	}
+
+	result, err := r.resealAll(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	if r.opts.RotateMasterKey {
+		masterKey := make([]byte, masterKeySize)
+		if _, err := rand.Read(masterKey); err != nil {
+			return result, fmt.Errorf("generate master key: %w", err)
+		}
+		r.config.MasterKey = masterKey
+	}
+
+	r.config.RetiredAgePrivateKeys = nil
+	if err := r.persist(r.config); err != nil {
+		return result, fmt.Errorf("persist rotated config: %w", err)
+	}
+
+	return result, nil
+}
+
+// rotateIdentity generates a new age identity, retires the current one and
+// persists the config before any envelope is re-sealed.
+func (r *Rotator) rotateIdentity() error {
+	r.config.Recipients = r.filterRecipients(r.config.Recipients)
+
+	publicKey, privateKey, err := enc.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	r.config.RetiredAgePrivateKeys = append(r.config.RetiredAgePrivateKeys, r.config.AgePrivateKey)
+	r.config.AgePublicKey = publicKey
+	r.config.AgePrivateKey = privateKey
+
+	if err := r.persist(r.config); err != nil {
+		return fmt.Errorf("persist new identity: %w", err)
+	}
+	return nil
+}
+
+// resealAll re-seals every envelope in the repository to the current identity.
+func (r *Rotator) resealAll(ctx context.Context) (*Result, error) {
+	objectIDs, err := envelope.List(ctx, r.storage)
+	if err != nil {
+		return nil, fmt.Errorf("list envelopes: %w", err)
+	}
+
+	result := &Result{}
+	for _, objectID := range objectIDs {
+		wrapped, err := r.reseal(ctx, objectID)
+		if err != nil {
+			return result, fmt.Errorf("reseal %s: %w", objectID, err)
+		}
+
+		result.Resealed++
+		if wrapped {
+			result.Wrapped++
+		}
+	}
+	return result, nil
+}
+
+// filterRecipients removes the recipients listed in Options.DropRecipients.
+func (r *Rotator) filterRecipients(recipients []string) []string {
+	if len(r.opts.DropRecipients) == 0 {
+		return recipients
+	}
+	drop := make(map[string]bool, len(r.opts.DropRecipients))
+	for _, d := range r.opts.DropRecipients {
+		drop[d] = true
+	}
+	var out []string
+	for _, rcpt := range recipients {
+		if !drop[rcpt] {
+			out = append(out, rcpt)
+		}
+	}
+	return out
+}
+
+// reseal opens a single envelope with any known identity and seals it to the
+// current public key and its remaining recipients. It reports whether the envelope was switched to wrapped mode.
+func (r *Rotator) reseal(ctx context.Context, objectID string) (bool, error) {
+	trust := envelope.Trust{Signers: r.config.Signers(), AllowUnsigned: true}
+	env, err := envelope.Fetch(ctx, r.storage, objectID, r.keys.DecryptConfig(), trust)
+	if err != nil {
+		return false, err
+	}
+
+	wrapped := false
+	if r.opts.RotateMasterKey && env.Encryption.Mode != envelope.KeyModeWrapped {
+		dataKey, err := env.ResolveDataKey(r.keys)
+		if err != nil {
+			return false, fmt.Errorf("resolve data key: %w", err)
+		}
+		env.Wrap(dataKey)
+		wrapped = true
+	}
+
+	env.Recipients = r.filterRecipients(env.Recipients)
+	recipients := config.MergeUnique([]string{r.config.AgePublicKey}, env.Recipients)
+
+	if err := env.Store(ctx, r.storage, recipients, r.keys); err != nil {
+		return false, err
+	}
+	return wrapped, nil
+}
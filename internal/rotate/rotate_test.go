@@ -0,0 +1,168 @@
+package rotate
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/storage"
+)
+
+type memStorage struct {
+	objects map[string][]byte
+}
+
+func (m *memStorage) Upload(_ context.Context, key string, body io.Reader, _ string, _ map[string]string) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	m.objects[key] = b
+	return nil
+}
+
+func (m *memStorage) Download(_ context.Context, key string, w io.Writer) (string, map[string]string, error) {
+	b, ok := m.objects[key]
+	if !ok {
+		return "", nil, storage.ErrNotFound
+	}
+	_, err := w.Write(b)
+	return "", nil, err
+}
+
+func (m *memStorage) GetMetadata(_ context.Context, key string) (map[string]string, error) {
+	if _, ok := m.objects[key]; !ok {
+		return nil, storage.ErrNotFound
+	}
+	return nil, nil
+}
+
+func (m *memStorage) List(_ context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	var out []storage.ObjectInfo
+	for k, v := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, storage.ObjectInfo{Key: k, Size: int64(len(v))})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+func newTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	pub, priv, err := enc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := make([]byte, masterKeySize)
+	if _, err := rand.Read(master); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{AgePublicKey: pub, AgePrivateKey: priv, MasterKey: master}
+	if _, err := cfg.EnsureSigningKey(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func putEnvelope(t *testing.T, s *memStorage, cfg *config.Config, objectID string, signed bool) {
+	t.Helper()
+	env := envelope.NewEnvelope(objectID, objectID+".txt")
+	dataKey, err := enc.DeriveDataKey(cfg.MasterKey, objectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.SetDerivedKey(dataKey)
+	if env.Encryption.Params, err = enc.NewAEADParams(objectID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if signed {
+		if err := env.Sign(enc.KeySigner(cfg.SigningPrivateKey)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sealed, err := env.Seal([]string{cfg.AgePublicKey}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.objects[envelope.Key(objectID)] = sealed
+}
+
+func TestRotateMasterKey(t *testing.T) {
+	cfg := newTestConfig(t)
+	s := &memStorage{objects: map[string][]byte{config.RecoveryKeyObject: []byte("blob")}}
+	putEnvelope(t, s, cfg, "obj1", true)
+	putEnvelope(t, s, cfg, "obj2", true)
+
+	oldPriv := cfg.AgePrivateKey
+	oldMaster := cfg.MasterKey
+	wantKey, err := enc.DeriveDataKey(oldMaster, "obj1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	persisted := 0
+	persist := func(*config.Config) error { persisted++; return nil }
+
+	result, err := NewRotator(cfg, s, Options{RotateMasterKey: true}, persist).Execute(context.Background())
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result.Resealed != 2 || result.Wrapped != 2 {
+		t.Errorf("result = %+v, want 2 resealed and 2 wrapped", result)
+	}
+	if persisted != 2 {
+		t.Errorf("persist called %d times, want 2", persisted)
+	}
+	if cfg.AgePrivateKey == oldPriv || bytes.Equal(cfg.MasterKey, oldMaster) {
+		t.Error("identity and master key should both be rotated")
+	}
+	if len(cfg.RetiredAgePrivateKeys) != 0 {
+		t.Error("retired identities should be cleared after a successful rotation")
+	}
+
+	var env envelope.Envelope
+	if _, err := env.Open(s.objects[envelope.Key("obj1")], enc.DecryptConfig{Identities: []string{oldPriv}}); err == nil {
+		t.Error("old identity should no longer open the envelope")
+	}
+
+	opened, err := env.Open(s.objects[envelope.Key("obj1")], enc.DecryptConfig{Identities: []string{cfg.AgePrivateKey}})
+	if err != nil {
+		t.Fatalf("open with new identity: %v", err)
+	}
+	got, err := opened.ResolveDataKey(keyring.NewLocal(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, wantKey) {
+		t.Error("wrapped data key should equal the key derived from the old master key")
+	}
+}
+
+func TestRotateSignsUnsignedEnvelopes(t *testing.T) {
+	cfg := newTestConfig(t)
+	s := &memStorage{objects: map[string][]byte{}}
+	putEnvelope(t, s, cfg, "legacy", false)
+
+	persist := func(*config.Config) error { return nil }
+	result, err := NewRotator(cfg, s, Options{}, persist).Execute(context.Background())
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result.Resealed != 1 {
+		t.Errorf("result = %+v, want 1 resealed", result)
+	}
+
+	trust := envelope.Trust{Signers: cfg.Signers()}
+	if _, err := envelope.Fetch(context.Background(), s, "legacy", keyring.NewLocal(cfg).DecryptConfig(), trust); err != nil {
+		t.Errorf("re-sealed envelope does not verify: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+// Package settings holds the tunables of the upload pipeline: compression,
+// encryption chunking and multipart upload. They are stored in the config
+// file and can be overridden per command.
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/thebluefowl/burrow/internal/compress"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/storage/b2"
+)
+
+// Settings are pipeline tunables. Zero values mean "use the default".
+type Settings struct {
+	// CompressionLevel is the zstd level (1-19) used by auto mode and the
+	// zstd codecs.
+	CompressionLevel int `json:"compression_level,omitempty"`
+	// CompressionMinSaving is the estimated saving (0-1) below which auto
+	// mode stores a block instead of compressing it.
+	CompressionMinSaving float64 `json:"compression_min_saving,omitempty"`
+	// CompressionBlockSize is the size of auto mode blocks.
+	CompressionBlockSize Size `json:"compression_block_size,omitempty"`
+	// ChunkSize is the AEAD chunk size of new objects.
+	ChunkSize Size `json:"chunk_size,omitempty"`
+	// PartSize is the multipart upload part size; a multiple of 1 MiB.
+	PartSize Size `json:"part_size,omitempty"`
+	// Concurrency is the number of parts uploaded in parallel.
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// Default returns the built-in settings.
+func Default() Settings {
+	return Settings{
+		CompressionLevel:     3,
+		CompressionMinSaving: 0.05,
+		CompressionBlockSize: compress.DefaultBlockSize,
+		ChunkSize:            enc.AEADDefaultChunkSize,
+		PartSize:             b2.DefaultPartSizeMB * MiB,
+		Concurrency:          b2.DefaultConcurrency,
+	}
+}
+
+// WithDefaults returns s with every unset field taken from Default.
+func (s Settings) WithDefaults() Settings {
+	return Default().Merge(s)
+}
+
+// Merge returns s with the fields set in o overriding its own.
+func (s Settings) Merge(o Settings) Settings {
+	if o.CompressionLevel != 0 {
+		s.CompressionLevel = o.CompressionLevel
+	}
+	if o.CompressionMinSaving != 0 {
+		s.CompressionMinSaving = o.CompressionMinSaving
+	}
+	if o.CompressionBlockSize != 0 {
+		s.CompressionBlockSize = o.CompressionBlockSize
+	}
+	if o.ChunkSize != 0 {
+		s.ChunkSize = o.ChunkSize
+	}
+	if o.PartSize != 0 {
+		s.PartSize = o.PartSize
+	}
+	if o.Concurrency != 0 {
+		s.Concurrency = o.Concurrency
+	}
+	return s
+}
+
+// Validate checks the fields that are set.
+func (s Settings) Validate() error {
+	var errs []error
+	if l := s.CompressionLevel; l != 0 && (l < compress.MinZstdLevel || l > compress.MaxZstdLevel) {
+		errs = append(errs, fmt.Errorf("compression-level %d out of range %d-%d", l, compress.MinZstdLevel, compress.MaxZstdLevel))
+	}
+	if m := s.CompressionMinSaving; m < 0 || m >= 1 {
+		errs = append(errs, fmt.Errorf("min-saving %v out of range [0, 1)", m))
+	}
+	if b := s.CompressionBlockSize; b != 0 && (b < compress.MinBlockSize || b > compress.MaxBlockSize) {
+		errs = append(errs, fmt.Errorf("block-size %s out of range %s-%s", b, Size(compress.MinBlockSize), Size(compress.MaxBlockSize)))
+	}
+	if c := s.ChunkSize; c != 0 {
+		if err := enc.ValidateChunkSize(int(c)); err != nil {
+			errs = append(errs, fmt.Errorf("chunk-size %s out of range %s-%s", c, Size(enc.AEADMinChunkSize), Size(enc.AEADMaxChunkSize)))
+		}
+	}
+	if p := s.PartSize; p != 0 {
+		if p%MiB != 0 || p < b2.MinPartSizeMB*MiB || p > b2.MaxPartSizeMB*MiB {
+			errs = append(errs, fmt.Errorf("part-size %s must be whole MiB in range %s-%s", p, Size(b2.MinPartSizeMB*MiB), Size(b2.MaxPartSizeMB*MiB)))
+		}
+	}
+	if c := s.Concurrency; c < 0 || c > b2.MaxConcurrency {
+		errs = append(errs, fmt.Errorf("concurrency %d out of range 1-%d", c, b2.MaxConcurrency))
+	}
+	return errors.Join(errs...)
+}
+
+// PartSizeMB returns the part size in MiB, as the storage client takes it.
+func (s Settings) PartSizeMB() int64 {
+	return int64(s.PartSize / MiB)
+}
+
+// Field describes one setting for flags and `config settings`.
+type Field struct {
+	Name  string
+	Usage string
+}
+
+// Fields lists the settings in display order.
+var Fields = []Field{
+	{"compression-level", "zstd compression level (1-19)"},
+	{"min-saving", "Estimated saving below which auto mode stores a block (0-1)"},
+	{"block-size", "Auto compression block size, e.g. 1MiB"},
+	{"chunk-size", "Encryption chunk size, e.g. 4MiB"},
+	{"part-size", "Upload part size, e.g. 16MiB"},
+	{"concurrency", "Parts uploaded in parallel"},
+}
+
+// Value returns a flag.Value (and pflag.Value) that reads and writes the
+// named setting of s.
+func Value(s *Settings, name string) (*FieldValue, error) {
+	for _, f := range Fields {
+		if f.Name == name {
+			return &FieldValue{s: s, name: name}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown setting %q", name)
+}
+
+// FieldValue is one setting of a Settings, as text.
+type FieldValue struct {
+	s    *Settings
+	name string
+}
+
+func (v *FieldValue) String() string {
+	if v == nil || v.s == nil {
+		return ""
+	}
+	switch v.name {
+	case "compression-level":
+		return strconv.Itoa(v.s.CompressionLevel)
+	case "min-saving":
+		return strconv.FormatFloat(v.s.CompressionMinSaving, 'g', -1, 64)
+	case "block-size":
+		return v.s.CompressionBlockSize.String()
+	case "chunk-size":
+		return v.s.ChunkSize.String()
+	case "part-size":
+		return v.s.PartSize.String()
+	case "concurrency":
+		return strconv.Itoa(v.s.Concurrency)
+	}
+	return ""
+}
+
+func (v *FieldValue) Set(text string) error {
+	var err error
+	switch v.name {
+	case "compression-level":
+		v.s.CompressionLevel, err = strconv.Atoi(text)
+	case "min-saving":
+		v.s.CompressionMinSaving, err = strconv.ParseFloat(text, 64)
+	case "block-size":
+		v.s.CompressionBlockSize, err = ParseSize(text)
+	case "chunk-size":
+		v.s.ChunkSize, err = ParseSize(text)
+	case "part-size":
+		v.s.PartSize, err = ParseSize(text)
+	case "concurrency":
+		v.s.Concurrency, err = strconv.Atoi(text)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", v.name, err)
+	}
+	return nil
+}
+
+// Type implements pflag.Value.
+func (v *FieldValue) Type() string {
+	switch v.name {
+	case "block-size", "chunk-size", "part-size":
+		return "size"
+	case "min-saving":
+		return "float"
+	}
+	return "int"
+}
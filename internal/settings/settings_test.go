@@ -0,0 +1,75 @@
+package settings
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Size
+	}{
+		{"65536", 64 * KiB},
+		{"512K", 512 * KiB},
+		{"4MiB", 4 * MiB},
+		{"4mib", 4 * MiB},
+		{"1 GiB", GiB},
+		{"100B", 100},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "MiB", "-1K", "4TB"} {
+		if _, err := ParseSize(bad); err == nil {
+			t.Errorf("ParseSize(%q) succeeded", bad)
+		}
+	}
+	if s := (16 * MiB).String(); s != "16MiB" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("defaults invalid: %v", err)
+	}
+	bad := []Settings{
+		{CompressionLevel: 20},
+		{CompressionMinSaving: 1},
+		{CompressionBlockSize: KiB},
+		{ChunkSize: 128 * MiB},
+		{PartSize: 4 * MiB},
+		{PartSize: 16*MiB + 1},
+		{Concurrency: -1},
+	}
+	for _, s := range bad {
+		if err := s.Validate(); err == nil {
+			t.Errorf("%+v validated", s)
+		}
+	}
+}
+
+func TestValueAndMerge(t *testing.T) {
+	var s Settings
+	for name, text := range map[string]string{"chunk-size": "1MiB", "compression-level": "9", "min-saving": "0.1"} {
+		v, err := Value(&s, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Set(text); err != nil {
+			t.Fatal(err)
+		}
+		if v.String() != text {
+			t.Errorf("%s = %q, want %q", name, v, text)
+		}
+	}
+	if _, err := Value(&s, "nope"); err == nil {
+		t.Fatal("unknown setting accepted")
+	}
+
+	got := s.WithDefaults()
+	if got.ChunkSize != MiB || got.CompressionLevel != 9 || got.PartSize != Default().PartSize {
+		t.Fatalf("WithDefaults() = %+v", got)
+	}
+}
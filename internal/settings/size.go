@@ -0,0 +1,53 @@
+package settings
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Size is a byte count that parses and prints with binary units (KiB, MiB,
+// GiB). In JSON it is a plain number of bytes.
+type Size int64
+
+const (
+	KiB Size = 1 << 10
+	MiB Size = 1 << 20
+	GiB Size = 1 << 30
+)
+
+var sizeUnits = []struct {
+	suffix string
+	size   Size
+}{
+	{"GiB", GiB}, {"MiB", MiB}, {"KiB", KiB},
+	{"G", GiB}, {"M", MiB}, {"K", KiB},
+	{"B", 1},
+}
+
+// ParseSize parses sizes such as "4MiB", "512K" or "65536".
+func ParseSize(s string) (Size, error) {
+	t := strings.TrimSpace(s)
+	mult := Size(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(t), strings.ToUpper(u.suffix)) {
+			t, mult = strings.TrimSpace(t[:len(t)-len(u.suffix)]), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return Size(n) * mult, nil
+}
+
+// String prints the size in the largest unit that divides it.
+func (s Size) String() string {
+	for _, u := range sizeUnits[:3] {
+		if s != 0 && s%u.size == 0 {
+			return strconv.FormatInt(int64(s/u.size), 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(s), 10)
+}
@@ -0,0 +1,47 @@
+package share
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/storage"
+)
+
+// Share re-seals the envelope of objectID so that recipients can open it in
+// addition to everyone it is already sealed to. Envelopes in derived mode are
+// switched to wrapped mode, since recipients don't hold the master key.
+// Like a migration, it accepts an unsigned envelope and signs it with the
+// own key, so the bucket must be trusted not to have been tampered with.
+func Share(ctx context.Context, cfg *config.Config, keys keyring.Keyring, s storage.Storage, objectID string, recipients []string) error {
+	if len(recipients) == 0 {
+		return errors.New("at least one recipient is required")
+	}
+	for _, r := range recipients {
+		if err := enc.ValidateRecipient(r); err != nil {
+			return err
+		}
+	}
+
+	trust := envelope.Trust{Signers: cfg.Signers(), AllowUnsigned: true}
+	env, err := envelope.Fetch(ctx, s, objectID, keys.DecryptConfig(), trust)
+	if err != nil {
+		return err
+	}
+
+	if env.Encryption.Mode != envelope.KeyModeWrapped {
+		dataKey, err := env.ResolveDataKey(keys)
+		if err != nil {
+			return fmt.Errorf("resolve data key: %w", err)
+		}
+		env.Wrap(dataKey)
+	}
+
+	env.Recipients = config.MergeUnique(env.Recipients, recipients)
+
+	return env.Store(ctx, s, config.MergeUnique([]string{cfg.AgePublicKey}, env.Recipients), keys)
+}
@@ -0,0 +1,105 @@
+package share
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/storage"
+)
+
+// memStorage is an in-memory storage.Storage.
+type memStorage struct {
+	objects map[string][]byte
+}
+
+func (m *memStorage) Upload(_ context.Context, key string, body io.Reader, _ string, _ map[string]string) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	m.objects[key] = b
+	return nil
+}
+
+func (m *memStorage) Download(_ context.Context, key string, w io.Writer) (string, map[string]string, error) {
+	b, ok := m.objects[key]
+	if !ok {
+		return "", nil, storage.ErrNotFound
+	}
+	_, err := w.Write(b)
+	return "", nil, err
+}
+
+func (m *memStorage) GetMetadata(_ context.Context, key string) (map[string]string, error) {
+	if _, ok := m.objects[key]; !ok {
+		return nil, storage.ErrNotFound
+	}
+	return nil, nil
+}
+
+func (m *memStorage) List(_ context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	var out []storage.ObjectInfo
+	for k, v := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, storage.ObjectInfo{Key: k, Size: int64(len(v))})
+		}
+	}
+	return out, nil
+}
+
+func TestShareUnsignedEnvelope(t *testing.T) {
+	pub, priv, err := enc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := make([]byte, 64)
+	rand.Read(master)
+	cfg := &config.Config{AgePublicKey: pub, AgePrivateKey: priv, MasterKey: master}
+	if _, err := cfg.EnsureSigningKey(); err != nil {
+		t.Fatal(err)
+	}
+	keys := keyring.NewLocal(cfg)
+
+	// An envelope written before signing existed.
+	s := &memStorage{objects: map[string][]byte{}}
+	env := envelope.NewEnvelope("obj", "obj.txt")
+	dataKey, err := enc.DeriveDataKey(master, "obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.SetDerivedKey(dataKey)
+	if env.Encryption.Params, err = enc.NewAEADParams("obj", 0); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := env.Seal([]string{pub}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.objects[envelope.Key("obj")] = sealed
+
+	friendPub, friendPriv, err := enc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := Share(ctx, cfg, keys, s, "obj", []string{friendPub}); err != nil {
+		t.Fatalf("Share() error = %v", err)
+	}
+
+	trust := envelope.Trust{Signers: cfg.Signers()}
+	shared, err := envelope.Fetch(ctx, s, "obj", enc.DecryptConfig{Identities: []string{friendPriv}}, trust)
+	if err != nil {
+		t.Fatalf("recipient cannot open the signed envelope: %v", err)
+	}
+	if !slices.Contains(shared.Recipients, friendPub) || shared.Encryption.Mode != envelope.KeyModeWrapped {
+		t.Errorf("shared envelope has recipients %v in %s mode", shared.Recipients, shared.Encryption.Mode)
+	}
+}
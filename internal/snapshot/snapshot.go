@@ -0,0 +1,146 @@
+// Package snapshot runs the hooks that create and release a filesystem
+// snapshot around an upload, so that the archive is read from a frozen copy
+// of the sources instead of files that change under it.
+//
+// The pre hook is a shell command that creates and mounts a snapshot of a
+// directory (Config.Of), for example with lvcreate, `btrfs subvolume
+// snapshot` or `zfs snapshot`, and prints the directory the snapshot is
+// mounted at as the last line of its output. Each source below Of is then
+// read from the same relative path under the mount. The post hook unmounts
+// and removes the snapshot; it runs even if the upload fails.
+//
+// Both hooks run with "sh -c" and get these variables:
+//
+//	BURROW_SNAPSHOT_OF   the directory the snapshot is taken of
+//	BURROW_SOURCES       the absolute source paths, one per line
+//	BURROW_SNAPSHOT      the mount printed by the pre hook (post hook only)
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Config holds the snapshot hooks. An empty Pre disables snapshots.
+type Config struct {
+	// Pre creates and mounts the snapshot and prints its mount point.
+	Pre string `json:"pre,omitempty"`
+	// Post unmounts and removes the snapshot.
+	Post string `json:"post,omitempty"`
+	// Of is the directory the snapshot is taken of; empty means "/".
+	Of string `json:"of,omitempty"`
+}
+
+// Enabled reports whether uploads should use a snapshot.
+func (c Config) Enabled() bool { return c.Pre != "" }
+
+// Snapshot is a mounted snapshot.
+type Snapshot struct {
+	// Of is the absolute directory the snapshot was taken of.
+	Of string
+	// Mount is where the snapshot of Of is mounted.
+	Mount string
+	// Sources are the absolute source paths the snapshot was taken for.
+	Sources []string
+
+	post string
+}
+
+// Create runs the pre hook for sources, which must all be below cfg.Of.
+func Create(ctx context.Context, cfg Config, sources []string) (*Snapshot, error) {
+	of := cfg.Of
+	if of == "" {
+		of = "/"
+	}
+	of, err := filepath.Abs(of)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{Of: of, post: cfg.Post}
+	for _, src := range sources {
+		abs, err := filepath.Abs(src)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.relative(abs); err != nil {
+			return nil, err
+		}
+		s.Sources = append(s.Sources, abs)
+	}
+
+	out, err := s.run(ctx, cfg.Pre)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot pre hook: %w", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	s.Mount = strings.TrimSpace(lines[len(lines)-1])
+	if !filepath.IsAbs(s.Mount) {
+		s.Release(ctx)
+		return nil, fmt.Errorf("snapshot pre hook printed %q; want the absolute path of the mounted snapshot", s.Mount)
+	}
+	if fi, err := os.Stat(s.Mount); err != nil || !fi.IsDir() {
+		s.Release(ctx)
+		return nil, fmt.Errorf("snapshot mount %s is not a directory", s.Mount)
+	}
+	return s, nil
+}
+
+// Path returns where the content of the source path src is read from.
+func (s *Snapshot) Path(src string) string {
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return src
+	}
+	rel, err := s.relative(abs)
+	if err != nil {
+		return src
+	}
+	return filepath.Join(s.Mount, rel)
+}
+
+func (s *Snapshot) relative(abs string) (string, error) {
+	rel, err := filepath.Rel(s.Of, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("source %s is not below the snapshot directory %s", abs, s.Of)
+	}
+	return rel, nil
+}
+
+// Release runs the post hook, if any.
+func (s *Snapshot) Release(ctx context.Context) error {
+	if s.post == "" {
+		return nil
+	}
+	if _, err := s.run(ctx, s.post); err != nil {
+		return fmt.Errorf("snapshot post hook: %w", err)
+	}
+	return nil
+}
+
+// run runs a hook and returns its standard output. Its standard error goes
+// to ours.
+func (s *Snapshot) run(ctx context.Context, command string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"BURROW_SNAPSHOT_OF="+s.Of,
+		"BURROW_SOURCES="+strings.Join(s.Sources, "\n"),
+		"BURROW_SNAPSHOT="+s.Mount,
+	)
+	cmd.Stderr = os.Stderr
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return nil, fmt.Errorf("%q exited with status %d", command, exit.ExitCode())
+		}
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,69 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "live")
+	frozen := filepath.Join(dir, "frozen")
+	log := filepath.Join(dir, "hooks.log")
+	os.MkdirAll(filepath.Join(live, "docs"), 0o755)
+
+	cfg := Config{
+		// The "snapshot" is a copy; the hooks log what they were told.
+		Pre:  `cp -r "$BURROW_SNAPSHOT_OF" ` + frozen + ` && echo "pre $BURROW_SOURCES" >> ` + log + ` && echo noise && echo ` + frozen,
+		Post: `echo "post $BURROW_SNAPSHOT" >> ` + log + ` && rm -r "$BURROW_SNAPSHOT"`,
+		Of:   live,
+	}
+	src := filepath.Join(live, "docs")
+	s, err := Create(context.Background(), cfg, []string{src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Mount != frozen {
+		t.Errorf("Mount = %q, want %q", s.Mount, frozen)
+	}
+	if got, want := s.Path(src), filepath.Join(frozen, "docs"); got != want {
+		t.Errorf("Path(%q) = %q, want %q", src, got, want)
+	}
+	if got, want := s.Path(live), frozen; got != want {
+		t.Errorf("Path(%q) = %q, want %q", live, got, want)
+	}
+	if err := s.Release(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(frozen); !os.IsNotExist(err) {
+		t.Error("post hook did not run")
+	}
+	b, _ := os.ReadFile(log)
+	if want := "pre " + src + "\npost " + frozen + "\n"; string(b) != want {
+		t.Errorf("hooks logged %q, want %q", b, want)
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	if _, err := Create(ctx, Config{Pre: "echo " + dir, Of: filepath.Join(dir, "a")}, []string{dir}); err == nil {
+		t.Error("a source outside Of should be rejected")
+	}
+	if _, err := Create(ctx, Config{Pre: "exit 3", Of: dir}, []string{dir}); err == nil || !strings.Contains(err.Error(), "status 3") {
+		t.Errorf("failing pre hook: err = %v", err)
+	}
+
+	released := filepath.Join(dir, "released")
+	_, err := Create(ctx, Config{Pre: "echo relative", Post: "touch " + released, Of: dir}, []string{dir})
+	if err == nil {
+		t.Error("a relative mount should be rejected")
+	}
+	if _, err := os.Stat(released); err != nil {
+		t.Error("post hook should clean up after a bad mount")
+	}
+}
@@ -3,6 +3,7 @@ package b2
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -14,11 +15,14 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/thebluefowl/burrow/internal/storage"
 )
 
 // Compile-time check to ensure B2Client implements storage.Storage interface
 var _ storage.Storage = (*B2Client)(nil)
+var _ storage.RangeDownloader = (*B2Client)(nil)
 
 // B2Client encapsulates a Backblaze B2 S3-compatible client and default settings.
 type B2Client struct {
@@ -28,6 +32,16 @@ type B2Client struct {
 	concurrency int
 }
 
+// Limits of the multipart upload settings. B2 rejects parts under 5 MiB
+// (except the last) and over 5 GiB.
+const (
+	DefaultPartSizeMB  = 16
+	DefaultConcurrency = 4
+	MinPartSizeMB      = 5
+	MaxPartSizeMB      = 5 << 10
+	MaxConcurrency     = 64
+)
+
 // Config holds options to initialize the uploader.
 type Opts struct {
 	Bucket      string
@@ -42,10 +56,10 @@ type Opts struct {
 // NewB2Client builds a new client configured for Backblaze B2.
 func New(ctx context.Context, opts *Opts) (*B2Client, error) {
 	if opts.PartSizeMB <= 0 {
-		opts.PartSizeMB = 16
+		opts.PartSizeMB = DefaultPartSizeMB
 	}
 	if opts.Concurrency <= 0 {
-		opts.Concurrency = 4
+		opts.Concurrency = DefaultConcurrency
 	}
 
 	loadOpts := []func(*config.LoadOptions) error{
@@ -125,7 +139,7 @@ func (c *B2Client) Download(ctx context.Context, key string, w io.Writer) (conte
 
 	result, err := c.client.GetObject(ctx, input)
 	if err != nil {
-		return "", nil, fmt.Errorf("get object %s/%s: %w", c.bucket, key, err)
+		return "", nil, fmt.Errorf("get object %s/%s: %w", c.bucket, key, wrapNotFound(err))
 	}
 	defer result.Body.Close()
 
@@ -142,6 +156,34 @@ func (c *B2Client) Download(ctx context.Context, key string, w io.Writer) (conte
 	return ct, result.Metadata, nil
 }
 
+// DownloadRange writes length bytes of an object starting at offset to w.
+// Ranges past the end of the object return io.ErrUnexpectedEOF.
+func (c *B2Client) DownloadRange(ctx context.Context, key string, offset, length int64, w io.Writer) error {
+	if offset < 0 || length <= 0 {
+		return fmt.Errorf("invalid range %d+%d", offset, length)
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	}
+
+	result, err := c.client.GetObject(ctx, input)
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+			return fmt.Errorf("get object %s/%s range %d+%d: %w", c.bucket, key, offset, length, io.ErrUnexpectedEOF)
+		}
+		return fmt.Errorf("get object %s/%s: %w", c.bucket, key, wrapNotFound(err))
+	}
+	defer result.Body.Close()
+
+	if _, err := io.Copy(w, io.LimitReader(result.Body, length)); err != nil {
+		return fmt.Errorf("copy object range: %w", err)
+	}
+	return nil
+}
+
 // List lists all objects in the bucket with optional prefix filtering.
 // It automatically handles pagination to retrieve all objects.
 // Note: ListObjectsV2 does not return metadata. Use GetMetadata for individual objects.
@@ -183,6 +225,19 @@ func (c *B2Client) List(ctx context.Context, prefix string) ([]storage.ObjectInf
 	return objects, nil
 }
 
+// Delete removes an object. In a bucket that keeps old versions, B2 hides
+// the file and its lifecycle rules decide when the versions are removed.
+func (c *B2Client) Delete(ctx context.Context, key string) error {
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	}
+	if _, err := c.client.DeleteObject(ctx, input); err != nil {
+		return fmt.Errorf("delete object %s/%s: %w", c.bucket, key, err)
+	}
+	return nil
+}
+
 // GetMetadata retrieves metadata for a specific object without downloading it.
 func (c *B2Client) GetMetadata(ctx context.Context, key string) (map[string]string, error) {
 	input := &s3.HeadObjectInput{
@@ -192,12 +247,23 @@ func (c *B2Client) GetMetadata(ctx context.Context, key string) (map[string]stri
 
 	output, err := c.client.HeadObject(ctx, input)
 	if err != nil {
-		return nil, fmt.Errorf("get metadata for %s/%s: %w", c.bucket, key, err)
+		return nil, fmt.Errorf("get metadata for %s/%s: %w", c.bucket, key, wrapNotFound(err))
 	}
 
 	return output.Metadata, nil
 }
 
+// wrapNotFound tags S3 "missing key" errors with storage.ErrNotFound so callers
+// can use errors.Is without depending on the AWS SDK.
+func wrapNotFound(err error) error {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return fmt.Errorf("%w: %w", storage.ErrNotFound, err)
+	}
+	return err
+}
+
 // GetClient returns the underlying S3 client.
 func (c *B2Client) GetClient() *s3.Client {
 	return c.client
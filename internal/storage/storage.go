@@ -2,9 +2,13 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
 )
 
+// ErrNotFound is returned (wrapped) by backends when the requested key does not exist.
+var ErrNotFound = errors.New("object not found")
+
 // Storer is a generic interface for object storage backends.
 // It abstracts storage operations to support multiple providers (S3, B2, GCS, local filesystem, etc.)
 type Storage interface {
@@ -33,3 +37,58 @@ type ObjectInfo struct {
 	ETag         string
 	Metadata     map[string]string
 }
+
+// RangeDownloader is implemented by backends that can read part of an object.
+type RangeDownloader interface {
+	// DownloadRange writes length bytes of the object starting at offset to
+	// w. It returns ErrNotFound (wrapped) for missing keys.
+	DownloadRange(ctx context.Context, key string, offset, length int64, w io.Writer) error
+}
+
+// Deleter is implemented by backends that can remove objects.
+type Deleter interface {
+	// Delete removes the object at key. Deleting a missing key is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
+
+// NewReaderAt returns an io.ReaderAt over an object that issues one ranged
+// download per ReadAt call.
+func NewReaderAt(ctx context.Context, s RangeDownloader, key string) io.ReaderAt {
+	return &readerAt{ctx: ctx, s: s, key: key}
+}
+
+type readerAt struct {
+	ctx context.Context
+	s   RangeDownloader
+	key string
+}
+
+func (r *readerAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	w := &sliceWriter{buf: p}
+	if err := r.s.DownloadRange(r.ctx, r.key, off, int64(len(p)), w); err != nil {
+		return w.n, err
+	}
+	if w.n < len(p) {
+		return w.n, io.EOF
+	}
+	return w.n, nil
+}
+
+// sliceWriter fills a fixed buffer and rejects anything beyond it.
+type sliceWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	k := copy(w.buf[w.n:], p)
+	w.n += k
+	if k < len(p) {
+		return k, errors.New("ranged download returned more data than requested")
+	}
+	return k, nil
+}
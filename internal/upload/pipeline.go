@@ -1,46 +1,70 @@
 package upload
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 
 	"github.com/thebluefowl/burrow/internal/archive"
 	"github.com/thebluefowl/burrow/internal/compress"
 	"github.com/thebluefowl/burrow/internal/config"
 	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/padding"
 	"github.com/thebluefowl/burrow/internal/pipeline"
 	"github.com/thebluefowl/burrow/internal/progress"
 	"github.com/thebluefowl/burrow/internal/storage"
 )
 
-// Constants for pipeline configuration
-const (
-	compressionLevel      = 3
-	compressionMinSaving  = 0.05
-	compressionSampleSize = 1 << 20
-)
-
 // EncryptionPipelineOpts contains options for the encryption pipeline
 type EncryptionPipelineOpts struct {
 	ObjectID string
+	DataKey  []byte
 	Config   *config.Config
 	B2Client storage.Storage
+
+	// Compression is the codec to compress with; empty selects auto.
+	Compression compress.CompressionMode
+	// Dictionary is a trained zstd dictionary for the zstd codecs.
+	Dictionary []byte
+
+	// Stream, if set, is compressed and encrypted as is instead of a tar
+	// archive of the source path.
+	Stream io.Reader
+
+	// ReadFrom, if set, maps each source to the path it is read from, e.g.
+	// inside a filesystem snapshot.
+	ReadFrom func(src string) string
+	// Warn, if set, receives problems that don't abort the upload, such as
+	// files that changed while read under the "warn" change policy.
+	Warn func(err error)
+	// Select, if set, picks the entries of the archive (see
+	// archive.Options.Select).
+	Select func(name string, info fs.FileInfo) bool
+
+	// StorageKey overrides the default data/<id>.enc destination.
+	StorageKey string
+	// Pad pads the compressed stream to a Padmé size before encryption.
+	Pad bool
+	// Trailer, if set, is called once the encrypted stream is complete and
+	// its result is appended to the uploaded object.
+	Trailer func(*EncryptionPipelineResult) ([]byte, error)
 }
 
 // EncryptionPipelineResult contains the results of the encryption pipeline
 type EncryptionPipelineResult struct {
 	CompressInfo *compress.CompressInfo
 	AEADResult   *enc.AEADResult
+	Padding      *envelope.Padding
 }
 
 // EncryptionPipeline executes the complete encryption pipeline
-func EncryptionPipeline(opts *EncryptionPipelineOpts, src string, dst io.Writer) (*EncryptionPipelineResult, error) {
-	ctx := context.Background()
-
+func EncryptionPipeline(ctx context.Context, opts *EncryptionPipelineOpts, srcs []string, dst io.Writer) (*EncryptionPipelineResult, error) {
 	ep := &encryptionPipeline{
 		opts: opts,
-		src:  src,
+		srcs: srcs,
 		dst:  dst,
 	}
 
@@ -50,11 +74,12 @@ func EncryptionPipeline(opts *EncryptionPipelineOpts, src string, dst io.Writer)
 // encryptionPipeline manages the encryption pipeline execution
 type encryptionPipeline struct {
 	opts *EncryptionPipelineOpts
-	src  string
+	srcs []string
 	dst  io.Writer
 
 	compressInfo *compress.CompressInfo
 	aeadResult   *enc.AEADResult
+	padding      *envelope.Padding
 }
 
 // execute runs the complete pipeline
@@ -67,68 +92,142 @@ func (ep *encryptionPipeline) execute(ctx context.Context) (*EncryptionPipelineR
 		return nil, fmt.Errorf("config is required")
 	}
 
-	if ep.opts.Config.MasterKey == nil {
-		return nil, fmt.Errorf("masterKey is required")
+	if ep.opts.DataKey == nil {
+		return nil, fmt.Errorf("dataKey is required")
 	}
 
 	stages := []pipeline.Stage{
 		ep.archiveStage,
-		ep.compressStage,
-		ep.encryptStage,
-		ep.uploadStage,
 	}
+	if ep.opts.Stream != nil {
+		stages[0] = ep.streamStage
+	}
+	if ep.opts.Pad {
+		stages = append(stages, ep.padStage)
+	}
+	stages = append(stages, ep.encryptStage, ep.uploadStage)
 
 	if err := pipeline.PipeGraph(ctx, stages...); err != nil {
 		return nil, fmt.Errorf("encryption pipeline: %w", err)
 	}
 
+	return ep.result(), nil
+}
+
+func (ep *encryptionPipeline) result() *EncryptionPipelineResult {
 	return &EncryptionPipelineResult{
 		CompressInfo: ep.compressInfo,
 		AEADResult:   ep.aeadResult,
-	}, nil
+		Padding:      ep.padding,
+	}
+}
+
+// newCompressor creates the compressor the first stage writes through.
+func (ep *encryptionPipeline) newCompressor(w io.Writer) (io.WriteCloser, error) {
+	mode := ep.opts.Compression
+	if mode == "" {
+		mode = compress.CompressAuto
+	}
+	set := ep.opts.Config.Settings.WithDefaults()
+	compCfg := compress.CompressorConfig{
+		Mode:          mode,
+		ZstdLevel:     set.CompressionLevel,
+		AutoMinSaving: set.CompressionMinSaving,
+		SampleBytes:   int(set.CompressionBlockSize),
+		Dictionary:    ep.opts.Dictionary,
+	}
+
+	compWriter, compInfo, err := compress.NewCompressorWithInfo(w, compCfg)
+	if err != nil {
+		return nil, fmt.Errorf("compress stage setup: %w", err)
+	}
+
+	ep.compressInfo = compInfo
+	return compWriter, nil
 }
 
-// tarStage creates a tar archive from the source
+// archiveStage creates a tar archive from the sources and compresses it. The
+// two run in one stage so the archiver can tell the compressor about each
+// file before its content arrives (see compress.Classify).
 func (ep *encryptionPipeline) archiveStage(ctx context.Context, r io.Reader, w io.Writer) error {
+	policy, err := archive.ParseChangePolicy(ep.opts.Config.ChangePolicy)
+	if err != nil {
+		return err
+	}
+
 	bar := progress.CreateProgressBar("📦 ARCHIVE ")
 	defer func() { _ = bar.Finish() }()
 
-	opts := archive.Options{
-		IncludeRoot:   true,
-		Deterministic: true,
+	compWriter, err := ep.newCompressor(w)
+	if err != nil {
+		return err
+	}
+
+	opts := archiveOptions(ep.opts.Config)
+	opts.ChangePolicy = policy
+	opts.ReadFrom = ep.opts.ReadFrom
+	opts.Warn = ep.opts.Warn
+	opts.Select = ep.opts.Select
+	if hinter, ok := compWriter.(compress.Hinter); ok {
+		opts.HeadSize = compress.HeadSize
+		opts.OnFile = func(name string, size int64, head []byte) error {
+			return hinter.Hint(compress.Classify(name, size, head))
+		}
 	}
 
-	progressWriter := io.MultiWriter(w, bar)
-	if err := archive.StreamTar(ctx, progressWriter, ep.src, opts); err != nil {
+	progressWriter := io.MultiWriter(compWriter, bar)
+	if err := archive.StreamTarPaths(ctx, progressWriter, ep.srcs, opts); err != nil {
+		compWriter.Close()
 		return fmt.Errorf("tar stage: %w", err)
 	}
 
+	if err := compWriter.Close(); err != nil {
+		return fmt.Errorf("compress stage close: %w", err)
+	}
+
 	return nil
 }
 
-// compressStage compresses the tar archive
-func (ep *encryptionPipeline) compressStage(ctx context.Context, r io.Reader, w io.Writer) error {
-	bar := progress.CreateProgressBar("🗜️  COMPRESS")
-	defer func() { _ = bar.Finish() }()
+// archiveOptions returns the archive options cfg sets for uploads.
+func archiveOptions(cfg *config.Config) archive.Options {
+	return archive.Options{
+		IncludeRoot:       true,
+		Deterministic:     true,
+		Exclude:           cfg.Exclude,
+		IgnoreCase:        cfg.ExcludeIgnoreCase,
+		IgnoreFile:        archive.IgnoreFileName,
+		ExcludeCaches:     cfg.ExcludeCaches,
+		ExcludeLargerThan: int64(cfg.ExcludeLargerThan),
+		SpecialFiles:      cfg.SpecialFiles,
+	}
+}
 
-	compCfg := compress.CompressorConfig{
-		Mode:          compress.CompressionMode("auto"),
-		ZstdLevel:     compressionLevel,
-		AutoMinSaving: compressionMinSaving,
-		SampleBytes:   compressionSampleSize,
+// Scan walks srcs as an upload with cfg would and calls fn with the tar
+// path and lstat info of every entry it would archive, without reading any
+// file content.
+func Scan(ctx context.Context, cfg *config.Config, srcs []string, fn func(name string, info fs.FileInfo)) error {
+	opts := archiveOptions(cfg)
+	opts.Select = func(name string, info fs.FileInfo) bool {
+		fn(name, info)
+		return false
 	}
+	return archive.StreamTarPaths(ctx, io.Discard, srcs, opts)
+}
 
-	compWriter, compInfo, err := compress.NewCompressorWithInfo(w, compCfg)
+// streamStage compresses the raw input stream
+func (ep *encryptionPipeline) streamStage(ctx context.Context, r io.Reader, w io.Writer) error {
+	bar := progress.CreateProgressBar("📥 READ    ")
+	defer func() { _ = bar.Finish() }()
+
+	compWriter, err := ep.newCompressor(w)
 	if err != nil {
-		return fmt.Errorf("compress stage setup: %w", err)
+		return err
 	}
 
-	ep.compressInfo = compInfo
-
-	progressReader := io.TeeReader(r, bar)
-	if _, err := io.Copy(compWriter, progressReader); err != nil {
+	progressWriter := io.MultiWriter(compWriter, bar)
+	if _, err := io.Copy(progressWriter, ep.opts.Stream); err != nil {
 		compWriter.Close()
-		return fmt.Errorf("compress stage copy: %w", err)
+		return fmt.Errorf("stream stage: %w", err)
 	}
 
 	if err := compWriter.Close(); err != nil {
@@ -138,23 +237,33 @@ func (ep *encryptionPipeline) compressStage(ctx context.Context, r io.Reader, w
 	return nil
 }
 
+// padStage pads the compressed stream so its length only reveals a size bucket
+func (ep *encryptionPipeline) padStage(ctx context.Context, r io.Reader, w io.Writer) error {
+	pw := padding.NewWriter(w)
+	if _, err := io.Copy(pw, r); err != nil {
+		return fmt.Errorf("pad stage copy: %w", err)
+	}
+	if err := pw.Close(); err != nil {
+		return fmt.Errorf("pad stage close: %w", err)
+	}
+
+	ep.padding = &envelope.Padding{Scheme: envelope.PaddingPadme, Length: pw.Length()}
+	return nil
+}
+
 // encryptStage encrypts the compressed data
 func (ep *encryptionPipeline) encryptStage(ctx context.Context, r io.Reader, w io.Writer) error {
 	bar := progress.CreateProgressBar("🔒 ENCRYPT ")
 	defer func() { _ = bar.Finish() }()
 
-	params, err := enc.NewAEADParams(ep.opts.ObjectID, enc.AEADDefaultChunkSize)
+	chunkSize := ep.opts.Config.Settings.WithDefaults().ChunkSize
+	params, err := enc.NewAEADParams(ep.opts.ObjectID, int(chunkSize))
 	if err != nil {
 		return fmt.Errorf("new aead params: %w", err)
 	}
 
-	dataKey, err := enc.DeriveDataKey(ep.opts.Config.MasterKey, ep.opts.ObjectID)
-	if err != nil {
-		return fmt.Errorf("derive data key: %w", err)
-	}
-
 	progressReader := io.TeeReader(r, bar)
-	aeadResult, err := enc.EncryptAEAD(w, progressReader, dataKey, params)
+	aeadResult, err := enc.EncryptAEAD(w, progressReader, ep.opts.DataKey, params)
 	if err != nil {
 		return fmt.Errorf("aead encrypt: %w", err)
 	}
@@ -173,13 +282,40 @@ func (ep *encryptionPipeline) uploadStage(ctx context.Context, r io.Reader, w io
 	bar := progress.CreateProgressBar("☁️  UPLOAD  ")
 	defer func() { _ = bar.Finish() }()
 
-	key := "data/" + ep.opts.ObjectID + ".enc"
-	progressReader := io.TeeReader(r, bar)
+	key := ep.opts.StorageKey
+	if key == "" {
+		key = "data/" + ep.opts.ObjectID + ".enc"
+	}
+	var body io.Reader = io.TeeReader(r, bar)
+	if ep.opts.Trailer != nil {
+		// The trailer is built only after the encrypt stage has finished,
+		// when the pipeline result is complete.
+		body = io.MultiReader(body, &trailerReader{build: func() ([]byte, error) {
+			return ep.opts.Trailer(ep.result())
+		}})
+	}
 
-	err := ep.opts.B2Client.Upload(ctx, key, progressReader, "application/octet-stream", nil)
+	err := ep.opts.B2Client.Upload(ctx, key, body, "application/octet-stream", nil)
 	if err != nil {
 		return fmt.Errorf("upload stage: %w", err)
 	}
 
 	return nil
 }
+
+// trailerReader produces the bytes returned by build on its first Read.
+type trailerReader struct {
+	build func() ([]byte, error)
+	r     io.Reader
+}
+
+func (t *trailerReader) Read(p []byte) (int, error) {
+	if t.r == nil {
+		b, err := t.build()
+		if err != nil {
+			return 0, err
+		}
+		t.r = bytes.NewReader(b)
+	}
+	return t.r.Read(p)
+}
@@ -1,52 +1,163 @@
 package upload
 
 import (
-	"bytes"
 	"context"
+	"crypto/rand"
 	"fmt"
+	"io"
+	"io/fs"
+	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/segmentio/ksuid"
 	"github.com/thebluefowl/burrow/internal/compress"
 	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/enc"
 	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/hooks"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/snapshot"
 	"github.com/thebluefowl/burrow/internal/storage"
 )
 
+// multiSourceName is the original file name recorded for an upload of
+// several paths.
+const multiSourceName = "backup"
+
 // Uploader handles the complete upload workflow
 type Uploader struct {
 	config     *config.Config
-	sourcePath string
+	keys       keyring.Keyring
+	sources    []string
+	stream     io.Reader
+	name       string
 	objectID   string
+	dataKey    []byte
+	dictionary []byte
+
+	snapshot    *snapshot.Snapshot
+	warnings    []error
+	metadata    map[string]string
+	incremental *envelope.Incremental
+	selected    func(name string, info fs.FileInfo) bool
+
+	compressInfo *compress.CompressInfo
 
 	envelope *envelope.Envelope
 	storage  storage.Storage
 }
 
-// NewUploader creates a new Uploader instance
-func NewUploader(cfg *config.Config, sourcePath string, storageClient storage.Storage) *Uploader {
+// NewUploader creates a new Uploader instance that archives the given files
+// and directories into one object
+func NewUploader(cfg *config.Config, keys keyring.Keyring, sources []string, storageClient storage.Storage) *Uploader {
+	return &Uploader{
+		config:  cfg,
+		keys:    keys,
+		sources: sources,
+		storage: storageClient,
+	}
+}
+
+// NewStreamUploader creates an Uploader that encrypts the raw bytes read from
+// r, e.g. stdin, instead of archiving a path. name is recorded as the
+// original file name.
+func NewStreamUploader(cfg *config.Config, keys keyring.Keyring, r io.Reader, name string, storageClient storage.Storage) *Uploader {
 	return &Uploader{
-		config:     cfg,
-		sourcePath: sourcePath,
-		storage:    storageClient,
+		config:  cfg,
+		keys:    keys,
+		stream:  r,
+		name:    name,
+		storage: storageClient,
+	}
+}
+
+// SetMetadata records key=value in the envelope's metadata. It must be
+// called before Execute.
+func (u *Uploader) SetMetadata(key, value string) {
+	if u.metadata == nil {
+		u.metadata = make(map[string]string)
 	}
+	u.metadata[key] = value
+}
+
+// SetSelect archives only the entries selected reports true for (see
+// archive.Options.Select). It must be called before Execute.
+func (u *Uploader) SetSelect(selected func(name string, info fs.FileInfo) bool) {
+	u.selected = selected
+}
+
+// SetIncremental records in the envelope that the upload holds the changes
+// since the object parent, and the tar paths deleted since. It must be
+// called before Execute.
+func (u *Uploader) SetIncremental(parent string, deleted []string) {
+	u.incremental = &envelope.Incremental{Parent: parent, Deleted: deleted}
 }
 
 // Execute runs the complete upload process
 func (u *Uploader) Execute() error {
+	return u.ExecuteContext(context.Background())
+}
+
+// ExecuteContext runs the complete upload process, between the pre and post
+// upload hooks; cancelling ctx aborts it.
+func (u *Uploader) ExecuteContext(ctx context.Context) (err error) {
+	event := hooks.NewEvent(hooks.Upload)
+	event.Sources = u.sources
+	event.Metadata = u.metadata
+	if err := u.config.Hooks.RunPre(ctx, event); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	defer func() {
+		event.ObjectID = u.objectID
+		event.Err = err
+		event.Duration = time.Since(start)
+		if info := u.compressInfo; info != nil {
+			event.Compression = string(info.ModeUsed)
+			event.BytesUncompressed = info.BytesInUncompressed
+			event.BytesCompressed = info.BytesOutCompressed
+		}
+		// The upload's outcome stands even if the post hook fails.
+		if herr := u.config.Hooks.RunPost(context.WithoutCancel(ctx), event); herr != nil {
+			u.warnings = append(u.warnings, herr)
+		}
+	}()
+
+	return u.execute(ctx)
+}
+
+func (u *Uploader) execute(ctx context.Context) (err error) {
 	if err := u.initialize(); err != nil {
 		return err
 	}
 
-	encryptionResult, err := u.encryptAndUpload()
+	if u.stream == nil && u.config.Snapshot.Enabled() {
+		if err := u.takeSnapshot(ctx); err != nil {
+			return err
+		}
+		defer func() {
+			// Release the snapshot even when ctx was cancelled.
+			if rerr := u.snapshot.Release(context.WithoutCancel(ctx)); err == nil {
+				err = rerr
+			}
+		}()
+	}
+
+	encryptionResult, err := u.encryptAndUpload(ctx)
 	if err != nil {
 		return err
 	}
 
+	if u.config.Bundle {
+		// The envelope was appended to the bundle by the upload stage.
+		return nil
+	}
+
 	u.fillEnvelope(encryptionResult)
 
-	if err := u.uploadEnvelope(); err != nil {
+	if err := u.uploadEnvelope(ctx); err != nil {
 		return err
 	}
 
@@ -55,23 +166,106 @@ func (u *Uploader) Execute() error {
 
 // initialize sets up the uploader state
 func (u *Uploader) initialize() error {
-	u.objectID = ksuid.New().String()
-	u.envelope = envelope.NewEnvelope(u.objectID, filepath.Base(u.sourcePath))
+	if u.private() {
+		id, err := randomObjectID()
+		if err != nil {
+			return err
+		}
+		u.objectID = id
+	} else {
+		u.objectID = ksuid.New().String()
+	}
+	if u.stream != nil {
+		u.envelope = envelope.NewEnvelope(u.objectID, u.name)
+		u.envelope.Kind = envelope.KindStream
+	} else if len(u.sources) == 0 {
+		return fmt.Errorf("no source paths")
+	} else if len(u.sources) == 1 {
+		info, err := os.Lstat(u.sources[0])
+		if err != nil {
+			return fmt.Errorf("source: %w", err)
+		}
+		u.envelope = envelope.NewEnvelope(u.objectID, filepath.Base(u.sources[0]))
+		switch {
+		case info.Mode().IsRegular():
+			u.envelope.Kind = envelope.KindFile
+		case info.IsDir():
+			u.envelope.Kind = envelope.KindDir
+		}
+	} else {
+		// Several sources are archived side by side, like the content of a
+		// directory.
+		u.envelope = envelope.NewEnvelope(u.objectID, multiSourceName)
+		u.envelope.Kind = envelope.KindDir
+	}
+	if len(u.metadata) > 0 {
+		u.envelope.Metadata = u.metadata
+	}
+	u.envelope.Incremental = u.incremental
+
+	var err error
+	if u.config.WrapDataKeys() {
+		u.dataKey, err = enc.NewDataKey()
+	} else {
+		u.dataKey, err = u.keys.DeriveDataKey(u.objectID)
+	}
+	if err != nil {
+		return fmt.Errorf("data key: %w", err)
+	}
+
+	if u.config.CompressionDictionary != "" {
+		u.dictionary, err = os.ReadFile(u.config.CompressionDictionary)
+		if err != nil {
+			return fmt.Errorf("compression dictionary: %w", err)
+		}
+	}
+	return nil
+}
+
+// takeSnapshot runs the snapshot pre hook and records the snapshot in the
+// envelope. The sources are then read from it.
+func (u *Uploader) takeSnapshot(ctx context.Context) error {
+	snap, err := snapshot.Create(ctx, u.config.Snapshot, u.sources)
+	if err != nil {
+		return err
+	}
+	u.snapshot = snap
+	u.envelope.Snapshot = &envelope.Snapshot{
+		Of:      snap.Of,
+		Mount:   snap.Mount,
+		Sources: snap.Sources,
+	}
 	return nil
 }
 
 // encryptAndUpload performs the encryption pipeline and uploads to storage
-func (u *Uploader) encryptAndUpload() (*EncryptionPipelineResult, error) {
+func (u *Uploader) encryptAndUpload(ctx context.Context) (*EncryptionPipelineResult, error) {
 	opts := &EncryptionPipelineOpts{
 		ObjectID: u.objectID,
+		DataKey:  u.dataKey,
 		Config:   u.config,
 		B2Client: u.storage,
+		Pad:      u.private(),
+		Stream:   u.stream,
+		Warn:     func(err error) { u.warnings = append(u.warnings, err) },
+		Select:   u.selected,
+
+		Compression: compress.CompressionMode(u.config.Compression),
+		Dictionary:  u.dictionary,
+	}
+	if u.snapshot != nil {
+		opts.ReadFrom = u.snapshot.Path
+	}
+	if u.config.Bundle {
+		opts.StorageKey = envelope.BundleKey(u.objectID)
+		opts.Trailer = u.bundleTrailer
 	}
 
-	result, err := EncryptionPipeline(opts, u.sourcePath, nil)
+	result, err := EncryptionPipeline(ctx, opts, u.sources, nil)
 	if err != nil {
 		return nil, fmt.Errorf("encryption and upload pipeline failed: %w", err)
 	}
+	u.compressInfo = result.CompressInfo
 
 	return result, nil
 }
@@ -83,38 +277,79 @@ func (u *Uploader) fillEnvelope(result *EncryptionPipelineResult) {
 
 	if result.AEADResult != nil {
 		u.envelope.Encryption.Params = result.AEADResult.Params
-		u.envelope.Encryption.DataKey = result.AEADResult.DataKey
+		if u.config.WrapDataKeys() {
+			// Recipients don't hold the master key, so they need the data key itself.
+			u.envelope.Wrap(result.AEADResult.DataKey)
+			u.envelope.Recipients = u.config.Recipients
+		} else {
+			u.envelope.SetDerivedKey(result.AEADResult.DataKey)
+		}
 		u.envelope.PlainSHA = result.AEADResult.PlainSHA
 	}
 
 	if result.CompressInfo != nil {
 		u.envelope.Compression.Mode = string(result.CompressInfo.ModeUsed)
+		if result.CompressInfo.ModeUsed != compress.CompressNone {
+			u.envelope.Compression.Dictionary = u.dictionary
+		}
+		if info := result.CompressInfo; info.SeekTableSize > 0 {
+			u.envelope.Compression.Seekable = &envelope.Seekable{
+				Frames:      info.SeekFrames,
+				TableOffset: info.BytesOutCompressed - info.SeekTableSize,
+				TableSize:   info.SeekTableSize,
+			}
+		}
 	} else {
 		u.envelope.Compression.Mode = string(compress.CompressNone)
 	}
 
+	u.envelope.Padding = result.Padding
 	u.envelope.CreatedAt = time.Now()
 }
 
-// uploadEnvelope seals and uploads the envelope to the /keys directory
-func (u *Uploader) uploadEnvelope() error {
-	ctx := context.Background()
+// bundleTrailer fills the envelope once the data is encrypted and returns it
+// sealed, to be appended to the bundle
+func (u *Uploader) bundleTrailer(result *EncryptionPipelineResult) ([]byte, error) {
+	u.fillEnvelope(result)
+	return u.envelope.BundleTrailer(u.config.EnvelopeRecipients(), u.keys)
+}
 
-	// Seal the envelope using age encryption
-	recipients := []string{u.config.AgePublicKey}
-	sealedEnvelope, err := u.envelope.Seal(recipients, true)
-	if err != nil {
-		return fmt.Errorf("failed to seal envelope: %w", err)
-	}
+// private reports whether uploads should hide their time and size
+func (u *Uploader) private() bool {
+	return u.config.Privacy || u.config.Bundle
+}
 
-	// Upload to /keys directory
-	key := "keys/" + u.objectID + ".envelope"
-	err = u.storage.Upload(ctx, key, bytes.NewReader(sealedEnvelope), "application/octet-stream", nil)
+// randomObjectID returns a KSUID made of random bytes. It has the same format
+// as a regular KSUID, but its timestamp component carries no upload time.
+func randomObjectID() (string, error) {
+	var b [20]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("object id: %w", err)
+	}
+	id, err := ksuid.FromBytes(b[:])
 	if err != nil {
-		return fmt.Errorf("failed to upload envelope: %w", err)
+		return "", fmt.Errorf("object id: %w", err)
 	}
+	return id.String(), nil
+}
 
-	return nil
+// uploadEnvelope seals and uploads the envelope to the /keys directory
+func (u *Uploader) uploadEnvelope(ctx context.Context) error {
+	return u.envelope.Store(ctx, u.storage, u.config.EnvelopeRecipients(), u.keys)
+}
+
+// Warnings returns the problems that didn't stop the upload, such as files
+// that changed while they were read.
+func (u *Uploader) Warnings() []error {
+	return u.warnings
+}
+
+// StorageKeys returns the keys of the objects the upload wrote.
+func (u *Uploader) StorageKeys() []string {
+	if u.config.Bundle {
+		return []string{envelope.BundleKey(u.objectID)}
+	}
+	return []string{"data/" + u.objectID + ".enc", envelope.Key(u.objectID)}
 }
 
 // ObjectID returns the generated object ID for this upload
@@ -0,0 +1,11 @@
+package watch
+
+// notifier reports changes below a directory.
+type notifier interface {
+	// Events receives the paths that changed, or the directory itself when
+	// changes may have been missed. It is closed if watching fails; Err
+	// then returns why.
+	Events() <-chan string
+	Err() error
+	Close() error
+}
@@ -0,0 +1,154 @@
+//go:build linux
+
+package watch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_ATTRIB |
+	unix.IN_CLOSE_WRITE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF |
+	unix.IN_ONLYDIR | unix.IN_DONT_FOLLOW | unix.IN_EXCL_UNLINK
+
+// inotify watches every directory of a tree, adding watches for
+// directories created or moved into it.
+type inotify struct {
+	f      *os.File
+	fd     int
+	root   string
+	dirs   map[int32]string // watch descriptor to directory
+	events chan string
+	done   chan struct{}
+	once   sync.Once
+	err    error
+}
+
+func newNotifier(dir string) (notifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %w", err)
+	}
+	n := &inotify{
+		// A non-blocking descriptor is read through the runtime poller, so
+		// Close interrupts a pending Read.
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		fd:     fd,
+		root:   dir,
+		dirs:   make(map[int32]string),
+		events: make(chan string, 64),
+		done:   make(chan struct{}),
+	}
+	if err := n.addTree(dir); err != nil {
+		n.f.Close()
+		return nil, err
+	}
+	go n.read()
+	return n, nil
+}
+
+// addTree watches dir and the directories below it. Directories that
+// vanish meanwhile are skipped.
+func (n *inotify) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path != dir {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		wd, err := unix.InotifyAddWatch(n.fd, path, watchMask)
+		switch {
+		case errors.Is(err, unix.ENOENT), errors.Is(err, unix.ENOTDIR):
+			return nil
+		case errors.Is(err, unix.ENOSPC):
+			return fmt.Errorf("inotify watch limit reached at %s; raise fs.inotify.max_user_watches", path)
+		case err != nil:
+			return fmt.Errorf("watch %s: %w", path, err)
+		}
+		n.dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+func (n *inotify) read() {
+	defer close(n.events)
+	buf := make([]byte, 64*1024)
+	for {
+		size, err := n.f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				n.err = fmt.Errorf("inotify: %w", err)
+			}
+			return
+		}
+		if err := n.handle(buf[:size]); err != nil {
+			n.err = err
+			return
+		}
+	}
+}
+
+// handle decodes a buffer of inotify events and reports their paths.
+func (n *inotify) handle(buf []byte) error {
+	for len(buf) >= unix.SizeofInotifyEvent {
+		wd := int32(binary.NativeEndian.Uint32(buf[0:]))
+		mask := binary.NativeEndian.Uint32(buf[4:])
+		nameLen := int(binary.NativeEndian.Uint32(buf[12:]))
+		end := unix.SizeofInotifyEvent + nameLen
+		if end > len(buf) {
+			return errors.New("inotify: short event")
+		}
+		name := string(bytes.TrimRight(buf[unix.SizeofInotifyEvent:end], "\x00"))
+		buf = buf[end:]
+
+		dir, ok := n.dirs[wd]
+		path := n.root
+		switch {
+		case mask&unix.IN_Q_OVERFLOW != 0:
+			// Events were dropped; the rescan finds what they were.
+		case mask&unix.IN_IGNORED != 0:
+			delete(n.dirs, wd)
+			continue
+		case !ok:
+			continue
+		default:
+			path = filepath.Join(dir, name)
+		}
+		if mask&unix.IN_ISDIR != 0 && mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+			if err := n.addTree(path); err != nil {
+				return err
+			}
+		}
+		select {
+		case n.events <- path:
+		case <-n.done:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (n *inotify) Events() <-chan string { return n.events }
+
+func (n *inotify) Err() error { return n.err }
+
+func (n *inotify) Close() error {
+	var err error
+	n.once.Do(func() {
+		close(n.done)
+		err = n.f.Close()
+	})
+	return err
+}
@@ -0,0 +1,52 @@
+//go:build !linux
+
+package watch
+
+import (
+	"sync"
+	"time"
+)
+
+// pollInterval is how often the directory is rescanned without inotify.
+const pollInterval = time.Minute
+
+// poller reports a possible change every pollInterval; the rescan finds
+// out what, if anything, changed.
+type poller struct {
+	dir    string
+	events chan string
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newNotifier(dir string) (notifier, error) {
+	p := &poller{dir: dir, events: make(chan string), done: make(chan struct{})}
+	go p.run()
+	return p, nil
+}
+
+func (p *poller) run() {
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-t.C:
+			select {
+			case p.events <- p.dir:
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
+
+func (p *poller) Events() <-chan string { return p.events }
+
+func (p *poller) Err() error { return nil }
+
+func (p *poller) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
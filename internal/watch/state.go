@@ -0,0 +1,80 @@
+package watch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State is what a watcher remembers between runs, stored as
+// <state-dir>/<dir-name>-<hash>.json.
+type State struct {
+	Dir string `json:"dir"`
+	// LastObjectID is the newest object of the chain; the next upload is an
+	// increment on it.
+	LastObjectID string    `json:"last_object_id,omitempty"`
+	LastUpload   time.Time `json:"last_upload,omitzero"`
+	// Files is the index of the archive as of LastObjectID, by tar path.
+	Files map[string]Entry `json:"files,omitempty"`
+}
+
+// Entry is what the index keeps of a file to tell whether it changed.
+type Entry struct {
+	Size    int64       `json:"size"`
+	ModTime int64       `json:"mtime"` // Unix nanoseconds
+	Mode    fs.FileMode `json:"mode"`
+}
+
+func entryOf(info fs.FileInfo) Entry {
+	return Entry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Mode: info.Mode()}
+}
+
+// statePath returns the state file of dir, named so that directories with
+// the same base name don't collide.
+func statePath(stateDir, dir string) string {
+	sum := sha256.Sum256([]byte(dir))
+	return filepath.Join(stateDir, filepath.Base(dir)+"-"+hex.EncodeToString(sum[:4])+".json")
+}
+
+// loadState reads the state of dir; a directory never watched has an empty
+// State.
+func loadState(path, dir string) (*State, error) {
+	s := &State{Dir: dir}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read watch state: %w", err)
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("parse watch state %s: %w", path, err)
+	}
+	if s.Dir != dir {
+		return nil, fmt.Errorf("watch state %s belongs to %s", path, s.Dir)
+	}
+	return s, nil
+}
+
+// save writes the state through a temporary file, so a crash leaves the
+// previous state intact.
+func (s *State) save(path string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("write watch state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write watch state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+// Package watch backs up a directory continuously. The first upload holds
+// the whole directory; each later one is an increment holding only the
+// entries that changed since the previous upload, with the paths deleted
+// since recorded in its envelope.
+//
+// Filesystem notifications (inotify on Linux, polling elsewhere) only tell
+// the watcher that something changed. What changed is found by rescanning
+// the directory against the file index of the last upload, so events the
+// kernel drops and changes made while the watcher was not running are
+// picked up the same way.
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/lockfile"
+	"github.com/thebluefowl/burrow/internal/storage"
+	"github.com/thebluefowl/burrow/internal/upload"
+)
+
+// MetadataWatch is the envelope metadata key holding the watched directory.
+const MetadataWatch = "watch"
+
+const (
+	defaultDebounce    = 10 * time.Second
+	defaultMaxDelay    = 10 * time.Minute
+	defaultMinInterval = time.Minute
+)
+
+// Options controls when a Watcher uploads.
+type Options struct {
+	// Debounce is how long the directory must be quiet before a batch is
+	// uploaded; zero means 10s.
+	Debounce time.Duration
+	// MaxDelay bounds how long a batch waits for the directory to become
+	// quiet; zero means 10m.
+	MaxDelay time.Duration
+	// MinInterval is the least time between two uploads; zero means 1m.
+	MinInterval time.Duration
+	// StateDir holds the state and lock files.
+	StateDir string
+	Log      *log.Logger
+}
+
+// Watcher uploads the changes of one directory.
+type Watcher struct {
+	dir     string
+	config  *config.Config
+	keys    keyring.Keyring
+	storage storage.Storage
+	opts    Options
+
+	statePath string
+	state     *State
+	unlock    func()
+
+	// stateDir and stateName are the state directory and its tar path
+	// when it lies inside dir, or empty. Its changes are not backed up, or
+	// every upload would cause another.
+	stateDir, stateName string
+}
+
+// New creates a Watcher for dir, loading the state of earlier runs. It
+// locks the directory's state until Close.
+func New(cfg *config.Config, keys keyring.Keyring, storageClient storage.Storage, dir string, opts Options) (*Watcher, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("watch: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("watch: %s is not a directory", dir)
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = defaultDebounce
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = defaultMaxDelay
+	}
+	if opts.MinInterval <= 0 {
+		opts.MinInterval = defaultMinInterval
+	}
+	if err := os.MkdirAll(opts.StateDir, 0o700); err != nil {
+		return nil, fmt.Errorf("state directory: %w", err)
+	}
+
+	w := &Watcher{dir: dir, config: cfg, keys: keys, storage: storageClient, opts: opts}
+	if stateDir, err := filepath.Abs(opts.StateDir); err == nil {
+		if rel, err := filepath.Rel(dir, stateDir); err == nil && rel == "." {
+			return nil, fmt.Errorf("watch: the state directory can't be the watched directory")
+		} else if err == nil && filepath.IsLocal(rel) {
+			w.stateDir = stateDir
+			w.stateName = filepath.Base(dir) + "/" + filepath.ToSlash(rel)
+		}
+	}
+	w.statePath = statePath(opts.StateDir, dir)
+	w.unlock, err = lockfile.Lock(w.statePath + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("watch %s: %w", dir, err)
+	}
+	w.state, err = loadState(w.statePath, dir)
+	if err != nil {
+		w.unlock()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Close releases the directory's lock.
+func (w *Watcher) Close() {
+	w.unlock()
+}
+
+// State returns the watcher's state as of the last upload.
+func (w *Watcher) State() *State {
+	return w.state
+}
+
+// Run uploads the directory's changes until ctx is cancelled. It starts
+// with a rescan, so changes made while no watcher ran are uploaded first.
+func (w *Watcher) Run(ctx context.Context) error {
+	n, err := newNotifier(w.dir)
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+
+	// first and last are the times of the first and last change of the
+	// pending batch; a zero first means nothing is pending.
+	now := time.Now()
+	first, last := now, now.Add(-w.opts.Debounce)
+	var lastAttempt time.Time
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case path, ok := <-n.Events():
+			if !ok {
+				return n.Err()
+			}
+			if w.inStateDir(path) {
+				continue
+			}
+			last = time.Now()
+			if first.IsZero() {
+				first = last
+			}
+			timer.Reset(w.due(first, last, lastAttempt))
+
+		case <-timer.C:
+			if first.IsZero() {
+				continue
+			}
+			if d := w.due(first, last, lastAttempt); d > 0 {
+				timer.Reset(d)
+				continue
+			}
+			lastAttempt = time.Now()
+			if _, err := w.Sync(ctx); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				// Keep the batch; it is retried after MinInterval.
+				w.opts.Log.Printf("upload failed: %v", err)
+				timer.Reset(w.due(first, last, lastAttempt))
+				continue
+			}
+			first = time.Time{}
+		}
+	}
+}
+
+// inStateDir reports whether path is the state directory or below it.
+func (w *Watcher) inStateDir(path string) bool {
+	return w.stateDir != "" && (path == w.stateDir || strings.HasPrefix(path, w.stateDir+string(filepath.Separator)))
+}
+
+// due returns how long the pending batch still waits: until the directory
+// has been quiet for Debounce or the batch is MaxDelay old, and at least
+// MinInterval after the last upload.
+func (w *Watcher) due(first, last, lastAttempt time.Time) time.Duration {
+	wait := min(time.Until(last.Add(w.opts.Debounce)), time.Until(first.Add(w.opts.MaxDelay)))
+	if !lastAttempt.IsZero() {
+		wait = max(wait, time.Until(lastAttempt.Add(w.opts.MinInterval)))
+	}
+	return max(wait, 0)
+}
+
+// Sync rescans the directory and uploads what changed since the last
+// upload: everything the first time, an increment after that. It returns
+// the new object's ID, or "" if nothing changed.
+func (w *Watcher) Sync(ctx context.Context) (string, error) {
+	files := make(map[string]Entry)
+	changed := make(map[string]bool)
+	err := upload.Scan(ctx, w.config, []string{w.dir}, func(name string, info fs.FileInfo) {
+		if w.stateName != "" && (name == w.stateName || strings.HasPrefix(name, w.stateName+"/")) {
+			return
+		}
+		e := entryOf(info)
+		files[name] = e
+		if old, ok := w.state.Files[name]; !ok || old != e {
+			changed[name] = true
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("scan %s: %w", w.dir, err)
+	}
+	var deleted []string
+	for name := range w.state.Files {
+		if _, ok := files[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	slices.Sort(deleted)
+	if len(changed) == 0 && len(deleted) == 0 && w.state.LastObjectID != "" {
+		return "", nil
+	}
+
+	u := upload.NewUploader(w.config, w.keys, []string{w.dir}, w.storage)
+	u.SetMetadata(MetadataWatch, w.dir)
+	// Entries that appeared after the scan are left for the next batch, as
+	// the index doesn't have them yet.
+	u.SetSelect(func(name string, _ fs.FileInfo) bool { return changed[name] })
+	if w.state.LastObjectID != "" {
+		u.SetIncremental(w.state.LastObjectID, deleted)
+	}
+	if err := u.ExecuteContext(ctx); err != nil {
+		return "", err
+	}
+	for _, warning := range u.Warnings() {
+		w.opts.Log.Printf("warning: %v", warning)
+	}
+
+	if w.state.LastObjectID == "" {
+		w.opts.Log.Printf("uploaded %s: %d entries", u.ObjectID(), len(files))
+	} else {
+		w.opts.Log.Printf("uploaded %s: %d changed, %d deleted, on %s", u.ObjectID(), len(changed), len(deleted), w.state.LastObjectID)
+	}
+	w.state.LastObjectID = u.ObjectID()
+	w.state.LastUpload = time.Now()
+	w.state.Files = files
+	if err := w.state.save(w.statePath); err != nil {
+		return "", err
+	}
+	return u.ObjectID(), nil
+}
@@ -0,0 +1,56 @@
+package watch
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/thebluefowl/burrow/internal/keyring"
+)
+
+func TestRunUploadsChanges(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644)
+
+	cfg := newTestConfig(t)
+	s := &memStorage{objects: map[string][]byte{}}
+	w, err := New(cfg, keyring.NewLocal(cfg), s, dir, Options{
+		Debounce:    10 * time.Millisecond,
+		MinInterval: 10 * time.Millisecond,
+		StateDir:    t.TempDir(),
+		Log:         log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	waitFor := func(what string, ok func(*State) bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			// Run owns w's state; read what it saved.
+			if s, err := loadState(w.statePath, w.dir); err == nil && ok(s) {
+				return
+			}
+		}
+		t.Fatalf("timed out waiting for %s", what)
+	}
+	waitFor("the initial upload", func(s *State) bool { return s.LastObjectID != "" })
+	os.MkdirAll(filepath.Join(dir, "new"), 0o755)
+	os.WriteFile(filepath.Join(dir, "new", "b.txt"), []byte("b"), 0o644)
+	name := filepath.Base(dir) + "/new/b.txt"
+	waitFor("the change", func(s *State) bool { _, ok := s.Files[name]; return ok })
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,250 @@
+package watch
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thebluefowl/burrow/internal/config"
+	"github.com/thebluefowl/burrow/internal/download"
+	"github.com/thebluefowl/burrow/internal/enc"
+	"github.com/thebluefowl/burrow/internal/envelope"
+	"github.com/thebluefowl/burrow/internal/keyring"
+	"github.com/thebluefowl/burrow/internal/lockfile"
+	"github.com/thebluefowl/burrow/internal/storage"
+)
+
+// memStorage is an in-memory storage.Storage.
+type memStorage struct {
+	objects map[string][]byte
+}
+
+func (m *memStorage) Upload(_ context.Context, key string, body io.Reader, _ string, _ map[string]string) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	m.objects[key] = b
+	return nil
+}
+
+func (m *memStorage) Download(_ context.Context, key string, w io.Writer) (string, map[string]string, error) {
+	b, ok := m.objects[key]
+	if !ok {
+		return "", nil, storage.ErrNotFound
+	}
+	_, err := w.Write(b)
+	return "", nil, err
+}
+
+func (m *memStorage) GetMetadata(_ context.Context, key string) (map[string]string, error) {
+	if _, ok := m.objects[key]; !ok {
+		return nil, storage.ErrNotFound
+	}
+	return nil, nil
+}
+
+func (m *memStorage) List(_ context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	var out []storage.ObjectInfo
+	for k, v := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, storage.ObjectInfo{Key: k, Size: int64(len(v))})
+		}
+	}
+	return out, nil
+}
+
+func newTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	pub, priv, err := enc.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := make([]byte, 64)
+	rand.Read(master)
+	cfg := &config.Config{AgePublicKey: pub, AgePrivateKey: priv, MasterKey: master}
+	if _, err := cfg.EnsureSigningKey(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// objectNames returns the tar paths of an uploaded object.
+func objectNames(t *testing.T, cfg *config.Config, s storage.Storage, id string) []string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := download.NewStreamDownloader(cfg, keyring.NewLocal(cfg), id, &buf, false, s).Execute(); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestSync(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "docs")
+	stateDir := filepath.Join(dir, ".state")
+	os.MkdirAll(filepath.Join(dir, "sub"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("b"), 0o644)
+	os.WriteFile(filepath.Join(dir, "gone.txt"), []byte("x"), 0o644)
+
+	cfg := newTestConfig(t)
+	keys := keyring.NewLocal(cfg)
+	s := &memStorage{objects: map[string][]byte{}}
+	opts := Options{StateDir: stateDir, Log: log.New(io.Discard, "", 0)}
+	ctx := context.Background()
+
+	w, err := New(cfg, keys, s, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := w.Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(objectNames(t, cfg, s, base), ","); got != "docs/,docs/a.txt,docs/gone.txt,docs/sub/,docs/sub/b.txt" {
+		t.Errorf("base holds %s", got)
+	}
+	if id, err := w.Sync(ctx); err != nil || id != "" {
+		t.Fatalf("Sync() without changes = %q, %v", id, err)
+	}
+
+	// A second watcher of the same directory is refused.
+	if _, err := New(cfg, keys, s, dir, opts); !errors.Is(err, lockfile.ErrLocked) {
+		t.Fatalf("second New() error = %v, want ErrLocked", err)
+	}
+	w.Close()
+
+	// Changes made while no watcher runs are found by the rescan.
+	os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("bb"), 0o644)
+	os.WriteFile(filepath.Join(dir, "new.txt"), []byte("n"), 0o644)
+	os.Remove(filepath.Join(dir, "gone.txt"))
+	w, err = New(cfg, keys, s, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	inc, err := w.Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := strings.Join(objectNames(t, cfg, s, inc), ",")
+	if !strings.Contains(names, "docs/new.txt") || !strings.Contains(names, "docs/sub/b.txt") || strings.Contains(names, "a.txt") {
+		t.Errorf("increment holds %s", names)
+	}
+
+	env, err := envelope.Fetch(ctx, s, inc, keys.DecryptConfig(), envelope.Trust{Signers: cfg.Signers()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Incremental == nil || env.Incremental.Parent != base || strings.Join(env.Incremental.Deleted, ",") != "docs/gone.txt" {
+		t.Errorf("incremental = %+v, want parent %s and docs/gone.txt deleted", env.Incremental, base)
+	}
+	if env.Metadata[MetadataWatch] != dir {
+		t.Errorf("metadata = %v", env.Metadata)
+	}
+	if w.State().LastObjectID != inc {
+		t.Errorf("state head = %s, want %s", w.State().LastObjectID, inc)
+	}
+	if _, ok := w.State().Files["docs/.state"]; ok {
+		t.Error("the state directory inside the watched one was indexed")
+	}
+}
+
+func TestRestoreChain(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "docs")
+	os.MkdirAll(filepath.Join(dir, "old"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0o644)
+	os.WriteFile(filepath.Join(dir, "old", "c.txt"), []byte("c"), 0o644)
+	os.Symlink("a.txt", filepath.Join(dir, "link"))
+
+	cfg := newTestConfig(t)
+	keys := keyring.NewLocal(cfg)
+	s := &memStorage{objects: map[string][]byte{}}
+	opts := Options{StateDir: filepath.Join(root, "state"), Log: log.New(io.Discard, "", 0)}
+	ctx := context.Background()
+
+	w, err := New(cfg, keys, s, dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := w.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("bb"), 0o644)
+	os.RemoveAll(filepath.Join(dir, "old"))
+	os.WriteFile(filepath.Join(dir, "new.txt"), []byte("n"), 0o644)
+	if _, err := w.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The symlink becomes a file; the file it pointed to must not change.
+	os.Remove(filepath.Join(dir, "link"))
+	os.WriteFile(filepath.Join(dir, "link"), []byte("l"), 0o644)
+	os.Remove(filepath.Join(dir, "new.txt"))
+	head, err := w.Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	d := download.NewDownloader(cfg, keys, head, dest, true, false, s)
+	if err := d.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Warnings()) != 0 {
+		t.Errorf("warnings = %v", d.Warnings())
+	}
+	want := map[string]string{"a.txt": "a", "b.txt": "bb", "link": "l"}
+	got := make(map[string]string)
+	restored := filepath.Join(dest, "docs")
+	filepath.WalkDir(restored, func(path string, e fs.DirEntry, err error) error {
+		if err != nil || e.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(restored, path)
+		b, _ := os.ReadFile(path)
+		got[rel] = string(b)
+		return nil
+	})
+	if !maps.Equal(got, want) {
+		t.Errorf("restored %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(restored, "old")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("deleted directory is back: %v", err)
+	}
+
+	// Without --extract only the increment is written, with a warning.
+	d = download.NewDownloader(cfg, keys, head, t.TempDir(), false, false, s)
+	if err := d.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Warnings()) != 1 {
+		t.Errorf("warnings = %v, want one about the increment", d.Warnings())
+	}
+}